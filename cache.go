@@ -0,0 +1,110 @@
+package igpsportsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DetailCache stores activity details by RideID for GetActivityDetails.
+// Implementations must be safe for concurrent use.
+type DetailCache interface {
+	// Get returns the cached detail, false if missing or expired
+	Get(rideID int) (ActivityDetailData, bool)
+	// Set stores the detail; errors are passed to Config.OnCacheError
+	Set(rideID int, detail ActivityDetailData) error
+}
+
+type memoryDetailEntry struct {
+	detail  ActivityDetailData
+	expires time.Time
+}
+
+// MemoryDetailCache keeps details in memory for a fixed time
+type MemoryDetailCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int]memoryDetailEntry
+}
+
+// NewMemoryDetailCache creates a memory cache whose entries expire after
+// ttl, never if ttl is not positive
+func NewMemoryDetailCache(ttl time.Duration) *MemoryDetailCache {
+	return &MemoryDetailCache{ttl: ttl, entries: make(map[int]memoryDetailEntry)}
+}
+
+func (c *MemoryDetailCache) Get(rideID int) (ActivityDetailData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[rideID]
+	if !ok {
+		return ActivityDetailData{}, false
+	}
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		delete(c.entries, rideID)
+		return ActivityDetailData{}, false
+	}
+	return entry.detail, true
+}
+
+func (c *MemoryDetailCache) Set(rideID int, detail ActivityDetailData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := memoryDetailEntry{detail: detail}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	c.entries[rideID] = entry
+	return nil
+}
+
+// DiskDetailCache keeps details as <rideId>.json files in a directory, so
+// they survive restarts. Entries older than the TTL (by file modification
+// time) are ignored.
+type DiskDetailCache struct {
+	Dir string
+	TTL time.Duration // never expire if not positive
+}
+
+// NewDiskDetailCache creates the cache directory if needed
+func NewDiskDetailCache(dir string, ttl time.Duration) (*DiskDetailCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating cache directory: %v", err)
+	}
+	return &DiskDetailCache{Dir: dir, TTL: ttl}, nil
+}
+
+func (c *DiskDetailCache) path(rideID int) string {
+	return filepath.Join(c.Dir, strconv.Itoa(rideID)+".json")
+}
+
+func (c *DiskDetailCache) Get(rideID int) (ActivityDetailData, bool) {
+	var detail ActivityDetailData
+	path := c.path(rideID)
+	if c.TTL > 0 {
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) > c.TTL {
+			return detail, false
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return detail, false
+	}
+	if err := json.Unmarshal(data, &detail); err != nil {
+		return detail, false
+	}
+	return detail, true
+}
+
+func (c *DiskDetailCache) Set(rideID int, detail ActivityDetailData) error {
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(rideID), data)
+}
@@ -0,0 +1,449 @@
+package igpsportsync
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The catalog is a local SQLite mirror of the activity list, activity
+// details, stored files and analytics summaries. It works with any
+// database/sql SQLite driver; the command uses the pure-Go modernc.org/sqlite.
+
+const catalogSchema = `
+CREATE TABLE IF NOT EXISTS catalog_activities (
+	ride_id INTEGER NOT NULL PRIMARY KEY,
+	title TEXT NOT NULL DEFAULT '',
+	ride_distance REAL NOT NULL DEFAULT 0,
+	start_time TEXT NOT NULL DEFAULT '',
+	product_name TEXT NOT NULL DEFAULT '',
+	moving_time INTEGER,
+	total_time INTEGER,
+	total_ascent INTEGER,
+	device_name TEXT,
+	detail TEXT,
+	updated_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS catalog_activities_start_time ON catalog_activities (start_time);
+CREATE TABLE IF NOT EXISTS catalog_files (
+	ride_id INTEGER NOT NULL,
+	format TEXT NOT NULL,
+	path TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	sha256 TEXT NOT NULL,
+	stored_at TEXT NOT NULL,
+	PRIMARY KEY (ride_id, format)
+);
+CREATE TABLE IF NOT EXISTS catalog_summaries (
+	ride_id INTEGER NOT NULL PRIMARY KEY,
+	summary TEXT NOT NULL,
+	updated_at TEXT NOT NULL
+);
+`
+
+// catalogTimeLayout keeps start times sortable as text
+const catalogTimeLayout = "2006-01-02 15:04:05"
+
+// CatalogFile is a stored activity file
+type CatalogFile struct {
+	RideID   int
+	Format   string
+	Path     string
+	Size     int64
+	SHA256   string
+	StoredAt time.Time
+}
+
+// CatalogSummary holds the analytics of an activity kept in the catalog.
+// Values that could not be computed are zero.
+type CatalogSummary struct {
+	Duration         time.Duration `json:"duration"`
+	AveragePower     float64       `json:"averagePower"`
+	MaxPower         float64       `json:"maxPower"`
+	NormalizedPower  float64       `json:"normalizedPower"`
+	IntensityFactor  float64       `json:"intensityFactor"`
+	TSS              float64       `json:"tss"`
+	AverageHeartRate float64       `json:"averageHeartRate"`
+	MaxHeartRate     float64       `json:"maxHeartRate"`
+	TRIMP            float64       `json:"trimp"`
+	HrTSS            float64       `json:"hrTss"`
+}
+
+// NewCatalogSummary flattens power and heart rate analyses, either may be nil
+func NewCatalogSummary(power *PowerSummary, hr *HeartRateSummary) CatalogSummary {
+	var s CatalogSummary
+	if power != nil {
+		s.Duration = power.Duration
+		s.AveragePower, s.MaxPower = power.AveragePower, power.MaxPower
+		s.NormalizedPower, s.IntensityFactor, s.TSS = power.NormalizedPower, power.IntensityFactor, power.TSS
+	}
+	if hr != nil {
+		if s.Duration == 0 {
+			s.Duration = hr.Duration
+		}
+		s.AverageHeartRate, s.MaxHeartRate = hr.AverageHeartRate, hr.MaxHeartRate
+		s.TRIMP, s.HrTSS = hr.TRIMP, hr.HrTSS
+	}
+	return s
+}
+
+// CatalogEntry is everything the catalog knows about one activity
+type CatalogEntry struct {
+	Row ActivityRow
+
+	// Detail is nil until the activity detail has been stored
+	Detail *ActivityDetailData
+
+	Files []CatalogFile
+
+	// Summary is nil until the activity has been analyzed
+	Summary *CatalogSummary
+}
+
+// CatalogQuery selects catalog entries. Zero values disable a condition.
+type CatalogQuery struct {
+	// From and To bound the start time (From inclusive, To exclusive),
+	// compared as wall time in the catalog's location
+	From time.Time
+	To   time.Time
+
+	MinDistance float64
+	MaxDistance float64
+
+	// Device matches a case-insensitive substring of the product or device name
+	Device string
+
+	// Text matches a case-insensitive substring of the title
+	Text string
+
+	// Limit caps the number of results, newest first
+	Limit int
+}
+
+// Catalog is a local SQLite activity catalog
+type Catalog struct {
+	db  *sql.DB
+	loc *time.Location
+}
+
+// NewCatalog creates the catalog tables if needed. Start times without a
+// zone are read as wall time in loc (time.Local if nil).
+func NewCatalog(db *sql.DB, loc *time.Location) (*Catalog, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	if _, err := db.Exec(catalogSchema); err != nil {
+		return nil, fmt.Errorf("error creating catalog schema: %v", err)
+	}
+	return &Catalog{db: db, loc: loc}, nil
+}
+
+// normalizeTime rewrites an API start time in the sortable catalog layout,
+// keeping unparseable values as they are
+func (c *Catalog) normalizeTime(value string) string {
+	t, err := ParseActivityTime(value, c.loc)
+	if err != nil {
+		return value
+	}
+	return t.In(c.loc).Format(catalogTimeLayout)
+}
+
+func catalogNow() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// PutRow inserts or updates an activity from the activity list, keeping any
+// stored detail
+func (c *Catalog) PutRow(row ActivityRow) error {
+	_, err := c.db.Exec(`INSERT INTO catalog_activities (ride_id, title, ride_distance, start_time, product_name, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ride_id) DO UPDATE SET title = excluded.title, ride_distance = excluded.ride_distance,
+			start_time = excluded.start_time, product_name = excluded.product_name, updated_at = excluded.updated_at`,
+		row.RideID, row.Title, row.RideDistance, c.normalizeTime(row.StartTime), row.ProductName, catalogNow())
+	if err != nil {
+		return fmt.Errorf("error storing activity %d: %v", row.RideID, err)
+	}
+	return nil
+}
+
+// PutDetail stores the activity detail, creating the activity if needed
+func (c *Catalog) PutDetail(detail ActivityDetailData) error {
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`INSERT INTO catalog_activities (ride_id, title, ride_distance, start_time,
+			moving_time, total_time, total_ascent, device_name, detail, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ride_id) DO UPDATE SET title = excluded.title, ride_distance = excluded.ride_distance,
+			start_time = excluded.start_time, moving_time = excluded.moving_time, total_time = excluded.total_time,
+			total_ascent = excluded.total_ascent, device_name = excluded.device_name, detail = excluded.detail,
+			updated_at = excluded.updated_at`,
+		detail.RideId, detail.Title, detail.RideDistance, c.normalizeTime(detail.StartTime),
+		detail.MovingTime, detail.TotalTime, detail.TotalAscent, detail.DeviceInfo.DeviceName, string(data), catalogNow())
+	if err != nil {
+		return fmt.Errorf("error storing activity detail %d: %v", detail.RideId, err)
+	}
+	return nil
+}
+
+// PutFile records a stored file, creating the activity from the manifest
+// entry if it is not in the catalog yet
+func (c *Catalog) PutFile(entry ManifestEntry, path string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT OR IGNORE INTO catalog_activities (ride_id, title, start_time, updated_at) VALUES (?, ?, ?, ?)`,
+		entry.RideID, entry.Title, c.normalizeTime(entry.StartTime), catalogNow())
+	if err != nil {
+		return fmt.Errorf("error storing activity %d: %v", entry.RideID, err)
+	}
+	_, err = tx.Exec(`INSERT OR REPLACE INTO catalog_files (ride_id, format, path, size, sha256, stored_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.RideID, entry.Format, path, entry.Size, entry.SHA256, entry.DownloadedAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("error storing file of activity %d: %v", entry.RideID, err)
+	}
+	return tx.Commit()
+}
+
+// PutSummary stores the analytics summary of an activity
+func (c *Catalog) PutSummary(rideID int, summary CatalogSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`INSERT OR REPLACE INTO catalog_summaries (ride_id, summary, updated_at) VALUES (?, ?, ?)`,
+		rideID, string(data), catalogNow())
+	if err != nil {
+		return fmt.Errorf("error storing summary of activity %d: %v", rideID, err)
+	}
+	return nil
+}
+
+// Analyze decodes a downloaded activity and stores its summary. Power and
+// heart rate are analyzed when present; an activity with neither is an error.
+func (c *Catalog) Analyze(activity *DownloadedActivity, ftp int, hr HeartRateOptions) error {
+	if activity.Error != nil {
+		return activity.Error
+	}
+	track, err := DecodeTrack(activity.Data, activity.Extension)
+	if err != nil {
+		return err
+	}
+	power, _ := AnalyzePower(track, ftp)
+	heartRate, _ := AnalyzeHeartRate(track, hr)
+	if power == nil && heartRate == nil {
+		return fmt.Errorf("activity %d has no power or heart rate data", activity.RideID)
+	}
+	return c.PutSummary(activity.RideID, NewCatalogSummary(power, heartRate))
+}
+
+// Callback returns a DownloadCallback that stores the attached row and
+// detail (see DownloadOptions.IncludeDetail) and analyzes every successfully
+// downloaded activity into the catalog, then calls next (if not nil).
+// Failures, including activities without power or heart rate data, are
+// joined and passed to report (if not nil) and do not stop the download.
+// Use DiskSink.Catalog to record the stored files.
+func (c *Catalog) Callback(ftp int, hr HeartRateOptions, report func(rideID int, err error), next DownloadCallback) DownloadCallback {
+	return func(activity *DownloadedActivity) bool {
+		var errs []error
+		if activity.Row != nil {
+			errs = append(errs, c.PutRow(*activity.Row))
+		}
+		if activity.Detail != nil {
+			errs = append(errs, c.PutDetail(*activity.Detail))
+		}
+		if activity.Error == nil {
+			errs = append(errs, c.Analyze(activity, ftp, hr))
+		}
+		if err := errors.Join(errs...); err != nil && report != nil {
+			report(activity.RideID, err)
+		}
+		if next == nil {
+			return true
+		}
+		return next(activity)
+	}
+}
+
+// Delete removes an activity with its files and summary
+func (c *Catalog) Delete(rideID int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"catalog_files", "catalog_summaries", "catalog_activities"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE ride_id = ?`, rideID); err != nil {
+			return fmt.Errorf("error deleting activity %d: %v", rideID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// HasDetail reports whether the activity detail is stored
+func (c *Catalog) HasDetail(rideID int) (bool, error) {
+	var n int
+	err := c.db.QueryRow(`SELECT COUNT(*) FROM catalog_activities WHERE ride_id = ? AND detail IS NOT NULL`, rideID).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("error querying catalog: %v", err)
+	}
+	return n > 0, nil
+}
+
+// Get returns the catalog entry of an activity, or nil if it is unknown
+func (c *Catalog) Get(rideID int) (*CatalogEntry, error) {
+	entries, err := c.query(`WHERE a.ride_id = ?`, []any{rideID}, "")
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+// Query returns the entries matching q, newest first
+func (c *Catalog) Query(q CatalogQuery) ([]CatalogEntry, error) {
+	var conditions []string
+	var args []any
+	if !q.From.IsZero() {
+		conditions = append(conditions, "a.start_time >= ?")
+		args = append(args, q.From.In(c.loc).Format(catalogTimeLayout))
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, "a.start_time < ?")
+		args = append(args, q.To.In(c.loc).Format(catalogTimeLayout))
+	}
+	if q.MinDistance > 0 {
+		conditions = append(conditions, "a.ride_distance >= ?")
+		args = append(args, q.MinDistance)
+	}
+	if q.MaxDistance > 0 {
+		conditions = append(conditions, "a.ride_distance <= ?")
+		args = append(args, q.MaxDistance)
+	}
+	if q.Device != "" {
+		conditions = append(conditions, "(instr(lower(a.product_name), lower(?)) > 0 OR instr(lower(coalesce(a.device_name, '')), lower(?)) > 0)")
+		args = append(args, q.Device, q.Device)
+	}
+	if q.Text != "" {
+		conditions = append(conditions, "instr(lower(a.title), lower(?)) > 0")
+		args = append(args, q.Text)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	limit := ""
+	if q.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+	return c.query(where, args, limit)
+}
+
+func (c *Catalog) query(where string, args []any, limit string) ([]CatalogEntry, error) {
+	rows, err := c.db.Query(`SELECT a.ride_id, a.title, a.ride_distance, a.start_time, a.product_name, a.detail, s.summary
+		FROM catalog_activities a LEFT JOIN catalog_summaries s ON s.ride_id = a.ride_id
+		`+where+` ORDER BY a.start_time DESC, a.ride_id DESC`+limit, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying catalog: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []CatalogEntry
+	index := make(map[int]int)
+	for rows.Next() {
+		var e CatalogEntry
+		var detail, summary sql.NullString
+		err := rows.Scan(&e.Row.RideID, &e.Row.Title, &e.Row.RideDistance, &e.Row.StartTime, &e.Row.ProductName, &detail, &summary)
+		if err != nil {
+			return nil, fmt.Errorf("error reading catalog: %v", err)
+		}
+		if detail.Valid {
+			e.Detail = &ActivityDetailData{}
+			if err := json.Unmarshal([]byte(detail.String), e.Detail); err != nil {
+				return nil, fmt.Errorf("error decoding detail of activity %d: %v", e.Row.RideID, err)
+			}
+		}
+		if summary.Valid {
+			e.Summary = &CatalogSummary{}
+			if err := json.Unmarshal([]byte(summary.String), e.Summary); err != nil {
+				return nil, fmt.Errorf("error decoding summary of activity %d: %v", e.Row.RideID, err)
+			}
+		}
+		index[e.Row.RideID] = len(entries)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]any, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.Row.RideID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	files, err := c.db.Query(`SELECT ride_id, format, path, size, sha256, stored_at FROM catalog_files
+		WHERE ride_id IN (`+placeholders+`) ORDER BY ride_id, format`, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying catalog files: %v", err)
+	}
+	defer files.Close()
+	for files.Next() {
+		var f CatalogFile
+		var storedAt string
+		if err := files.Scan(&f.RideID, &f.Format, &f.Path, &f.Size, &f.SHA256, &storedAt); err != nil {
+			return nil, fmt.Errorf("error reading catalog files: %v", err)
+		}
+		f.StoredAt, _ = time.Parse(time.RFC3339, storedAt)
+		if i, ok := index[f.RideID]; ok {
+			entries[i].Files = append(entries[i].Files, f)
+		}
+	}
+	return entries, files.Err()
+}
+
+// SyncCatalog stores the activity list between beginTime and endTime in the
+// catalog. With withDetail the details of activities without one are fetched
+// with GetActivityDetails. It returns the number of listed activities.
+func (s *IgpsportSync) SyncCatalog(c *Catalog, beginTime, endTime string, withDetail bool) (int, error) {
+	rows, err := s.ListAllActivities(beginTime, endTime)
+	if err != nil {
+		return 0, err
+	}
+	var missing []int
+	for _, row := range rows {
+		if err := c.PutRow(row); err != nil {
+			return 0, err
+		}
+		if !withDetail {
+			continue
+		}
+		known, err := c.HasDetail(row.RideID)
+		if err != nil {
+			return 0, err
+		}
+		if !known {
+			missing = append(missing, row.RideID)
+		}
+	}
+	if len(missing) == 0 {
+		return len(rows), nil
+	}
+
+	details, fetchErr := s.GetActivityDetails(context.Background(), missing)
+	for _, detail := range details {
+		if err := c.PutDetail(detail); err != nil {
+			return 0, err
+		}
+	}
+	return len(rows), fetchErr
+}
@@ -0,0 +1,1070 @@
+// Command igpsport-sync downloads iGPSport activities to a local directory.
+//
+// Credentials are read from the IGPSPORT_USERNAME and IGPSPORT_PASSWORD
+// environment variables. "download -storage s3" reads the bucket from
+// S3_ENDPOINT, S3_REGION, S3_BUCKET, S3_PREFIX, AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY. "upload -to strava" reads STRAVA_CLIENT_ID,
+// STRAVA_CLIENT_SECRET and, until a token file exists, STRAVA_REFRESH_TOKEN.
+// "upload -to intervals" reads INTERVALS_API_KEY and, optionally,
+// INTERVALS_ATHLETE_ID.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+	_ "modernc.org/sqlite"
+)
+
+const usage = `usage: igpsport-sync <command> [flags]
+
+commands:
+  download   download activities into a directory
+  verify     re-hash downloaded files against the manifest
+  duplicates list suspected duplicate rides
+  runningpage export downloaded files to a running_page database
+  sanitize   write copies of downloaded files with private GPS points removed
+  load       print daily training load (CTL/ATL/TSB) of downloaded files
+  stats      print weekly, monthly or yearly totals, year over year and streaks
+  catalog    sync or query the local SQLite activity catalog
+  reconcile  find rides deleted or edited on iGPSport and mirror them locally
+  upload     upload downloaded files to a training platform
+  garmin     bundle downloaded files for the Garmin Connect importer
+  redeliver  retry failed webhook notifications of downloads
+  serve      serve the catalog and downloaded files over a local REST API
+  report     write a static HTML report with maps, charts and a riding heatmap
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "download":
+		err = runDownload(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	case "duplicates":
+		err = runDuplicates(os.Args[2:])
+	case "runningpage":
+		err = runRunningPage(os.Args[2:])
+	case "sanitize":
+		err = runSanitize(os.Args[2:])
+	case "load":
+		err = runLoad(os.Args[2:])
+	case "stats":
+		err = runStats(os.Args[2:])
+	case "catalog":
+		err = runCatalog(os.Args[2:])
+	case "reconcile":
+		err = runReconcile(os.Args[2:])
+	case "upload":
+		err = runUpload(os.Args[2:])
+	case "garmin":
+		err = runGarmin(os.Args[2:])
+	case "redeliver":
+		err = runRedeliver(os.Args[2:])
+	case "serve":
+		err = runServe(os.Args[2:])
+	case "report":
+		err = runReport(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newClient() (*igpsportsync.IgpsportSync, error) {
+	return igpsportsync.New(igpsportsync.Config{
+		Username: os.Getenv("IGPSPORT_USERNAME"),
+		Password: os.Getenv("IGPSPORT_PASSWORD"),
+	})
+}
+
+func runDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	dir := fs.String("dir", "activities", "output directory")
+	format := fs.String("format", "fit", "file format: fit, gpx or tcx")
+	begin := fs.String("begin", "", "begin date (2006-01-02)")
+	end := fs.String("end", "", "end date (2006-01-02)")
+	concurrency := fs.Int("concurrency", 5, "number of concurrent downloads")
+	manifest := fs.Bool("manifest", true, "write "+igpsportsync.MANIFEST_FILE+" into the output directory")
+	catalogPath := fs.String("catalog", "", "also update this SQLite activity catalog")
+	detail := fs.Bool("detail", false, "fetch each activity's detail along with the file")
+	ids := fs.String("ids", "", "comma separated ride ids to download instead of listing")
+	showProgress := fs.Bool("progress", false, "show a progress bar instead of a line per activity")
+	skipSynced := fs.Bool("skip-synced", false, "skip activities already in the manifest of the output directory")
+	plan := fs.Bool("plan", false, "only print what would be downloaded, skipped or deleted")
+	storageKind := fs.String("storage", "dir", "where files go: dir (the output directory) or s3")
+	webhooksPath := fs.String("webhooks", "", `JSON file with webhooks notified of every downloaded activity: [{"url": .., "secret": ..}]`)
+	fs.Parse(args)
+
+	ext, err := igpsportsync.ParseExtension(*format)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	storage, err := openStorage(*storageKind)
+	if err != nil {
+		return err
+	}
+
+	// the bucket itself tells what is synced, the manifest may be elsewhere
+	var state igpsportsync.SyncState
+	if (*skipSynced || *plan) && storage != nil {
+		if state, err = igpsportsync.NewStorageState(context.Background(), storage); err != nil {
+			return err
+		}
+	} else if *skipSynced || *plan {
+		if state, err = igpsportsync.LoadManifestState(filepath.Join(*dir, igpsportsync.MANIFEST_FILE)); err != nil {
+			return err
+		}
+	}
+	if *plan {
+		return printPlan(client, igpsportsync.DownloadOptions{
+			Extension:     ext,
+			BeginTime:     *begin,
+			EndTime:       *end,
+			IncludeDetail: *detail || *catalogPath != "",
+			State:         state,
+		})
+	}
+
+	sink, err := igpsportsync.NewDiskSink(*dir, *manifest)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+	if storage != nil {
+		sink.Storage = storage
+	}
+
+	callback := func(activity *igpsportsync.DownloadedActivity) bool {
+		if activity.Error != nil {
+			if *showProgress {
+				fmt.Fprint(os.Stderr, "\r\033[K")
+			}
+			fmt.Printf("✗ %d: %v\n", activity.RideID, activity.Error)
+			return true
+		}
+		if !*showProgress {
+			fmt.Printf("✓ %d: %s (%d bytes)\n", activity.RideID, activity.Title, len(activity.Data))
+		}
+		return true
+	}
+
+	var catalog *igpsportsync.Catalog
+	if *catalogPath != "" {
+		db, err := sql.Open("sqlite", *catalogPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if catalog, err = igpsportsync.NewCatalog(db, time.Local); err != nil {
+			return err
+		}
+		userInfo, err := client.GetUserInfo()
+		if err != nil {
+			return err
+		}
+		hr := igpsportsync.HeartRateOptionsFromProfile(userInfo.Data, igpsportsync.HeartRateOptions{})
+		sink.Catalog = catalog
+		callback = catalog.Callback(userInfo.Data.Ftp, hr, func(rideID int, err error) {
+			fmt.Fprintf(os.Stderr, "catalog %d: %v\n", rideID, err)
+		}, callback)
+	}
+
+	// Ctrl-C stops the download, keeping what was saved so far
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if *webhooksPath != "" {
+		webhooks, err := loadWebhooks(*webhooksPath)
+		if err != nil {
+			return err
+		}
+		userInfo, err := client.GetUserInfo()
+		if err != nil {
+			return err
+		}
+		notifier := igpsportsync.NewNotifier(igpsportsync.NotifierConfig{
+			Webhooks:       webhooks,
+			DeadLetterPath: filepath.Join(*dir, deadLetterFile),
+			FTP:            userInfo.Data.Ftp,
+			HeartRate:      igpsportsync.HeartRateOptionsFromProfile(userInfo.Data, igpsportsync.HeartRateOptions{}),
+		})
+		// runs before cancel, so Ctrl-C also cuts the pending deliveries short
+		defer notifier.Close()
+		callback = notifier.Callback(ctx, func(rideID int, err error) {
+			fmt.Fprintf(os.Stderr, "notify %d: %v\n", rideID, err)
+		}, callback)
+	}
+
+	options := igpsportsync.DownloadOptions{
+		Extension:      ext,
+		BeginTime:      *begin,
+		EndTime:        *end,
+		MaxConcurrency: *concurrency,
+		IncludeDetail:  *detail,
+		State:          state,
+		Sink:           sink,
+		Callback:       callback,
+	}
+	if *showProgress {
+		options.Progress = func(progress igpsportsync.DownloadProgress) {
+			fmt.Fprint(os.Stderr, "\r\033[K"+progressBar(progress, 30))
+		}
+	}
+
+	var summary igpsportsync.DownloadSummary
+	if *ids != "" {
+		var rideIDs []int
+		for _, field := range strings.Split(*ids, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return fmt.Errorf("invalid ride id %q", field)
+			}
+			rideIDs = append(rideIDs, id)
+		}
+		summary, err = client.DownloadActivities(ctx, rideIDs, options)
+		printSummary(summary, *showProgress)
+		return err
+	}
+
+	summary, err = client.DownloadAllActivitiesContext(ctx, options)
+	printSummary(summary, *showProgress)
+	if err != nil || catalog == nil {
+		return err
+	}
+	_, err = client.SyncCatalog(catalog, *begin, *end, false)
+	return err
+}
+
+// printPlan prints the plan of a download, one line per activity that
+// would be downloaded or deleted, and the totals
+func printPlan(client *igpsportsync.IgpsportSync, options igpsportsync.DownloadOptions) error {
+	plan, err := client.PlanDownload(context.Background(), options)
+	if err != nil {
+		return err
+	}
+	for _, item := range plan.Items {
+		if item.Action == igpsportsync.PLAN_DOWNLOAD || item.Action == igpsportsync.PLAN_DELETE {
+			fmt.Printf("%-8s %d %s %s\n", item.Action, item.RideID, item.StartTime, item.Title)
+		}
+	}
+	fmt.Printf("download: %d, synced: %d, filtered: %d, duplicates: %d, delete: %d, ~%d requests\n",
+		plan.Counts[igpsportsync.PLAN_DOWNLOAD], plan.Counts[igpsportsync.PLAN_SYNCED],
+		plan.Counts[igpsportsync.PLAN_FILTERED], plan.Counts[igpsportsync.PLAN_DUPLICATE],
+		plan.Counts[igpsportsync.PLAN_DELETE], plan.Requests)
+	return nil
+}
+
+// printSummary prints the outcome of a download, first clearing the
+// progress bar if one is shown
+func printSummary(summary igpsportsync.DownloadSummary, clearProgress bool) {
+	if clearProgress {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	fmt.Printf("succeeded: %d, failed: %d, skipped: %d in %v",
+		summary.Succeeded, summary.Failed, summary.Skipped, summary.Duration.Round(time.Millisecond))
+	if summary.StoppedEarly {
+		fmt.Print(" (stopped early)")
+	}
+	fmt.Println()
+}
+
+// progressBar renders a download progress as one terminal line
+func progressBar(progress igpsportsync.DownloadProgress, width int) string {
+	filled := int(progress.Percent() / 100 * float64(width))
+	line := fmt.Sprintf("[%s%s] %d/%d %5.1f%% %s/s",
+		strings.Repeat("#", filled), strings.Repeat("-", width-filled),
+		progress.Completed, progress.Total, progress.Percent(), formatBytes(progress.Throughput))
+	if progress.ETA > 0 {
+		line += " ETA " + progress.ETA.Round(time.Second).String()
+	}
+	if progress.Failed > 0 {
+		line += fmt.Sprintf(" %d failed", progress.Failed)
+	}
+	return line
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[unit])
+}
+
+// openStorage returns the storage selected by a -storage flag, nil for
+// the output directory. s3 is configured from the environment.
+func openStorage(kind string) (igpsportsync.Storage, error) {
+	switch kind {
+	case "dir":
+		return nil, nil
+	case "s3":
+		return igpsportsync.NewS3Storage(igpsportsync.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Prefix:    os.Getenv("S3_PREFIX"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown storage %q", kind)
+}
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	dir := fs.String("dir", "activities", "directory containing "+igpsportsync.MANIFEST_FILE)
+	storageKind := fs.String("storage", "dir", "where the files are: dir (the manifest directory) or s3")
+	fs.Parse(args)
+
+	storage, err := openStorage(*storageKind)
+	if err != nil {
+		return err
+	}
+	report, err := igpsportsync.VerifyManifest(context.Background(), filepath.Join(*dir, igpsportsync.MANIFEST_FILE), storage)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range report.Problems() {
+		fmt.Printf("%-9s %d.%s %s\n", result.Status, result.Entry.RideID, result.Entry.Format, result.Detail)
+	}
+	fmt.Printf("ok: %d, missing: %d, corrupted: %d, failed downloads: %d\n",
+		report.OK, report.Missing, report.Corrupted, report.Failed)
+
+	if report.Missing > 0 || report.Corrupted > 0 {
+		return fmt.Errorf("verification failed")
+	}
+	return nil
+}
+
+func runDuplicates(args []string) error {
+	fs := flag.NewFlagSet("duplicates", flag.ExitOnError)
+	begin := fs.String("begin", "", "begin date (2006-01-02)")
+	end := fs.String("end", "", "end date (2006-01-02)")
+	detail := fs.Bool("detail", false, "fetch activity detail to compare durations")
+	dir := fs.String("dir", "", "also compare content hashes from the manifest in this directory")
+	fs.Parse(args)
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	rows, err := client.ListAllActivities(*begin, *end)
+	if err != nil {
+		return err
+	}
+	// keep the earliest upload of a ride as the original
+	sort.Slice(rows, func(i, j int) bool { return rows[i].RideID < rows[j].RideID })
+
+	dedup := igpsportsync.NewDeduplicator(igpsportsync.DedupOptions{})
+	for _, row := range rows {
+		fp := dedup.FingerprintRow(row)
+		if *detail {
+			resp, err := client.GetActivityDetail(row.RideID)
+			if err != nil {
+				return err
+			}
+			fp = dedup.FingerprintDetail(resp.Data)
+		}
+		dedup.Check(fp)
+	}
+
+	if *dir != "" {
+		entries, err := igpsportsync.ReadManifest(filepath.Join(*dir, igpsportsync.MANIFEST_FILE))
+		if err != nil {
+			return err
+		}
+		for _, entry := range igpsportsync.LatestManifestEntries(entries) {
+			if entry.SHA256 != "" {
+				dedup.CheckHash(entry.RideID, entry.SHA256)
+			}
+		}
+	}
+
+	pairs := dedup.Pairs()
+	for _, pair := range pairs {
+		fmt.Printf("%d duplicates %d (%s)\n", pair.RideID, pair.DuplicateOf, pair.Reason)
+	}
+	fmt.Printf("%d suspected duplicates among %d activities\n", len(pairs), len(rows))
+	return nil
+}
+
+func runRunningPage(args []string) error {
+	fs := flag.NewFlagSet("runningpage", flag.ExitOnError)
+	dir := fs.String("dir", "activities", "directory containing downloaded files and "+igpsportsync.MANIFEST_FILE)
+	dbPath := fs.String("db", "run_page/data.db", "running_page SQLite database")
+	jsonPath := fs.String("json", "src/static/activities.json", "running_page activities.json")
+	tz := fs.String("tz", "Local", "rider time zone for start_date_local")
+	fs.Parse(args)
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return err
+	}
+	entries, err := igpsportsync.ReadManifest(filepath.Join(*dir, igpsportsync.MANIFEST_FILE))
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite", *dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	exporter, err := igpsportsync.NewRunningPageExporter(db)
+	if err != nil {
+		return err
+	}
+
+	exported := 0
+	for _, entry := range igpsportsync.LatestManifestEntries(entries) {
+		if entry.File == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(*dir, entry.File))
+		if err != nil {
+			return err
+		}
+		ext, err := igpsportsync.ParseExtension(entry.Format)
+		if err != nil {
+			return err
+		}
+		track, err := igpsportsync.DecodeTrack(data, ext)
+		if err != nil {
+			fmt.Printf("✗ %d: %v\n", entry.RideID, err)
+			continue
+		}
+		if err := exporter.Upsert(igpsportsync.NewRunningPageActivity(entry.RideID, entry.Title, track, loc)); err != nil {
+			return err
+		}
+		exported++
+	}
+
+	if err := exporter.WriteJSON(*jsonPath); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d activities\n", exported)
+	return nil
+}
+
+func runSanitize(args []string) error {
+	fs := flag.NewFlagSet("sanitize", flag.ExitOnError)
+	dir := fs.String("dir", "activities", "directory containing downloaded files and "+igpsportsync.MANIFEST_FILE)
+	out := fs.String("out", "public", "output directory for sanitized copies")
+	zonesPath := fs.String("zones", "", `JSON file with privacy zones: [{"lat": .., "lng": .., "radius": ..}]`)
+	trimStart := fs.Float64("trim-start", 0, "meters removed from the start of every ride")
+	trimEnd := fs.Float64("trim-end", 0, "meters removed from the end of every ride")
+	fs.Parse(args)
+
+	options := igpsportsync.PrivacyOptions{TrimStart: *trimStart, TrimEnd: *trimEnd}
+	if *zonesPath != "" {
+		data, err := os.ReadFile(*zonesPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &options.Zones); err != nil {
+			return fmt.Errorf("error parsing zones: %v", err)
+		}
+	}
+
+	entries, err := igpsportsync.ReadManifest(filepath.Join(*dir, igpsportsync.MANIFEST_FILE))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+
+	for _, entry := range igpsportsync.LatestManifestEntries(entries) {
+		if entry.File == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(*dir, entry.File))
+		if err != nil {
+			return err
+		}
+		ext, err := igpsportsync.ParseExtension(entry.Format)
+		if err != nil {
+			return err
+		}
+		sanitized, err := igpsportsync.SanitizeActivity(data, ext, options)
+		if err != nil {
+			fmt.Printf("✗ %d: %v\n", entry.RideID, err)
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(*out, entry.File), sanitized, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("✓ %s\n", entry.File)
+	}
+	return nil
+}
+
+func runLoad(args []string) error {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	dir := fs.String("dir", "activities", "directory containing downloaded files and "+igpsportsync.MANIFEST_FILE)
+	cache := fs.String("cache", "", "training load cache file (default <dir>/training_load.json)")
+	from := fs.String("from", time.Now().AddDate(0, 0, -igpsportsync.CTL_DAYS).Format("2006-01-02"), "first day")
+	to := fs.String("to", time.Now().Format("2006-01-02"), "last day")
+	ftp := fs.Int("ftp", 0, "FTP in watts (default from profile)")
+	maxHR := fs.Int("max-hr", 0, "max heart rate (default from profile)")
+	lthr := fs.Int("lthr", 0, "lactate threshold heart rate (default from profile)")
+	restingHR := fs.Int("resting-hr", 0, "resting heart rate")
+	fs.Parse(args)
+
+	if *cache == "" {
+		*cache = filepath.Join(*dir, "training_load.json")
+	}
+	load, err := igpsportsync.OpenTrainingLoad(*cache)
+	if err != nil {
+		return err
+	}
+	entries, err := igpsportsync.ReadManifest(filepath.Join(*dir, igpsportsync.MANIFEST_FILE))
+	if err != nil {
+		return err
+	}
+
+	hr := igpsportsync.HeartRateOptions{MaxHR: *maxHR, LTHR: *lthr, RestingHR: *restingHR}
+	profileLoaded := *ftp > 0 && *maxHR > 0 && *lthr > 0
+	for _, entry := range igpsportsync.LatestManifestEntries(entries) {
+		if entry.File == "" || load.Has(entry.RideID) {
+			continue
+		}
+		if !profileLoaded {
+			// only log in when there are new activities and missing reference values
+			client, err := newClient()
+			if err != nil {
+				return err
+			}
+			userInfo, err := client.GetUserInfo()
+			if err != nil {
+				return err
+			}
+			if *ftp == 0 {
+				*ftp = userInfo.Data.Ftp
+			}
+			hr = igpsportsync.HeartRateOptionsFromProfile(userInfo.Data, hr)
+			profileLoaded = true
+		}
+
+		data, err := os.ReadFile(filepath.Join(*dir, entry.File))
+		if err != nil {
+			return err
+		}
+		ext, err := igpsportsync.ParseExtension(entry.Format)
+		if err != nil {
+			return err
+		}
+		activity := &igpsportsync.DownloadedActivity{RideID: entry.RideID, Title: entry.Title, StartTime: entry.StartTime, Data: data, Extension: ext}
+		if err := load.AddActivity(activity, *ftp, hr); err != nil {
+			fmt.Printf("✗ %d: %v\n", entry.RideID, err)
+		}
+	}
+	if err := load.Save(); err != nil {
+		return err
+	}
+
+	series, err := load.Series(*from, *to)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-10s %6s %6s %6s %6s\n", "date", "TSS", "CTL", "ATL", "TSB")
+	for _, day := range series {
+		fmt.Printf("%-10s %6.0f %6.1f %6.1f %6.1f\n", day.Date, day.TSS, day.CTL, day.ATL, day.TSB)
+	}
+	return nil
+}
+
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	begin := fs.String("begin", "", "begin date (2006-01-02)")
+	end := fs.String("end", "", "end date (2006-01-02)")
+	period := fs.String("period", "month", "bucket size: week, month or year")
+	detail := fs.Bool("detail", false, "fetch activity detail for moving time and ascent")
+	tz := fs.String("tz", "Local", "rider time zone")
+	fs.Parse(args)
+
+	periods := map[string]igpsportsync.StatsPeriod{
+		"week":  igpsportsync.PERIOD_WEEK,
+		"month": igpsportsync.PERIOD_MONTH,
+		"year":  igpsportsync.PERIOD_YEAR,
+	}
+	p, ok := periods[*period]
+	if !ok {
+		return fmt.Errorf("unknown period %q", *period)
+	}
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	rides, err := client.ListRideStats(*begin, *end, *detail, loc)
+	if err != nil {
+		return err
+	}
+
+	options := igpsportsync.StatsOptions{Location: loc}
+	fmt.Printf("%-8s %5s %9s %9s %7s %9s %6s\n", "period", "rides", "km", "moving", "ascent", "longest", "km/h")
+	for _, b := range igpsportsync.ComputeStats(rides, p, options) {
+		fmt.Printf("%-8s %5d %9.1f %9s %7.0f %9.1f %6.1f\n", b.Label, b.Rides, b.Distance/1000,
+			b.MovingTime.Round(time.Minute), b.Ascent, b.LongestRide.Distance/1000, b.AverageSpeed*3.6)
+	}
+
+	now := time.Now()
+	yoy := igpsportsync.CompareYears(rides, now, options)
+	fmt.Printf("\n%s to date: %d rides, %.1f km (%+.0f%% vs %s)\n", yoy.Current.Label, yoy.Current.Rides,
+		yoy.Current.Distance/1000, yoy.DistanceChange, yoy.Previous.Label)
+	streaks := igpsportsync.Streaks(rides, now, options)
+	fmt.Printf("streaks: %d days (longest %d), %d weeks (longest %d)\n", streaks.CurrentDays.Length,
+		streaks.LongestDays.Length, streaks.CurrentWeeks.Length, streaks.LongestWeeks.Length)
+	return nil
+}
+
+const catalogUsage = `usage: igpsport-sync catalog <sync|query> [flags]
+`
+
+func runCatalog(args []string) error {
+	if len(args) < 1 || (args[0] != "sync" && args[0] != "query") {
+		fmt.Fprint(os.Stderr, catalogUsage)
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("catalog "+args[0], flag.ExitOnError)
+	dbPath := fs.String("db", "catalog.db", "SQLite catalog")
+	tz := fs.String("tz", "Local", "rider time zone")
+	// sync
+	dir := fs.String("dir", "", "also import files recorded in the manifest in this directory")
+	begin := fs.String("begin", "", "begin date (2006-01-02)")
+	end := fs.String("end", "", "end date (2006-01-02)")
+	detail := fs.Bool("detail", false, "fetch the detail of activities without one")
+	// query
+	from := fs.String("from", "", "first day (2006-01-02)")
+	to := fs.String("to", "", "last day (2006-01-02)")
+	minDistance := fs.Float64("min-distance", 0, "minimum distance in meters")
+	maxDistance := fs.Float64("max-distance", 0, "maximum distance in meters")
+	device := fs.String("device", "", "device name substring")
+	text := fs.String("text", "", "title substring")
+	limit := fs.Int("limit", 0, "maximum number of results")
+	fs.Parse(args[1:])
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", *dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	catalog, err := igpsportsync.NewCatalog(db, loc)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "sync" {
+		client, err := newClient()
+		if err != nil {
+			return err
+		}
+		n, err := client.SyncCatalog(catalog, *begin, *end, *detail)
+		if err != nil {
+			return err
+		}
+		if *dir != "" {
+			entries, err := igpsportsync.ReadManifest(filepath.Join(*dir, igpsportsync.MANIFEST_FILE))
+			if err != nil {
+				return err
+			}
+			for _, entry := range igpsportsync.LatestManifestEntries(entries) {
+				if entry.File == "" {
+					continue
+				}
+				if err := catalog.PutFile(entry, filepath.Join(*dir, entry.File)); err != nil {
+					return err
+				}
+			}
+		}
+		fmt.Printf("synced %d activities\n", n)
+		return nil
+	}
+
+	q := igpsportsync.CatalogQuery{MinDistance: *minDistance, MaxDistance: *maxDistance, Device: *device, Text: *text, Limit: *limit}
+	if *from != "" {
+		if q.From, err = time.ParseInLocation("2006-01-02", *from, loc); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if q.To, err = time.ParseInLocation("2006-01-02", *to, loc); err != nil {
+			return err
+		}
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+	entries, err := catalog.Query(q)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		file := ""
+		if len(e.Files) > 0 {
+			file = e.Files[0].Path
+		}
+		fmt.Printf("%d\t%s\t%.1f km\t%s\t%s\t%s\n", e.Row.RideID, e.Row.StartTime, e.Row.RideDistance/1000, e.Row.ProductName, e.Row.Title, file)
+	}
+	return nil
+}
+
+func runReconcile(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	dir := fs.String("dir", "activities", "directory containing "+igpsportsync.MANIFEST_FILE)
+	begin := fs.String("begin", "", "begin date (2006-01-02) of the rides to compare")
+	end := fs.String("end", "", "end date (2006-01-02) of the rides to compare")
+	deleted := fs.String("deleted", "report", "deleted rides: report, delete or trash")
+	edited := fs.String("edited", "report", "edited rides: report or rename")
+	trash := fs.String("trash", "", "trash directory (default <dir>/.trash)")
+	force := fs.Bool("force", false, "remove deleted rides even when most stored rides are missing from the listing")
+	catalogPath := fs.String("catalog", "", "also update this SQLite activity catalog")
+	fs.Parse(args)
+
+	options := igpsportsync.ReconcileOptions{BeginTime: *begin, EndTime: *end, TrashDir: *trash, Force: *force}
+	var err error
+	if options.Deleted, err = igpsportsync.ParseReconcileAction(*deleted); err != nil {
+		return err
+	}
+	if options.Edited, err = igpsportsync.ParseReconcileAction(*edited); err != nil {
+		return err
+	}
+
+	sink, err := igpsportsync.NewDiskSink(*dir, true)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+	if *catalogPath != "" {
+		db, err := sql.Open("sqlite", *catalogPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if sink.Catalog, err = igpsportsync.NewCatalog(db, time.Local); err != nil {
+			return err
+		}
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	changes, err := client.Reconcile(context.Background(), sink, options)
+	for _, change := range changes {
+		entry := change.Entries[0]
+		switch change.Kind {
+		case igpsportsync.CHANGE_DELETED:
+			fmt.Printf("%-7s %-7s %d %s %s\n", change.Kind, change.Action, change.RideID, entry.StartTime, entry.Title)
+		case igpsportsync.CHANGE_EDITED:
+			fmt.Printf("%-7s %-7s %d %s %q -> %s %q\n", change.Kind, change.Action, change.RideID,
+				entry.StartTime, entry.Title, change.Row.StartTime, change.Row.Title)
+		}
+	}
+	fmt.Printf("%d changes\n", len(changes))
+	return err
+}
+
+func runUpload(args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	dir := fs.String("dir", "activities", "directory containing "+igpsportsync.MANIFEST_FILE)
+	to := fs.String("to", "strava", "destination: strava or intervals")
+	logPath := fs.String("log", "", "upload log (default <dir>/uploads.jsonl)")
+	tokenPath := fs.String("token", "strava-token.json", "file keeping the refreshed Strava token")
+	fs.Parse(args)
+
+	uploader, err := newUploader(*to, *tokenPath)
+	if err != nil {
+		return err
+	}
+	if *logPath == "" {
+		*logPath = filepath.Join(*dir, "uploads.jsonl")
+	}
+	log, err := igpsportsync.OpenUploadLog(*logPath)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+	state, err := igpsportsync.LoadManifestState(filepath.Join(*dir, igpsportsync.MANIFEST_FILE))
+	if err != nil {
+		return err
+	}
+	entries, err := state.Activities()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	return igpsportsync.UploadStored(ctx, uploader, log, &igpsportsync.LocalStorage{Dir: *dir}, entries,
+		func(result igpsportsync.UploadResult, err error) {
+			switch {
+			case err != nil:
+				fmt.Printf("✗ %v\n", err)
+			case result.Duplicate:
+				fmt.Printf("= %d: already on %s as %s\n", result.RideID, result.Destination, result.RemoteID)
+			default:
+				fmt.Printf("✓ %d: %s activity %s\n", result.RideID, result.Destination, result.RemoteID)
+			}
+		})
+}
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "listen address")
+	dbPath := fs.String("db", "catalog.db", "SQLite catalog")
+	dir := fs.String("dir", "activities", "directory containing the downloaded files")
+	tz := fs.String("tz", "Local", "rider time zone")
+	token := fs.String("token", os.Getenv("IGPSPORT_API_TOKEN"), "bearer token required by the API (default $IGPSPORT_API_TOKEN)")
+	me := fs.Bool("me", false, "fetch the rider profile once for /me")
+	fs.Parse(args)
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", *dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	catalog, err := igpsportsync.NewCatalog(db, loc)
+	if err != nil {
+		return err
+	}
+	config := igpsportsync.ServerConfig{
+		Catalog: catalog,
+		Storage: &igpsportsync.LocalStorage{Dir: *dir},
+		Token:   *token,
+	}
+	if *me {
+		client, err := newClient()
+		if err != nil {
+			return err
+		}
+		userInfo, err := client.GetUserInfo()
+		if err != nil {
+			return err
+		}
+		config.Profile = &userInfo.Data
+	}
+
+	server := &http.Server{Addr: *addr, Handler: igpsportsync.NewServer(config)}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	fmt.Printf("serving on http://%s\n", *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	dir := fs.String("dir", "activities", "directory containing downloaded files and "+igpsportsync.MANIFEST_FILE)
+	out := fs.String("out", "report", "output directory")
+	title := fs.String("title", "", "title of the index page")
+	tz := fs.String("tz", "Local", "rider time zone")
+	zonesPath := fs.String("zones", "", `JSON file with privacy zones cut from the maps: [{"lat": .., "lng": .., "radius": ..}]`)
+	catalogPath := fs.String("catalog", "", "SQLite activity catalog giving the distance of rides without samples")
+	fs.Parse(args)
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return err
+	}
+	options := igpsportsync.ReportOptions{Title: *title, Location: loc}
+	if *zonesPath != "" {
+		data, err := os.ReadFile(*zonesPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &options.Route.PrivacyZones); err != nil {
+			return fmt.Errorf("error parsing zones: %v", err)
+		}
+	}
+	if *catalogPath != "" {
+		db, err := sql.Open("sqlite", *catalogPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		catalog, err := igpsportsync.NewCatalog(db, loc)
+		if err != nil {
+			return err
+		}
+		catalogEntries, err := catalog.Query(igpsportsync.CatalogQuery{})
+		if err != nil {
+			return err
+		}
+		for _, entry := range catalogEntries {
+			options.Rows = append(options.Rows, entry.Row)
+		}
+	}
+	state, err := igpsportsync.LoadManifestState(filepath.Join(*dir, igpsportsync.MANIFEST_FILE))
+	if err != nil {
+		return err
+	}
+	entries, err := state.Activities()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	activities, err := igpsportsync.WriteReport(ctx, &igpsportsync.LocalStorage{Dir: *dir}, entries, *out, options)
+	fmt.Printf("wrote %d activities to %s\n", len(activities), filepath.Join(*out, "index.html"))
+	return err
+}
+
+// deadLetterFile receives failed webhook deliveries in the download directory
+const deadLetterFile = "webhooks_failed.jsonl"
+
+func loadWebhooks(path string) ([]igpsportsync.Webhook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []igpsportsync.Webhook
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		return nil, fmt.Errorf("error parsing webhooks: %v", err)
+	}
+	return webhooks, nil
+}
+
+func runRedeliver(args []string) error {
+	fs := flag.NewFlagSet("redeliver", flag.ExitOnError)
+	dir := fs.String("dir", "activities", "download directory containing "+deadLetterFile)
+	webhooksPath := fs.String("webhooks", "webhooks.json", "JSON file with the webhooks")
+	fs.Parse(args)
+
+	webhooks, err := loadWebhooks(*webhooksPath)
+	if err != nil {
+		return err
+	}
+	notifier := igpsportsync.NewNotifier(igpsportsync.NotifierConfig{
+		Webhooks:       webhooks,
+		DeadLetterPath: filepath.Join(*dir, deadLetterFile),
+	})
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	delivered, err := notifier.Redeliver(ctx)
+	fmt.Printf("redelivered %d notifications\n", delivered)
+	return err
+}
+
+func runGarmin(args []string) error {
+	fs := flag.NewFlagSet("garmin", flag.ExitOnError)
+	dir := fs.String("dir", "activities", "directory containing "+igpsportsync.MANIFEST_FILE)
+	out := fs.String("out", "garmin", "output directory for the zip bundles")
+	maxSize := fs.Int64("max-size", igpsportsync.GARMIN_BUNDLE_SIZE>>20, "size limit of each zip in MiB")
+	product := fs.Uint("product", igpsportsync.GARMIN_PRODUCT_EDGE_530, "FIT product id written to the files")
+	fs.Parse(args)
+
+	state, err := igpsportsync.LoadManifestState(filepath.Join(*dir, igpsportsync.MANIFEST_FILE))
+	if err != nil {
+		return err
+	}
+	entries, err := state.Activities()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	exported, err := igpsportsync.ExportGarmin(ctx, &igpsportsync.LocalStorage{Dir: *dir}, entries, *out,
+		igpsportsync.GarminExportOptions{Product: uint16(*product), MaxBundleSize: *maxSize << 20})
+	if err != nil {
+		return err
+	}
+	bundles := make(map[string]bool)
+	for _, entry := range exported {
+		bundles[entry.Bundle] = true
+	}
+	fmt.Printf("exported %d activities in %d bundles to %s\n", len(exported), len(bundles), *out)
+	return nil
+}
+
+// newUploader configures an uploader from the environment
+func newUploader(name, tokenPath string) (igpsportsync.Uploader, error) {
+	switch name {
+	case "strava":
+		token := igpsportsync.StravaToken{RefreshToken: os.Getenv("STRAVA_REFRESH_TOKEN")}
+		if data, err := os.ReadFile(tokenPath); err == nil {
+			if err := json.Unmarshal(data, &token); err != nil {
+				return nil, fmt.Errorf("invalid token file: %v", err)
+			}
+		}
+		if token.RefreshToken == "" {
+			return nil, fmt.Errorf("STRAVA_REFRESH_TOKEN is required")
+		}
+		return igpsportsync.NewStravaUploader(igpsportsync.StravaConfig{
+			ClientID:     os.Getenv("STRAVA_CLIENT_ID"),
+			ClientSecret: os.Getenv("STRAVA_CLIENT_SECRET"),
+			Token:        token,
+			OnToken: func(token igpsportsync.StravaToken) error {
+				data, err := json.Marshal(token)
+				if err != nil {
+					return err
+				}
+				return os.WriteFile(tokenPath, data, 0o600)
+			},
+		}), nil
+	case "intervals":
+		key := os.Getenv("INTERVALS_API_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("INTERVALS_API_KEY is required")
+		}
+		return igpsportsync.NewIntervalsUploader(igpsportsync.IntervalsConfig{
+			APIKey:    key,
+			AthleteID: os.Getenv("INTERVALS_ATHLETE_ID"),
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown destination %q", name)
+}
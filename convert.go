@@ -0,0 +1,155 @@
+package igpsportsync
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Conversion of activity files. iGPSport serves the recorded FIT file only
+// (getDownloadUrl and the detail's fitUrl), so GPX and TCX downloads are
+// encoded from the decoded track.
+
+// ConvertActivity converts activity file data to the ext format (FIT when
+// empty). Data already in that format is returned unchanged. GPX and TCX
+// are encoded from the samples of the decoded track; a FIT file cannot be
+// produced from another format.
+func ConvertActivity(data []byte, ext Extension) ([]byte, error) {
+	if ext == "" {
+		ext = FIT
+	}
+	from, ok := DetectExtension(data)
+	if !ok {
+		return nil, fmt.Errorf("unrecognized activity file format")
+	}
+	if from == ext {
+		return data, nil
+	}
+	if ext == FIT {
+		return nil, fmt.Errorf("cannot convert %s to fit", from.Name())
+	}
+	track, err := DecodeTrack(data, from)
+	if err != nil {
+		return nil, err
+	}
+	if ext == GPX {
+		return encodeGPX(track), nil
+	}
+	return encodeTCX(track), nil
+}
+
+func xmlText(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func xmlTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func encodeGPX(track *Track) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="igpsport-sync" xmlns="http://www.topografix.com/GPX/1/1" xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v1">
+  <trk>
+`)
+	if track.Sport != "" {
+		fmt.Fprintf(&b, "    <type>%s</type>\n", xmlText(track.Sport))
+	}
+	b.WriteString("    <trkseg>\n")
+	for _, p := range track.Points {
+		// GPX points need a position
+		if !p.HasPosition {
+			continue
+		}
+		fmt.Fprintf(&b, `      <trkpt lat="%.7f" lon="%.7f">`, p.Lat, p.Lon)
+		if p.HasAltitude {
+			fmt.Fprintf(&b, "<ele>%.1f</ele>", p.Altitude)
+		}
+		if !p.Time.IsZero() {
+			fmt.Fprintf(&b, "<time>%s</time>", xmlTime(p.Time))
+		}
+		if p.HasPower || p.HeartRate > 0 || p.Cadence > 0 {
+			b.WriteString("<extensions>")
+			if p.HasPower {
+				fmt.Fprintf(&b, "<power>%d</power>", p.Power)
+			}
+			if p.HeartRate > 0 || p.Cadence > 0 {
+				b.WriteString("<gpxtpx:TrackPointExtension>")
+				if p.HeartRate > 0 {
+					fmt.Fprintf(&b, "<gpxtpx:hr>%d</gpxtpx:hr>", p.HeartRate)
+				}
+				if p.Cadence > 0 {
+					fmt.Fprintf(&b, "<gpxtpx:cad>%d</gpxtpx:cad>", p.Cadence)
+				}
+				b.WriteString("</gpxtpx:TrackPointExtension>")
+			}
+			b.WriteString("</extensions>")
+		}
+		b.WriteString("</trkpt>\n")
+	}
+	b.WriteString("    </trkseg>\n  </trk>\n</gpx>\n")
+	return b.Bytes()
+}
+
+// tcxSports maps track sports to the TCX Sport attribute
+var tcxSports = map[string]string{"cycling": "Biking", "e_biking": "Biking", "running": "Running"}
+
+func encodeTCX(track *Track) []byte {
+	sport, ok := tcxSports[track.Sport]
+	if !ok {
+		sport = "Other"
+	}
+	start := track.StartTime()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2" xmlns:ns3="http://www.garmin.com/xmlschemas/ActivityExtension/v2">
+  <Activities>
+    <Activity Sport="%s">
+      <Id>%s</Id>
+      <Lap StartTime="%s">
+        <TotalTimeSeconds>%s</TotalTimeSeconds>
+        <DistanceMeters>%s</DistanceMeters>
+        <Track>
+`, sport, xmlTime(start), xmlTime(start), strconv.FormatFloat(track.ElapsedTime().Seconds(), 'f', 0, 64), formatMeters(track.Distance()))
+	for _, p := range track.Points {
+		b.WriteString("          <Trackpoint>")
+		if !p.Time.IsZero() {
+			fmt.Fprintf(&b, "<Time>%s</Time>", xmlTime(p.Time))
+		}
+		if p.HasPosition {
+			fmt.Fprintf(&b, "<Position><LatitudeDegrees>%.7f</LatitudeDegrees><LongitudeDegrees>%.7f</LongitudeDegrees></Position>", p.Lat, p.Lon)
+		}
+		if p.HasAltitude {
+			fmt.Fprintf(&b, "<AltitudeMeters>%.1f</AltitudeMeters>", p.Altitude)
+		}
+		fmt.Fprintf(&b, "<DistanceMeters>%s</DistanceMeters>", formatMeters(p.Distance))
+		if p.HeartRate > 0 {
+			fmt.Fprintf(&b, "<HeartRateBpm><Value>%d</Value></HeartRateBpm>", p.HeartRate)
+		}
+		if p.Cadence > 0 {
+			fmt.Fprintf(&b, "<Cadence>%d</Cadence>", p.Cadence)
+		}
+		if p.Speed > 0 || p.HasPower {
+			b.WriteString("<Extensions><ns3:TPX>")
+			if p.Speed > 0 {
+				fmt.Fprintf(&b, "<ns3:Speed>%.3f</ns3:Speed>", p.Speed)
+			}
+			if p.HasPower {
+				fmt.Fprintf(&b, "<ns3:Watts>%d</ns3:Watts>", p.Power)
+			}
+			b.WriteString("</ns3:TPX></Extensions>")
+		}
+		b.WriteString("</Trackpoint>\n")
+	}
+	b.WriteString(`        </Track>
+      </Lap>
+    </Activity>
+  </Activities>
+</TrainingCenterDatabase>
+`)
+	return b.Bytes()
+}
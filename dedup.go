@@ -0,0 +1,203 @@
+package igpsportsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupOptions configures the tolerances used to decide that two activities
+// are the same ride. Zero values fall back to the defaults below.
+type DedupOptions struct {
+	// StartTolerance is the maximum difference between start times (default 2 minutes)
+	StartTolerance time.Duration
+
+	// DurationTolerance is the maximum difference between total times,
+	// only compared when both durations are known (default 2 minutes)
+	DurationTolerance time.Duration
+
+	// DistanceTolerance is the maximum relative distance difference,
+	// e.g. 0.02 for 2% (default 0.02)
+	DistanceTolerance float64
+
+	// Location is used to parse start times without a zone (default time.Local)
+	Location *time.Location
+}
+
+func (o DedupOptions) withDefaults() DedupOptions {
+	if o.StartTolerance <= 0 {
+		o.StartTolerance = 2 * time.Minute
+	}
+	if o.DurationTolerance <= 0 {
+		o.DurationTolerance = 2 * time.Minute
+	}
+	if o.DistanceTolerance <= 0 {
+		o.DistanceTolerance = 0.02
+	}
+	return o
+}
+
+// ActivityFingerprint holds the values used for metadata based duplicate detection
+type ActivityFingerprint struct {
+	RideID    int
+	StartTime time.Time
+	Duration  time.Duration // zero when unknown
+	Distance  float64
+	Device    string // recording device name, empty when unknown
+}
+
+// DuplicateReason tells how a duplicate was detected
+type DuplicateReason string
+
+const (
+	DUPLICATE_METADATA DuplicateReason = "metadata" // start time, duration and distance match
+	DUPLICATE_CONTENT  DuplicateReason = "content"  // identical file content
+)
+
+// DuplicatePair reports RideID as a suspected duplicate of the earlier DuplicateOf
+type DuplicatePair struct {
+	RideID      int
+	DuplicateOf int
+	Reason      DuplicateReason
+}
+
+// Deduplicator detects duplicate activities as they flow through the
+// listing/download pipeline. The first activity seen is kept; later ones
+// matching it are reported as duplicates. Activities with the same RideID
+// (e.g. the same ride fetched in several formats) are never duplicates of
+// each other. It is safe for concurrent use.
+type Deduplicator struct {
+	options DedupOptions
+
+	mu      sync.Mutex
+	seen    []ActivityFingerprint
+	verdict map[int]int    // RideID -> RideID it duplicates, 0 if original
+	hashes  map[string]int // content hash -> RideID
+	pairs   []DuplicatePair
+}
+
+// NewDeduplicator creates a Deduplicator with the given tolerances
+func NewDeduplicator(options DedupOptions) *Deduplicator {
+	return &Deduplicator{
+		options: options.withDefaults(),
+		verdict: make(map[int]int),
+		hashes:  make(map[string]int),
+	}
+}
+
+// FingerprintRow builds a fingerprint from a listing row; the duration is unknown
+func (d *Deduplicator) FingerprintRow(row ActivityRow) ActivityFingerprint {
+	start, _ := ParseActivityTime(row.StartTime, d.options.Location)
+	return ActivityFingerprint{RideID: row.RideID, StartTime: start, Distance: row.RideDistance, Device: row.ProductName}
+}
+
+// FingerprintDetail builds a fingerprint from activity detail, including the duration
+func (d *Deduplicator) FingerprintDetail(detail ActivityDetailData) ActivityFingerprint {
+	start, _ := ParseActivityTime(detail.StartTime, d.options.Location)
+	return ActivityFingerprint{
+		RideID:    detail.RideId,
+		StartTime: start,
+		Duration:  time.Duration(detail.TotalTime) * time.Second,
+		Distance:  float64(detail.RideDistance),
+		Device:    detail.DeviceInfo.DeviceName,
+	}
+}
+
+// Check records the fingerprint and returns the RideID it duplicates, if any
+func (d *Deduplicator) Check(fp ActivityFingerprint) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if original, ok := d.verdict[fp.RideID]; ok {
+		return original, original != 0
+	}
+	for _, other := range d.seen {
+		if d.matches(fp, other) {
+			d.verdict[fp.RideID] = other.RideID
+			d.pairs = append(d.pairs, DuplicatePair{RideID: fp.RideID, DuplicateOf: other.RideID, Reason: DUPLICATE_METADATA})
+			return other.RideID, true
+		}
+	}
+	d.verdict[fp.RideID] = 0
+	d.seen = append(d.seen, fp)
+	return 0, false
+}
+
+// CheckRow is a shorthand for Check(d.FingerprintRow(row))
+func (d *Deduplicator) CheckRow(row ActivityRow) (int, bool) {
+	return d.Check(d.FingerprintRow(row))
+}
+
+// CheckContent records the SHA-256 of data and returns the RideID of an
+// earlier activity with identical content, if any
+func (d *Deduplicator) CheckContent(rideID int, data []byte) (int, bool) {
+	sum := sha256.Sum256(data)
+	return d.CheckHash(rideID, hex.EncodeToString(sum[:]))
+}
+
+// CheckHash is like CheckContent for an already computed hex SHA-256,
+// e.g. from a ManifestEntry
+func (d *Deduplicator) CheckHash(rideID int, hash string) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	original, ok := d.hashes[hash]
+	if !ok {
+		d.hashes[hash] = rideID
+		return 0, false
+	}
+	if original == rideID {
+		return 0, false
+	}
+	d.pairs = append(d.pairs, DuplicatePair{RideID: rideID, DuplicateOf: original, Reason: DUPLICATE_CONTENT})
+	return original, true
+}
+
+// Pairs returns the duplicates found so far, in detection order
+func (d *Deduplicator) Pairs() []DuplicatePair {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DuplicatePair(nil), d.pairs...)
+}
+
+func (d *Deduplicator) matches(a, b ActivityFingerprint) bool {
+	if a.StartTime.IsZero() || b.StartTime.IsZero() {
+		return false
+	}
+	if absDuration(a.StartTime.Sub(b.StartTime)) > d.options.StartTolerance {
+		return false
+	}
+	if a.Duration > 0 && b.Duration > 0 && absDuration(a.Duration-b.Duration) > d.options.DurationTolerance {
+		return false
+	}
+	longest := math.Max(a.Distance, b.Distance)
+	if longest == 0 {
+		// without distance (trainer rides, manual entries) the start time
+		// alone is too weak: require a matching duration or device
+		if a.Duration > 0 && b.Duration > 0 {
+			return true
+		}
+		return a.Device != "" && strings.EqualFold(a.Device, b.Device)
+	}
+	return math.Abs(a.Distance-b.Distance)/longest <= d.options.DistanceTolerance
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// FindDuplicates reports suspected duplicate pairs among the fingerprints.
+// Fingerprints should be ordered so that the ride to keep comes first.
+func FindDuplicates(fingerprints []ActivityFingerprint, options DedupOptions) []DuplicatePair {
+	d := NewDeduplicator(options)
+	for _, fp := range fingerprints {
+		d.Check(fp)
+	}
+	return d.Pairs()
+}
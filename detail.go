@@ -1,20 +1,31 @@
 package igpsportsync
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 )
 
 const ACTIVITY_DETAIL_URL = ACTIVITY_URL + "queryActivityDetail/" // + ride_id
 
+// DEFAULT_DETAIL_CONCURRENCY is the default number of concurrent
+// GetActivityDetails requests
+const DEFAULT_DETAIL_CONCURRENCY = 8
+
 func (s *IgpsportSync) GetActivityDetail(ride_id int) (*ActivityDetailResponse, error) {
+	return s.getActivityDetail(context.Background(), ride_id)
+}
+
+func (s *IgpsportSync) getActivityDetail(ctx context.Context, ride_id int) (*ActivityDetailResponse, error) {
 	// Build URL with ride_id as path parameter (not query parameter)
-	url := ACTIVITY_DETAIL_URL + strconv.Itoa(ride_id)
+	url := s.url(ACTIVITY_DETAIL_URL) + strconv.Itoa(ride_id)
 
 	// Create HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating activity detail request: %v", err)
 	}
@@ -43,3 +54,95 @@ func (s *IgpsportSync) GetActivityDetail(ride_id int) (*ActivityDetailResponse,
 
 	return &detailResp, nil
 }
+
+// GetActivityDetails fetches the detail of several activities, running at
+// most Config.DetailConcurrency requests at a time. Details found in
+// Config.DetailCache are not requested again and fetched ones are added
+// to it. Duplicate ids are fetched once.
+//
+// The returned map holds every detail that could be fetched; the error
+// joins the failures of the others. Cancelling ctx stops pending requests.
+func (s *IgpsportSync) GetActivityDetails(ctx context.Context, ids []int) (map[int]ActivityDetailData, error) {
+	details := make(map[int]ActivityDetailData, len(ids))
+	seen := make(map[int]bool, len(ids))
+	var missing []int
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if s.Config.DetailCache != nil {
+			if detail, ok := s.Config.DetailCache.Get(id); ok {
+				details[id] = detail
+				continue
+			}
+		}
+		missing = append(missing, id)
+	}
+
+	concurrency := s.Config.DetailConcurrency
+	if concurrency <= 0 {
+		concurrency = DEFAULT_DETAIL_CONCURRENCY
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, id := range missing {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("activity %d: %w", id, ctx.Err()))
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			detail, err := s.fetchActivityDetail(ctx, id)
+			mu.Lock()
+			defer mu.Unlock()
+			if detail != nil {
+				details[id] = *detail
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("activity %d: %w", id, err))
+			}
+		}(id)
+	}
+	wg.Wait()
+	return details, errors.Join(errs...)
+}
+
+// activityDetail returns the detail from Config.DetailCache, or fetches and
+// caches it
+func (s *IgpsportSync) activityDetail(ctx context.Context, id int) (*ActivityDetailData, error) {
+	if s.Config.DetailCache != nil {
+		if detail, ok := s.Config.DetailCache.Get(id); ok {
+			return &detail, nil
+		}
+	}
+	return s.fetchActivityDetail(ctx, id)
+}
+
+// fetchActivityDetail requests the detail and adds it to Config.DetailCache.
+// The cache is best effort: a failed write only means the detail is
+// requested again next time, so it is passed to Config.OnCacheError
+// instead of failing the fetch.
+func (s *IgpsportSync) fetchActivityDetail(ctx context.Context, id int) (*ActivityDetailData, error) {
+	resp, err := s.getActivityDetail(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if s.Config.DetailCache != nil {
+		if err := s.Config.DetailCache.Set(id, resp.Data); err != nil && s.Config.OnCacheError != nil {
+			s.Config.OnCacheError(id, fmt.Errorf("error caching detail: %w", err))
+		}
+	}
+	return &resp.Data, nil
+}
@@ -1,18 +1,27 @@
 package igpsportsync
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// GetActivityDownloadUrl returns the download url of the FIT file of an
+// activity, the only format iGPSport serves (see ConvertActivity)
 func (s *IgpsportSync) GetActivityDownloadUrl(ride_id int) (*string, error) {
+	return s.getActivityDownloadUrl(context.Background(), ride_id)
+}
+
+func (s *IgpsportSync) getActivityDownloadUrl(ctx context.Context, ride_id int) (*string, error) {
 	// Build URL with ride_id as path parameter (not query parameter)
-	url := DOWNLOAD_URL + strconv.Itoa(ride_id)
-	req, err := http.NewRequest("GET", url, nil)
+	url := s.url(DOWNLOAD_URL) + strconv.Itoa(ride_id)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +56,11 @@ func (s *IgpsportSync) GetActivityDownloadUrl(ride_id int) (*string, error) {
 
 // download file from url
 func (s *IgpsportSync) DownloadFile(url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	return s.downloadFile(context.Background(), url)
+}
+
+func (s *IgpsportSync) downloadFile(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -76,198 +89,181 @@ type DownloadCallback func(activity *DownloadedActivity) bool
 // It calls the callback function for each downloaded file
 // The callback receives a DownloadedActivity with either Data (on success) or Error (on failure)
 // Returning false from the callback will stop the download process
+//
+// Activities are downloaded one at a time and delivered in listing order;
+// the next one may already be downloading while the callback runs.
 func (s *IgpsportSync) DownloadAllActivities(options DownloadOptions) error {
-	if options.Callback == nil {
-		return fmt.Errorf("callback function is required")
-	}
-
-	page := 1
-	for {
-		// Get activity list for current page
-		resp, err := s.GetActivityList(page, DEFAULT_PAGE_SIZE, options.BeginTime, options.EndTime)
-		if err != nil {
-			return fmt.Errorf("error getting activity list page %d: %v", page, err)
-		}
-
-		// Process each activity on this page
-		for _, row := range resp.Data.Rows {
-			// Get download URL for this activity
-			downloadURL, err := s.GetActivityDownloadUrl(row.RideID)
-			if err != nil {
-				// Call callback with error
-				activity := &DownloadedActivity{
-					RideID:    row.RideID,
-					Title:     row.Title,
-					StartTime: row.StartTime,
-					Error:     fmt.Errorf("error getting download URL: %v", err),
-				}
-				if !options.Callback(activity) {
-					return nil // Stop downloading
-				}
-				continue
-			}
-
-			if downloadURL == nil || *downloadURL == "" {
-				// Call callback with error
-				activity := &DownloadedActivity{
-					RideID:    row.RideID,
-					Title:     row.Title,
-					StartTime: row.StartTime,
-					Error:     fmt.Errorf("empty download URL"),
-				}
-				if !options.Callback(activity) {
-					return nil // Stop downloading
-				}
-				continue
-			}
-
-			// Download the file
-			data, err := s.DownloadFile(*downloadURL)
-			activity := &DownloadedActivity{
-				RideID:    row.RideID,
-				Title:     row.Title,
-				StartTime: row.StartTime,
-				Data:      data,
-				Error:     err,
-			}
-
-			// Call callback
-			if !options.Callback(activity) {
-				return nil // Stop downloading
-			}
-		}
-
-		// Check if there are more pages
-		if page >= resp.Data.TotalPage {
-			break
-		}
-		page++
-	}
-
-	return nil
+	options.OrderedDelivery = true
+	_, err := s.downloadAll(context.Background(), options, 1)
+	return err
 }
 
 // DownloadAllActivitiesWithConcurrency downloads all activities with concurrent control
 // This method uses worker goroutines to download files in parallel
 // If MaxConcurrency is 0 or not set, defaults to 5
+//
+// The callback (and the sink) is always called from a single goroutine, one
+// activity at a time, so it does not need to be thread-safe. Activities are
+// delivered in completion order, or in listing order with
+// options.OrderedDelivery.
 func (s *IgpsportSync) DownloadAllActivitiesWithConcurrency(options DownloadOptions) error {
-	if options.Callback == nil {
-		return fmt.Errorf("callback function is required")
-	}
+	_, err := s.DownloadAllActivitiesContext(context.Background(), options)
+	return err
+}
 
-	// Set default concurrency if not specified
+// DownloadAllActivitiesContext works like DownloadAllActivitiesWithConcurrency
+// and reports what happened in a DownloadSummary. Cancelling ctx aborts the
+// requests in flight, delivers nothing more and returns ctx.Err(). When the
+// callback returns false the run stops the same way and returns no error.
+// All goroutines have exited when it returns.
+func (s *IgpsportSync) DownloadAllActivitiesContext(ctx context.Context, options DownloadOptions) (DownloadSummary, error) {
 	maxConcurrency := options.MaxConcurrency
 	if maxConcurrency <= 0 {
 		maxConcurrency = 5
 	}
+	return s.downloadAll(ctx, options, maxConcurrency)
+}
 
-	// Create channels for work distribution and synchronization
-	workChan := make(chan ActivityRow) // Channel to distribute activities
-	var wg sync.WaitGroup              // WaitGroup to track workers
-	shouldStop := false
-	stopMutex := &sync.Mutex{}
-
-	// Worker function that downloads activities
-	worker := func() {
-		defer wg.Done()
-		for row := range workChan {
-			// Check if we should stop
-			stopMutex.Lock()
-			if shouldStop {
-				stopMutex.Unlock()
-				continue
-			}
-			stopMutex.Unlock()
-
-			// Get download URL for this activity
-			downloadURL, err := s.GetActivityDownloadUrl(row.RideID)
-			if err != nil {
-				// Call callback with error
-				activity := &DownloadedActivity{
-					RideID:    row.RideID,
-					Title:     row.Title,
-					StartTime: row.StartTime,
-					Error:     fmt.Errorf("error getting download URL: %v", err),
-				}
-				if !options.Callback(activity) {
-					stopMutex.Lock()
-					shouldStop = true
-					stopMutex.Unlock()
-				}
-				continue
-			}
+// downloadAll lists the activities page by page and downloads them with
+// concurrency workers. A single delivery goroutine calls options.deliver.
+func (s *IgpsportSync) downloadAll(ctx context.Context, options DownloadOptions, concurrency int) (DownloadSummary, error) {
+	started := time.Now()
+	var summary DownloadSummary
+	if err := options.validate(); err != nil {
+		return summary, err
+	}
 
-			if downloadURL == nil || *downloadURL == "" {
-				// Call callback with error
-				activity := &DownloadedActivity{
-					RideID:    row.RideID,
-					Title:     row.Title,
-					StartTime: row.StartTime,
-					Error:     fmt.Errorf("empty download URL"),
-				}
-				if !options.Callback(activity) {
-					stopMutex.Lock()
-					shouldStop = true
-					stopMutex.Unlock()
+	// runCtx ends with ctx or when the callback asks to stop. It aborts the
+	// requests in flight and unblocks the dispatcher.
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	var stopped atomic.Bool // Set when the callback returns false
+	var total atomic.Int64  // TotalRows of the first page, for progress
+
+	jobs := make(chan downloadJob)                    // Channel to distribute activities
+	results := make(chan downloadResult, concurrency) // Channel to the delivery goroutine
+	var wg sync.WaitGroup                             // WaitGroup to track workers
+
+	// In ordered mode every dispatched activity holds a token until it is
+	// delivered, bounding the results waiting for an earlier one
+	var tokens chan struct{}
+	if options.OrderedDelivery {
+		reorderBuffer := options.ReorderBuffer
+		if reorderBuffer <= 0 {
+			reorderBuffer = 2 * concurrency
+		}
+		tokens = make(chan struct{}, reorderBuffer)
+	}
+
+	// Workers always report a result, so the delivery goroutine drains
+	// results and no worker blocks once the run has ended
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				// Abandoned jobs are still reported to keep the order
+				if runCtx.Err() != nil {
+					results <- downloadResult{seq: job.seq}
+					continue
 				}
-				continue
+				results <- downloadResult{seq: job.seq, activity: s.downloadActivity(runCtx, job.row.RideID, &job.row, options)}
 			}
+		}()
+	}
 
-			// Download the file
-			data, err := s.DownloadFile(*downloadURL)
-			activity := &DownloadedActivity{
-				RideID:    row.RideID,
-				Title:     row.Title,
-				StartTime: row.StartTime,
-				Data:      data,
-				Error:     err,
+	// Delivery goroutine, the only caller of the callback and of
+	// options.Progress. It owns the Succeeded, Failed and Skipped counters
+	// until it is done.
+	progress := newProgressTracker(options.Progress, started)
+	deliveryDone := make(chan struct{})
+	go func() {
+		defer close(deliveryDone)
+		handle := func(activity *DownloadedActivity) {
+			if activity == nil || runCtx.Err() != nil {
+				summary.Skipped++
+				progress.update(int(total.Load()), summary.Succeeded, summary.Failed, summary.Skipped, nil)
+				return
 			}
-
-			// Call callback
-			if !options.Callback(activity) {
-				stopMutex.Lock()
-				shouldStop = true
-				stopMutex.Unlock()
+			delivered, cont := options.deliver(runCtx, activity)
+			switch {
+			case !delivered:
+				summary.Skipped++
+			case activity.Error != nil:
+				summary.Failed++
+			default:
+				summary.Succeeded++
+			}
+			progress.update(int(total.Load()), summary.Succeeded, summary.Failed, summary.Skipped, activity)
+			if !cont {
+				stopped.Store(true)
+				stop()
+			}
+		}
+		pending := make(map[int]*DownloadedActivity)
+		next := 0
+		for result := range results {
+			if tokens == nil {
+				handle(result.activity)
 				continue
 			}
+			pending[result.seq] = result.activity
+			for {
+				activity, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				handle(activity)
+				<-tokens
+			}
 		}
-	}
-
-	// Start worker goroutines
-	for i := 0; i < maxConcurrency; i++ {
-		wg.Add(1)
-		go worker()
-	}
+	}()
 
-	// Fetch pages and send work to workers
-	page := 1
+	// Fetch pages and send work to workers until the listing ends or the
+	// run is stopped
+	var listErr error
+	page, seq := 1, 0
+pages:
 	for {
-		// Check if we should stop
-		stopMutex.Lock()
-		if shouldStop {
-			stopMutex.Unlock()
+		resp, err := s.getActivityList(runCtx, page, DEFAULT_PAGE_SIZE, options.BeginTime, options.EndTime)
+		if err != nil {
+			if runCtx.Err() == nil {
+				listErr = fmt.Errorf("error getting activity list page %d: %v", page, err)
+			}
 			break
 		}
-		stopMutex.Unlock()
-
-		// Get activity list for current page
-		resp, err := s.GetActivityList(page, DEFAULT_PAGE_SIZE, options.BeginTime, options.EndTime)
-		if err != nil {
-			close(workChan)
-			wg.Wait()
-			return fmt.Errorf("error getting activity list page %d: %v", page, err)
+		if page == 1 {
+			total.Store(int64(resp.Data.TotalRows))
 		}
 
-		// Send activities to workers
 		for _, row := range resp.Data.Rows {
-			stopMutex.Lock()
-			if shouldStop {
-				stopMutex.Unlock()
-				break
+			if runCtx.Err() != nil {
+				break pages
+			}
+			if tokens != nil {
+				select {
+				case tokens <- struct{}{}:
+				case <-runCtx.Done():
+					break pages
+				}
+			}
+			// Filtered and synced rows skip the workers but keep their
+			// place in the delivery order, so progress counts them
+			if !options.want(row) {
+				results <- downloadResult{seq: seq}
+				summary.Listed++
+				seq++
+				continue
+			}
+			select {
+			case jobs <- downloadJob{seq: seq, row: row}:
+				summary.Listed++
+				seq++
+			case <-runCtx.Done():
+				break pages
 			}
-			stopMutex.Unlock()
-
-			workChan <- row
 		}
 
 		// Check if there are more pages
@@ -277,45 +273,152 @@ func (s *IgpsportSync) DownloadAllActivitiesWithConcurrency(options DownloadOpti
 		page++
 	}
 
-	// Close the work channel and wait for workers to finish
-	close(workChan)
+	// Close the work channel and wait for workers and delivery to finish
+	close(jobs)
 	wg.Wait()
+	close(results)
+	<-deliveryDone
 
-	return nil
+	summary.StoppedEarly = stopped.Load() || ctx.Err() != nil
+	summary.Duration = time.Since(started)
+	if listErr != nil {
+		return summary, listErr
+	}
+	return summary, ctx.Err()
 }
 
-// DownloadSingleActivity downloads a single activity by rideId
-// It calls the callback function with the downloaded activity data
-// The callback receives a DownloadedActivity with either Data (on success) or Error (on failure)
-func (s *IgpsportSync) DownloadSingleActivity(rideId int, callback DownloadCallback) error {
-	if callback == nil {
-		return fmt.Errorf("callback function is required")
+// downloadJob is a listed activity numbered in listing order
+type downloadJob struct {
+	seq int
+	row ActivityRow
+}
+
+// downloadResult is a finished job, activity is nil for filtered and
+// abandoned jobs
+type downloadResult struct {
+	seq      int
+	activity *DownloadedActivity
+}
+
+// downloadActivity downloads the file of an activity. row is the activity
+// list entry, nil when only the RideID is known; the detail is then always
+// fetched to fill in title and start time. With options.IncludeDetail (or
+// without a row) the detail is fetched concurrently with the file; a detail
+// failure is reported in DetailError and does not fail the download.
+func (s *IgpsportSync) downloadActivity(ctx context.Context, rideID int, row *ActivityRow, options DownloadOptions) *DownloadedActivity {
+	activity := &DownloadedActivity{
+		RideID:    rideID,
+		Extension: options.Extension,
+		Requested: options.Extension,
+		Row:       row,
+	}
+	if row != nil {
+		activity.Title, activity.StartTime = row.Title, row.StartTime
 	}
 
-	// Get activity detail first to retrieve metadata
-	detail, err := s.GetActivityDetail(rideId)
-	if err != nil {
-		activity := &DownloadedActivity{
-			RideID: rideId,
-			Error:  fmt.Errorf("error getting activity detail: %v", err),
-		}
-		callback(activity)
-		return err
+	var detailDone chan struct{}
+	if options.IncludeDetail || row == nil {
+		detailDone = make(chan struct{})
+		go func() {
+			defer close(detailDone)
+			activity.Detail, activity.DetailError = s.activityDetail(ctx, rideID)
+		}()
 	}
 
-	downloadUrl := detail.Data.FitUrl
+	activity.SourceURL, activity.Data, activity.Error = s.downloadActivityFile(ctx, rideID)
+	if activity.Error == nil {
+		activity.Data, activity.Extension = convertDownload(activity.Data, options.Extension)
+	}
+	if detailDone != nil {
+		<-detailDone
+	}
+	if row == nil && activity.Detail != nil {
+		activity.Title, activity.StartTime = activity.Detail.Title, activity.Detail.StartTime
+	}
+	return activity
+}
 
-	data, err := s.DownloadFile(downloadUrl)
-	activity := &DownloadedActivity{
-		RideID:    rideId,
-		Title:     detail.Data.Title,
-		StartTime: detail.Data.StartTime,
-		Data:      data,
-		Error:     err,
+// downloadActivityFile resolves the download url of an activity and fetches
+// the file
+func (s *IgpsportSync) downloadActivityFile(ctx context.Context, rideID int) (string, []byte, error) {
+	downloadURL, err := s.getActivityDownloadUrl(ctx, rideID)
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting download URL: %v", err)
+	}
+	if downloadURL == nil || *downloadURL == "" {
+		return "", nil, fmt.Errorf("empty download URL")
+	}
+	data, err := s.downloadFile(ctx, *downloadURL)
+	return *downloadURL, data, err
+}
+
+// convertDownload converts the file served by iGPSport to the requested
+// format. Data that cannot be converted is kept in the format served, as
+// told by DetectExtension.
+func convertDownload(data []byte, ext Extension) ([]byte, Extension) {
+	if converted, err := ConvertActivity(data, ext); err == nil {
+		return converted, ext
 	}
+	if served, ok := DetectExtension(data); ok {
+		return data, served
+	}
+	return data, ext
+}
 
-	// Call callback
-	callback(activity)
+// DownloadSingleActivity downloads a single activity by rideId in the
+// format of options.Extension, through the same path as DownloadActivities.
+// The detail is always attached. options.Sink and options.Callback receive
+// the activity; BeginTime, EndTime and Filter.Match do not apply.
+// The download error, if any, is also returned.
+func (s *IgpsportSync) DownloadSingleActivity(rideId int, options DownloadOptions) error {
+	if err := options.validate(); err != nil {
+		return err
+	}
+	activity := s.downloadActivity(context.Background(), rideId, nil, options)
+	options.deliver(context.Background(), activity)
+	return activity.Error
+}
 
-	return err
+// DownloadActivities downloads the given activities in order, stopping when
+// the callback returns false or ctx is cancelled (returning ctx.Err()).
+// Titles and start times come from the activity detail, which is always
+// attached. Failed downloads are reported to the callback and counted in
+// the summary, not returned. BeginTime, EndTime and Filter.Match do not
+// apply.
+func (s *IgpsportSync) DownloadActivities(ctx context.Context, ids []int, options DownloadOptions) (DownloadSummary, error) {
+	started := time.Now()
+	summary := DownloadSummary{Listed: len(ids)}
+	if err := options.validate(); err != nil {
+		return summary, err
+	}
+	progress := newProgressTracker(options.Progress, started)
+	finish := func(stoppedEarly bool) DownloadSummary {
+		summary.Skipped = summary.Listed - summary.Succeeded - summary.Failed
+		summary.StoppedEarly = stoppedEarly
+		summary.Duration = time.Since(started)
+		return summary
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return finish(true), err
+		}
+		activity := s.downloadActivity(ctx, id, nil, options)
+		if err := ctx.Err(); err != nil {
+			return finish(true), err
+		}
+		delivered, cont := options.deliver(ctx, activity)
+		switch {
+		case !delivered:
+			summary.Skipped++
+		case activity.Error != nil:
+			summary.Failed++
+		default:
+			summary.Succeeded++
+		}
+		progress.update(len(ids), summary.Succeeded, summary.Failed, summary.Skipped, activity)
+		if !cont {
+			return finish(true), nil
+		}
+	}
+	return finish(false), nil
 }
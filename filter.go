@@ -0,0 +1,70 @@
+package igpsportsync
+
+import "strings"
+
+// ActivityFilter selects which listed activities go through the download
+// pipeline. Zero values disable the corresponding check; a nil filter
+// matches everything.
+type ActivityFilter struct {
+	// MinDistance and MaxDistance bound ActivityRow.RideDistance
+	MinDistance float64
+	MaxDistance float64
+
+	// Text matches a case-insensitive substring of the title
+	Text string
+
+	// Device matches a case-insensitive substring of the product name
+	Device string
+
+	// Deduplicator skips activities that duplicate an earlier one, by
+	// metadata before downloading and by file content after downloading
+	Deduplicator *Deduplicator
+}
+
+// Match reports whether the row passes the filter. Rows rejected as
+// duplicates are recorded in the Deduplicator.
+func (f *ActivityFilter) Match(row ActivityRow) bool {
+	if !f.matchFields(row) {
+		return false
+	}
+	if f != nil && f.Deduplicator != nil {
+		if _, dup := f.Deduplicator.CheckRow(row); dup {
+			return false
+		}
+	}
+	return true
+}
+
+// matchFields applies the distance, title and device checks of Match
+func (f *ActivityFilter) matchFields(row ActivityRow) bool {
+	if f == nil {
+		return true
+	}
+	if f.MinDistance > 0 && row.RideDistance < f.MinDistance {
+		return false
+	}
+	if f.MaxDistance > 0 && row.RideDistance > f.MaxDistance {
+		return false
+	}
+	if f.Text != "" && !containsFold(row.Title, f.Text) {
+		return false
+	}
+	if f.Device != "" && !containsFold(row.ProductName, f.Device) {
+		return false
+	}
+	return true
+}
+
+// matchContent reports whether a downloaded activity should be delivered,
+// i.e. it is not a content duplicate of an earlier one
+func (f *ActivityFilter) matchContent(activity *DownloadedActivity) bool {
+	if f == nil || f.Deduplicator == nil || activity.Error != nil {
+		return true
+	}
+	_, dup := f.Deduplicator.CheckContent(activity.RideID, activity.Data)
+	return !dup
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
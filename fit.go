@@ -0,0 +1,332 @@
+package igpsportsync
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Minimal decoder for the FIT binary protocol. It keeps the byte offset of
+// every field so callers can patch values in place (see updateCRC).
+
+// FIT global message numbers used by this package
+const (
+	FIT_MESG_FILE_ID = 0
+	FIT_MESG_SESSION = 18
+	FIT_MESG_LAP     = 19
+	FIT_MESG_RECORD  = 20
+)
+
+// fitEpoch is the FIT time origin, 1989-12-31T00:00:00Z
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+const fitTimestampField = 253
+
+type fitField struct {
+	num      uint8
+	baseType byte
+	offset   int // absolute offset of the value in fitFile.data
+	size     int
+}
+
+type fitMessage struct {
+	num          uint16
+	bigEndian    bool
+	fields       []fitField
+	timestamp    uint32
+	hasTimestamp bool
+}
+
+type fitDefinition struct {
+	num       uint16
+	bigEndian bool
+	fields    []fitField // offsets relative to the start of the data message
+	size      int
+}
+
+type fitFile struct {
+	data     []byte
+	dataEnd  int // offset of the trailing file CRC
+	messages []fitMessage
+}
+
+// decodeFIT parses the first FIT file contained in data. The slice is
+// referenced, not copied.
+func decodeFIT(data []byte) (*fitFile, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("fit: file too short")
+	}
+	headerSize := int(data[0])
+	if headerSize < 12 || len(data) < headerSize || string(data[8:12]) != ".FIT" {
+		return nil, fmt.Errorf("fit: invalid header")
+	}
+	dataEnd := headerSize + int(binary.LittleEndian.Uint32(data[4:8]))
+	if dataEnd+2 > len(data) {
+		return nil, fmt.Errorf("fit: truncated file")
+	}
+
+	f := &fitFile{data: data, dataEnd: dataEnd}
+	defs := make(map[byte]*fitDefinition)
+	var lastTimestamp uint32
+	pos := headerSize
+	for pos < dataEnd {
+		header := data[pos]
+		pos++
+
+		if header&0x80 == 0 && header&0x40 != 0 {
+			def, n, err := readFITDefinition(data[pos:dataEnd], header&0x20 != 0)
+			if err != nil {
+				return nil, err
+			}
+			defs[header&0x0F] = def
+			pos += n
+			continue
+		}
+
+		local := header & 0x0F
+		compressed := header&0x80 != 0
+		if compressed {
+			local = (header >> 5) & 0x03
+		}
+		def, ok := defs[local]
+		if !ok {
+			return nil, fmt.Errorf("fit: data message for undefined local type %d at offset %d", local, pos-1)
+		}
+		if pos+def.size > dataEnd {
+			return nil, fmt.Errorf("fit: truncated data message at offset %d", pos-1)
+		}
+
+		msg := fitMessage{num: def.num, bigEndian: def.bigEndian, fields: make([]fitField, len(def.fields))}
+		for i, field := range def.fields {
+			field.offset += pos
+			msg.fields[i] = field
+		}
+		pos += def.size
+
+		if compressed {
+			offset := uint32(header & 0x1F)
+			ts := lastTimestamp&^0x1F + offset
+			if offset < lastTimestamp&0x1F {
+				ts += 0x20
+			}
+			lastTimestamp = ts
+			msg.timestamp, msg.hasTimestamp = ts, true
+		} else if ts, ok := f.uint(&msg, fitTimestampField); ok {
+			lastTimestamp = uint32(ts)
+			msg.timestamp, msg.hasTimestamp = uint32(ts), true
+		}
+		f.messages = append(f.messages, msg)
+	}
+	return f, nil
+}
+
+func readFITDefinition(b []byte, developer bool) (*fitDefinition, int, error) {
+	if len(b) < 5 {
+		return nil, 0, fmt.Errorf("fit: truncated definition message")
+	}
+	def := &fitDefinition{bigEndian: b[1] == 1}
+	if def.bigEndian {
+		def.num = binary.BigEndian.Uint16(b[2:4])
+	} else {
+		def.num = binary.LittleEndian.Uint16(b[2:4])
+	}
+	count := int(b[4])
+	n := 5
+	if len(b) < n+count*3 {
+		return nil, 0, fmt.Errorf("fit: truncated definition message")
+	}
+	for i := 0; i < count; i++ {
+		field := fitField{num: b[n], size: int(b[n+1]), baseType: b[n+2], offset: def.size}
+		def.fields = append(def.fields, field)
+		def.size += field.size
+		n += 3
+	}
+	if developer {
+		if len(b) < n+1 {
+			return nil, 0, fmt.Errorf("fit: truncated definition message")
+		}
+		devCount := int(b[n])
+		n++
+		if len(b) < n+devCount*3 {
+			return nil, 0, fmt.Errorf("fit: truncated definition message")
+		}
+		for i := 0; i < devCount; i++ {
+			def.size += int(b[n+1]) // developer fields are skipped
+			n += 3
+		}
+	}
+	return def, n, nil
+}
+
+func (m *fitMessage) field(num uint8) *fitField {
+	for i := range m.fields {
+		if m.fields[i].num == num {
+			return &m.fields[i]
+		}
+	}
+	return nil
+}
+
+// fitBaseSize returns the size in bytes of a single value of the base type
+func fitBaseSize(baseType byte) int {
+	switch baseType & 0x1F {
+	case 3, 4, 11:
+		return 2
+	case 5, 6, 8, 12:
+		return 4
+	case 9, 14, 15, 16:
+		return 8
+	}
+	return 1
+}
+
+func fitSigned(baseType byte) bool {
+	switch baseType & 0x1F {
+	case 1, 3, 5, 14:
+		return true
+	}
+	return false
+}
+
+func fitZeroInvalid(baseType byte) bool {
+	switch baseType & 0x1F {
+	case 10, 11, 12, 16:
+		return true
+	}
+	return false
+}
+
+// raw reads the first value of a field as an unsigned integer of the base type size
+func (f *fitFile) raw(m *fitMessage, field *fitField) (uint64, int, bool) {
+	size := fitBaseSize(field.baseType)
+	if field.size < size {
+		return 0, 0, false
+	}
+	b := f.data[field.offset : field.offset+size]
+	var order binary.ByteOrder = binary.LittleEndian
+	if m.bigEndian {
+		order = binary.BigEndian
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), size, true
+	case 2:
+		return uint64(order.Uint16(b)), size, true
+	case 4:
+		return uint64(order.Uint32(b)), size, true
+	default:
+		return order.Uint64(b), size, true
+	}
+}
+
+// uint returns an unsigned field value, false if absent or invalid
+func (f *fitFile) uint(m *fitMessage, num uint8) (uint64, bool) {
+	field := m.field(num)
+	if field == nil {
+		return 0, false
+	}
+	v, size, ok := f.raw(m, field)
+	if !ok {
+		return 0, false
+	}
+	if fitZeroInvalid(field.baseType) {
+		return v, v != 0
+	}
+	return v, v != 1<<(size*8)-1
+}
+
+// sint returns a signed field value, false if absent or invalid
+func (f *fitFile) sint(m *fitMessage, num uint8) (int64, bool) {
+	field := m.field(num)
+	if field == nil {
+		return 0, false
+	}
+	v, size, ok := f.raw(m, field)
+	if !ok {
+		return 0, false
+	}
+	if !fitSigned(field.baseType) {
+		return int64(v), v != 1<<(size*8)-1
+	}
+	bits := uint(size * 8)
+	if v == 1<<(bits-1)-1 {
+		return 0, false
+	}
+	return int64(v<<(64-bits)) >> (64 - bits), true
+}
+
+// put writes an integer value into a field, using the field's byte order
+func (f *fitFile) put(m *fitMessage, num uint8, value uint64) bool {
+	field := m.field(num)
+	if field == nil {
+		return false
+	}
+	size := fitBaseSize(field.baseType)
+	if field.size < size {
+		return false
+	}
+	b := f.data[field.offset : field.offset+size]
+	var order binary.ByteOrder = binary.LittleEndian
+	if m.bigEndian {
+		order = binary.BigEndian
+	}
+	switch size {
+	case 1:
+		b[0] = byte(value)
+	case 2:
+		order.PutUint16(b, uint16(value))
+	case 4:
+		order.PutUint32(b, uint32(value))
+	default:
+		order.PutUint64(b, value)
+	}
+	return true
+}
+
+// invalidate sets a field to the invalid value of its base type
+func (f *fitFile) invalidate(m *fitMessage, num uint8) bool {
+	field := m.field(num)
+	if field == nil {
+		return false
+	}
+	size := fitBaseSize(field.baseType)
+	switch {
+	case fitZeroInvalid(field.baseType):
+		return f.put(m, num, 0)
+	case fitSigned(field.baseType):
+		return f.put(m, num, 1<<(size*8-1)-1)
+	default:
+		return f.put(m, num, 1<<(size*8)-1)
+	}
+}
+
+// updateCRC recomputes the trailing file CRC after fields were patched
+func (f *fitFile) updateCRC() {
+	var crc uint16
+	for _, b := range f.data[:f.dataEnd] {
+		crc = fitCRC(crc, b)
+	}
+	binary.LittleEndian.PutUint16(f.data[f.dataEnd:], crc)
+}
+
+var fitCRCTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+func fitCRC(crc uint16, b byte) uint16 {
+	tmp := fitCRCTable[crc&0xF]
+	crc = (crc >> 4) & 0x0FFF
+	crc = crc ^ tmp ^ fitCRCTable[b&0xF]
+	tmp = fitCRCTable[crc&0xF]
+	crc = (crc >> 4) & 0x0FFF
+	return crc ^ tmp ^ fitCRCTable[(b>>4)&0xF]
+}
+
+func fitTime(ts uint32) time.Time {
+	return fitEpoch.Add(time.Duration(ts) * time.Second)
+}
+
+func fitSemicircles(v int64) float64 {
+	return float64(v) * (180.0 / (1 << 31))
+}
@@ -0,0 +1,210 @@
+package igpsportsync
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Export of stored activities as bundles for the Garmin Connect importer
+
+const (
+	FIT_MESG_DEVICE_INFO = 23
+
+	// GARMIN_MANUFACTURER is the FIT manufacturer id of Garmin
+	GARMIN_MANUFACTURER = 1
+
+	// GARMIN_PRODUCT_EDGE_530 is the FIT product id of the Edge 530
+	GARMIN_PRODUCT_EDGE_530 = 3121
+
+	// GARMIN_BUNDLE_SIZE is the default size limit of a bundle zip
+	GARMIN_BUNDLE_SIZE = 20 << 20
+
+	// GARMIN_EXPORT_MANIFEST is the file mapping rides to bundle files
+	GARMIN_EXPORT_MANIFEST = "garmin_export.json"
+)
+
+// zip local header, data descriptor and central directory record per file,
+// without the name
+const zipFileOverhead = 30 + 16 + 46
+
+// GarminExportOptions configures ExportGarmin
+type GarminExportOptions struct {
+	// Manufacturer and Product are written to FIT files, default
+	// GARMIN_MANUFACTURER and GARMIN_PRODUCT_EDGE_530
+	Manufacturer uint16
+	Product      uint16
+
+	// MaxBundleSize limits the size of each zip, default GARMIN_BUNDLE_SIZE.
+	// A file larger than the limit gets a bundle of its own.
+	MaxBundleSize int64
+}
+
+// GarminExportEntry maps a ride to its file in a bundle
+type GarminExportEntry struct {
+	RideID    int    `json:"rideId"`
+	Title     string `json:"title"`
+	StartTime string `json:"startTime"`
+	Bundle    string `json:"bundle"`
+	File      string `json:"file"`
+	Size      int64  `json:"size"`
+
+	// Patched is set for FIT files whose device fields were rewritten;
+	// GPX and TCX files are exported as stored
+	Patched bool `json:"patched,omitempty"`
+}
+
+// GarminFIT returns a copy of a FIT activity with the manufacturer and
+// product of the file_id message and of the creator device_info messages
+// replaced, so the Garmin Connect importer accepts it as a Garmin recording
+func GarminFIT(data []byte, manufacturer, product uint16) ([]byte, error) {
+	f, err := decodeFIT(bytes.Clone(data))
+	if err != nil {
+		return nil, err
+	}
+	fileID := false
+	for i := range f.messages {
+		m := &f.messages[i]
+		switch m.num {
+		case FIT_MESG_FILE_ID:
+			if !f.put(m, 1, uint64(manufacturer)) {
+				return nil, fmt.Errorf("fit: file_id has no manufacturer field")
+			}
+			f.put(m, 2, uint64(product))
+			fileID = true
+		case FIT_MESG_DEVICE_INFO:
+			if index, ok := f.uint(m, 0); ok && index != 0 {
+				continue
+			}
+			f.put(m, 2, uint64(manufacturer))
+			f.put(m, 4, uint64(product))
+		}
+	}
+	if !fileID {
+		return nil, fmt.Errorf("fit: no file_id message")
+	}
+	f.updateCRC()
+	return f.data, nil
+}
+
+// ExportGarmin writes the stored files of manifest entries (e.g. the
+// Activities of a SyncState) to zip bundles in dir, named
+// garmin-001.zip, garmin-002.zip and so on, one file per ride: the FIT file
+// patched with GarminFIT when stored, else the first GPX or TCX file.
+// GARMIN_EXPORT_MANIFEST lists the exported files.
+func ExportGarmin(ctx context.Context, storage Storage, entries []ManifestEntry, dir string, options GarminExportOptions) ([]GarminExportEntry, error) {
+	if options.Manufacturer == 0 {
+		options.Manufacturer = GARMIN_MANUFACTURER
+	}
+	if options.Product == 0 {
+		options.Product = GARMIN_PRODUCT_EDGE_530
+	}
+	if options.MaxBundleSize <= 0 {
+		options.MaxBundleSize = GARMIN_BUNDLE_SIZE
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	b := &garminBundler{dir: dir, max: options.MaxBundleSize}
+	exported := []GarminExportEntry{}
+	for _, ride := range entryPerRide(entries) {
+		if err := ctx.Err(); err != nil {
+			return exported, err
+		}
+		ext, err := ParseExtension(ride.Format)
+		if err != nil {
+			return exported, err
+		}
+		data, err := storage.Get(ctx, ride.RideID, ext)
+		if err != nil {
+			return exported, fmt.Errorf("error reading activity %d: %v", ride.RideID, err)
+		}
+		entry := GarminExportEntry{
+			RideID:    ride.RideID,
+			Title:     ride.Title,
+			StartTime: ride.StartTime,
+			File:      storedFileName(ride.RideID, ext),
+		}
+		if ext == FIT {
+			data, err = GarminFIT(data, options.Manufacturer, options.Product)
+			if err != nil {
+				return exported, fmt.Errorf("error converting activity %d: %v", ride.RideID, err)
+			}
+			entry.Patched = true
+		}
+		entry.Size = int64(len(data))
+		if entry.Bundle, err = b.add(entry.File, data); err != nil {
+			return exported, err
+		}
+		exported = append(exported, entry)
+	}
+	if err := b.close(); err != nil {
+		return exported, err
+	}
+
+	manifest, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return exported, err
+	}
+	return exported, writeFileAtomic(filepath.Join(dir, GARMIN_EXPORT_MANIFEST), manifest)
+}
+
+// garminBundler packs files into zips of at most max bytes. Sizes are
+// estimated with uncompressed data plus the deflate overhead of
+// incompressible data, so bundles stay below the limit.
+type garminBundler struct {
+	dir   string
+	max   int64
+	count int
+
+	buf   bytes.Buffer
+	zip   *zip.Writer
+	size  int64
+	files int
+}
+
+func (b *garminBundler) name() string {
+	return fmt.Sprintf("garmin-%03d.zip", b.count)
+}
+
+func (b *garminBundler) add(file string, data []byte) (string, error) {
+	size := int64(zipFileOverhead + 2*len(file) + len(data) + len(data)/1024 + 16)
+	if b.zip != nil && b.files > 0 && b.size+size+22 > b.max {
+		if err := b.close(); err != nil {
+			return "", err
+		}
+	}
+	if b.zip == nil {
+		b.count++
+		b.buf.Reset()
+		b.zip = zip.NewWriter(&b.buf)
+		b.size, b.files = 0, 0
+	}
+	w, err := b.zip.CreateHeader(&zip.FileHeader{Name: file, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	b.size += size
+	b.files++
+	return b.name(), nil
+}
+
+func (b *garminBundler) close() error {
+	if b.zip == nil {
+		return nil
+	}
+	if err := b.zip.Close(); err != nil {
+		return err
+	}
+	b.zip = nil
+	return writeFileAtomic(filepath.Join(b.dir, b.name()), b.buf.Bytes())
+}
@@ -0,0 +1,198 @@
+package igpsportsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// PrivacyZone is a circular area, e.g. around home or work, that should not
+// appear in published routes
+type PrivacyZone struct {
+	Lat    float64 `json:"lat"`
+	Lng    float64 `json:"lng"`
+	Radius float64 `json:"radius"` // meters
+}
+
+// Contains reports whether the coordinate lies inside the zone
+func (z PrivacyZone) Contains(p LatLng) bool {
+	return Haversine(z.Lat, z.Lng, p.Lat, p.Lng) <= z.Radius
+}
+
+// RouteOptions controls how a track is turned into a route for maps
+type RouteOptions struct {
+	// Tolerance is the Douglas-Peucker simplification tolerance in meters,
+	// 0 keeps every point
+	Tolerance float64
+
+	// PrivacyZones trims the route start and end while they are inside a zone
+	PrivacyZones []PrivacyZone
+
+	// TrimStart and TrimEnd additionally cut this many meters from the
+	// start and end of the route
+	TrimStart float64
+	TrimEnd   float64
+}
+
+// Route returns the track coordinates with privacy trimming and
+// simplification applied
+func (t *Track) Route(options RouteOptions) []LatLng {
+	points := TrimRoute(t.Positions(), options.PrivacyZones, options.TrimStart, options.TrimEnd)
+	return SimplifyRoute(points, options.Tolerance)
+}
+
+// EncodedPolyline returns the route as a Google encoded polyline
+func (t *Track) EncodedPolyline(options RouteOptions) string {
+	return EncodePolyline(t.Route(options))
+}
+
+// TrimRoute removes points from the start and the end of a route while they
+// are inside one of the zones or within trimStart/trimEnd meters of the
+// respective end
+func TrimRoute(points []LatLng, zones []PrivacyZone, trimStart, trimEnd float64) []LatLng {
+	first := trimIndex(points, zones, trimStart, 0, 1)
+	if first >= len(points) {
+		return nil
+	}
+	last := trimIndex(points, zones, trimEnd, len(points)-1, -1)
+	if last < first {
+		return nil
+	}
+	return points[first : last+1]
+}
+
+// trimIndex walks from start in direction step and returns the index of the
+// first point that is outside every zone and farther than trim meters along
+// the route
+func trimIndex(points []LatLng, zones []PrivacyZone, trim float64, start, step int) int {
+	var walked float64
+	i := start
+	for ; i >= 0 && i < len(points); i += step {
+		if i != start {
+			prev := points[i-step]
+			walked += Haversine(prev.Lat, prev.Lng, points[i].Lat, points[i].Lng)
+		}
+		if walked >= trim && !inAnyZone(points[i], zones) {
+			break
+		}
+	}
+	return i
+}
+
+func inAnyZone(p LatLng, zones []PrivacyZone) bool {
+	for _, zone := range zones {
+		if zone.Contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// SimplifyRoute reduces the number of points with the Douglas-Peucker
+// algorithm; tolerance is the maximum deviation in meters, 0 disables it
+func SimplifyRoute(points []LatLng, tolerance float64) []LatLng {
+	if len(points) < 3 || tolerance <= 0 {
+		return points
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// iterative to avoid deep recursion on long rides
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		seg := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		maxDist, index := 0.0, -1
+		for i := seg[0] + 1; i < seg[1]; i++ {
+			if d := segmentDistance(points[i], points[seg[0]], points[seg[1]]); d > maxDist {
+				maxDist, index = d, i
+			}
+		}
+		if index >= 0 && maxDist > tolerance {
+			keep[index] = true
+			stack = append(stack, [2]int{seg[0], index}, [2]int{index, seg[1]})
+		}
+	}
+
+	simplified := make([]LatLng, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// segmentDistance returns the distance in meters from p to the segment a-b,
+// using an equirectangular projection which is accurate at route scale
+func segmentDistance(p, a, b LatLng) float64 {
+	toRad := math.Pi / 180
+	cosLat := math.Cos(a.Lat * toRad)
+	project := func(q LatLng) (float64, float64) {
+		return (q.Lng - a.Lng) * toRad * cosLat * EARTH_RADIUS, (q.Lat - a.Lat) * toRad * EARTH_RADIUS
+	}
+	px, py := project(p)
+	bx, by := project(b)
+
+	length := bx*bx + by*by
+	if length == 0 {
+		return math.Hypot(px, py)
+	}
+	u := math.Max(0, math.Min(1, (px*bx+py*by)/length))
+	return math.Hypot(px-u*bx, py-u*by)
+}
+
+// GeoJSONGeometry is a GeoJSON geometry; coordinates are [lng, lat]
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates [][]float64 `json:"coordinates"`
+}
+
+// GeoJSONFeature is a GeoJSON Feature
+type GeoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   GeoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+// GeoJSONFeatureCollection is a GeoJSON FeatureCollection
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// NewGeoJSONFeatureCollection wraps features into a FeatureCollection
+func NewGeoJSONFeatureCollection(features ...GeoJSONFeature) GeoJSONFeatureCollection {
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// NewGeoJSONLineString converts coordinates to a LineString geometry
+func NewGeoJSONLineString(points []LatLng) GeoJSONGeometry {
+	coordinates := make([][]float64, len(points))
+	for i, p := range points {
+		coordinates[i] = []float64{p.Lng, p.Lat}
+	}
+	return GeoJSONGeometry{Type: "LineString", Coordinates: coordinates}
+}
+
+// GeoJSONFeature returns the route as a LineString Feature. When detail is
+// given its fields become the feature properties (the download url is left out).
+func (t *Track) GeoJSONFeature(detail *ActivityDetailData, options RouteOptions) (*GeoJSONFeature, error) {
+	feature := &GeoJSONFeature{
+		Type:       "Feature",
+		Geometry:   NewGeoJSONLineString(t.Route(options)),
+		Properties: map[string]any{},
+	}
+	if detail != nil {
+		data, err := json.Marshal(detail)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding activity detail: %v", err)
+		}
+		if err := json.Unmarshal(data, &feature.Properties); err != nil {
+			return nil, fmt.Errorf("error encoding activity detail: %v", err)
+		}
+		delete(feature.Properties, "fitUrl")
+	}
+	return feature, nil
+}
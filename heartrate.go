@@ -0,0 +1,202 @@
+package igpsportsync
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// FRIEL_LTHR_ZONES are Joe Friel's cycling heart rate zones as fractions of LTHR
+var FRIEL_LTHR_ZONES = []Zone{
+	{1, "Recovery", 0, 0.81},
+	{2, "Aerobic", 0.81, 0.90},
+	{3, "Tempo", 0.90, 0.94},
+	{4, "SubThreshold", 0.94, 1.00},
+	{5, "SuperThreshold", 1.00, 1.03},
+	{6, "Aerobic Capacity", 1.03, 1.07},
+	{7, "Anaerobic Capacity", 1.07, math.Inf(1)},
+}
+
+// MAX_HR_ZONES are the classic five zones as fractions of max heart rate
+var MAX_HR_ZONES = []Zone{
+	{1, "Very Light", 0, 0.60},
+	{2, "Light", 0.60, 0.70},
+	{3, "Moderate", 0.70, 0.80},
+	{4, "Hard", 0.80, 0.90},
+	{5, "Maximum", 0.90, math.Inf(1)},
+}
+
+// DEFAULT_RESTING_HR is used for TRIMP when no resting heart rate is given
+const DEFAULT_RESTING_HR = 60
+
+// HeartRateOptions holds the rider's heart rate reference values.
+// Zero values are not set.
+type HeartRateOptions struct {
+	MaxHR     int
+	LTHR      int
+	RestingHR int
+	Female    *bool // selects the female TRIMP weighting when true
+}
+
+// HeartRateOptionsFromProfile takes max HR and LTHR from the rider profile
+// (UserInfoResult.Mhr and Lthr) and applies the non-zero overrides.
+// The TRIMP weighting follows the profile Sex (2 is female) unless
+// overrides.Female is set.
+func HeartRateOptionsFromProfile(profile UserInfoResult, overrides HeartRateOptions) HeartRateOptions {
+	female := profile.Sex == 2
+	options := HeartRateOptions{
+		MaxHR:  profile.Mhr,
+		LTHR:   profile.Lthr,
+		Female: &female,
+	}
+	if overrides.Female != nil {
+		options.Female = overrides.Female
+	}
+	if overrides.MaxHR > 0 {
+		options.MaxHR = overrides.MaxHR
+	}
+	if overrides.LTHR > 0 {
+		options.LTHR = overrides.LTHR
+	}
+	options.RestingHR = overrides.RestingHR
+	return options
+}
+
+// HeartRateSummary is the heart rate analysis of one activity
+type HeartRateSummary struct {
+	Options          HeartRateOptions
+	Duration         time.Duration // time covered by heart rate samples
+	AverageHeartRate float64
+	MaxHeartRate     float64
+
+	// LTHRZones is the time in FRIEL_LTHR_ZONES, nil without LTHR
+	LTHRZones []ZoneTime
+
+	// MaxHRZones is the time in MAX_HR_ZONES, nil without max HR
+	MaxHRZones []ZoneTime
+
+	// TRIMP is Banister's training impulse, needs max HR
+	TRIMP float64
+
+	// HrTSS scales TRIMP so that one hour at LTHR scores 100, needs max HR and LTHR
+	HrTSS float64
+
+	// AerobicDecoupling (Pa:HR) is the percentage drop of the
+	// power (or speed, without power) to heart rate ratio from the first
+	// to the second half of the activity
+	AerobicDecoupling float64
+
+	// HeartRateDrift is the percentage rise of average heart rate from
+	// the first to the second half of the activity
+	HeartRateDrift float64
+}
+
+// AnalyzeHeartRate computes heart rate metrics of a track. Metrics that
+// need a missing reference value are left zero.
+func AnalyzeHeartRate(track *Track, options HeartRateOptions) (*HeartRateSummary, error) {
+	points := resamplePoints(track, func(p TrackPoint) bool { return p.HeartRate > 0 })
+	if len(points) == 0 {
+		return nil, fmt.Errorf("activity has no heart rate data")
+	}
+	if options.RestingHR <= 0 {
+		options.RestingHR = DEFAULT_RESTING_HR
+	}
+
+	hr := make([]float64, len(points))
+	for i, p := range points {
+		hr[i] = float64(p.HeartRate)
+	}
+
+	summary := &HeartRateSummary{
+		Options:          options,
+		Duration:         time.Duration(len(points)) * time.Second,
+		AverageHeartRate: mean(hr),
+	}
+	for _, v := range hr {
+		summary.MaxHeartRate = math.Max(summary.MaxHeartRate, v)
+	}
+
+	if options.LTHR > 0 {
+		summary.LTHRZones = timeInZones(hr, float64(options.LTHR), FRIEL_LTHR_ZONES)
+	}
+	if options.MaxHR > 0 {
+		summary.MaxHRZones = timeInZones(hr, float64(options.MaxHR), MAX_HR_ZONES)
+		summary.TRIMP = trimp(hr, options)
+		if options.LTHR > 0 {
+			lthr := make([]float64, 3600)
+			for i := range lthr {
+				lthr[i] = float64(options.LTHR)
+			}
+			if reference := trimp(lthr, options); reference > 0 {
+				summary.HrTSS = summary.TRIMP / reference * 100
+			}
+		}
+	}
+
+	half := len(points) / 2
+	if half > 0 {
+		first, second := mean(hr[:half]), mean(hr[half:])
+		summary.HeartRateDrift = (second - first) / first * 100
+
+		output := func(p TrackPoint) float64 { return p.Speed }
+		if track.hasPower() {
+			output = func(p TrackPoint) float64 { return float64(p.Power) }
+		}
+		ef1 := efficiencyFactor(points[:half], output)
+		ef2 := efficiencyFactor(points[half:], output)
+		if ef1 > 0 {
+			summary.AerobicDecoupling = (ef1 - ef2) / ef1 * 100
+		}
+	}
+	return summary, nil
+}
+
+// trimp sums Banister's TRIMP over one second heart rate samples
+func trimp(hr []float64, options HeartRateOptions) float64 {
+	weight, exponent := 0.64, 1.92
+	if options.Female != nil && *options.Female {
+		weight, exponent = 0.86, 1.67
+	}
+	reserve := float64(options.MaxHR - options.RestingHR)
+	if reserve <= 0 {
+		return 0
+	}
+	var total float64
+	for _, v := range hr {
+		ratio := math.Max(0, math.Min(1, (v-float64(options.RestingHR))/reserve))
+		total += ratio * weight * math.Exp(exponent*ratio) / 60
+	}
+	return total
+}
+
+// efficiencyFactor is the average output divided by the average heart rate
+func efficiencyFactor(points []TrackPoint, output func(TrackPoint) float64) float64 {
+	var out, hr float64
+	for _, p := range points {
+		out += output(p)
+		hr += float64(p.HeartRate)
+	}
+	if hr == 0 {
+		return 0
+	}
+	return out / hr
+}
+
+func (t *Track) hasPower() bool {
+	for _, p := range t.Points {
+		if p.HasPower {
+			return true
+		}
+	}
+	return false
+}
+
+// AnalyzeHeartRate analyzes a track with the max HR and LTHR from the
+// rider's profile, applying the non-zero overrides
+func (s *IgpsportSync) AnalyzeHeartRate(track *Track, overrides HeartRateOptions) (*HeartRateSummary, error) {
+	userInfo, err := s.GetUserInfo()
+	if err != nil {
+		return nil, err
+	}
+	return AnalyzeHeartRate(track, HeartRateOptionsFromProfile(userInfo.Data, overrides))
+}
@@ -0,0 +1,114 @@
+package igpsportsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const INTERVALS_URL = "https://intervals.icu"
+
+// IntervalsConfig configures an IntervalsUploader
+type IntervalsConfig struct {
+	// APIKey is the key from the intervals.icu developer settings
+	APIKey string
+
+	// AthleteID defaults to "0", the athlete owning the key
+	AthleteID string
+
+	// BaseURL replaces INTERVALS_URL, e.g. for tests
+	BaseURL string
+
+	// Client defaults to an http.Client with a 60 second timeout
+	Client *http.Client
+}
+
+// IntervalsUploader uploads activity files to intervals.icu. The
+// external_id of every upload is the iGPSport RideID, so intervals.icu
+// rejects a ride uploaded twice with 409 Conflict, reported as a duplicate.
+type IntervalsUploader struct {
+	config IntervalsConfig
+	client *http.Client
+}
+
+func NewIntervalsUploader(config IntervalsConfig) *IntervalsUploader {
+	if config.AthleteID == "" {
+		config.AthleteID = "0"
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = INTERVALS_URL
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &IntervalsUploader{config: config, client: client}
+}
+
+func (u *IntervalsUploader) Name() string {
+	return "intervals"
+}
+
+// Upload sends the file; intervals.icu processes it synchronously
+func (u *IntervalsUploader) Upload(ctx context.Context, activity *DownloadedActivity) (UploadResult, error) {
+	result := UploadResult{Destination: u.Name(), RideID: activity.RideID}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", storedFileName(activity.RideID, activity.Extension))
+	if err != nil {
+		return result, err
+	}
+	file.Write(activity.Data)
+	if err := form.Close(); err != nil {
+		return result, err
+	}
+
+	query := url.Values{"external_id": {strconv.Itoa(activity.RideID)}}
+	if activity.Title != "" {
+		query.Set("name", activity.Title)
+	}
+	endpoint := u.config.BaseURL + "/api/v1/athlete/" + url.PathEscape(u.config.AthleteID) + "/activities?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, &body)
+	if err != nil {
+		return result, err
+	}
+	req.SetBasicAuth("API_KEY", u.config.APIKey)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	res, err := u.client.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return result, err
+	}
+	if res.StatusCode == http.StatusConflict {
+		result.Duplicate = true
+		result.UploadedAt = time.Now().UTC()
+		return result, nil
+	}
+	if res.StatusCode >= 300 {
+		return result, fmt.Errorf("intervals.icu: %s %s", res.Status, strings.TrimSpace(string(data)))
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &created); err != nil {
+		return result, fmt.Errorf("error decoding intervals.icu response: %v", err)
+	}
+	result.RemoteID = created.ID
+	result.UploadedAt = time.Now().UTC()
+	return result, nil
+}
@@ -3,11 +3,13 @@ package igpsportsync
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,6 +23,21 @@ const DEFAULT_PAGE_SIZE = 20
 type Config struct {
 	Username string
 	Password string
+
+	// BaseURL replaces BASE_URL in every API url, e.g. to use a proxy.
+	// Empty uses BASE_URL.
+	BaseURL string
+
+	// DetailCache caches GetActivityDetails results by RideID, nil to disable
+	DetailCache DetailCache
+
+	// OnCacheError is called (if not nil) when DetailCache fails to store a
+	// fetched detail; the fetch itself still succeeds
+	OnCacheError func(rideID int, err error)
+
+	// DetailConcurrency bounds the concurrent requests of GetActivityDetails,
+	// DEFAULT_DETAIL_CONCURRENCY if not positive
+	DetailConcurrency int
 }
 
 type IgpsportSync struct {
@@ -53,8 +70,20 @@ func New(config Config) (*IgpsportSync, error) {
 	return s, nil
 }
 
+// url rewrites an API url for Config.BaseURL
+func (s *IgpsportSync) url(u string) string {
+	if s.Config.BaseURL == "" {
+		return u
+	}
+	return strings.TrimSuffix(s.Config.BaseURL, "/") + "/" + strings.TrimPrefix(u, BASE_URL)
+}
+
 // query activity list
 func (s *IgpsportSync) GetActivityList(pageNo int, pageSize int, beginTime string, endTime string) (resp *ActivityListResponse, err error) {
+	return s.getActivityList(context.Background(), pageNo, pageSize, beginTime, endTime)
+}
+
+func (s *IgpsportSync) getActivityList(ctx context.Context, pageNo int, pageSize int, beginTime string, endTime string) (*ActivityListResponse, error) {
 	if pageNo < 1 {
 		return nil, fmt.Errorf("pageNo must be greater than 0")
 	}
@@ -70,7 +99,7 @@ func (s *IgpsportSync) GetActivityList(pageNo int, pageSize int, beginTime strin
 	}
 
 	// Create HTTP request with query parameters
-	req, err := http.NewRequest("GET", QUERY_URL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url(QUERY_URL), nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %v", err)
 	}
@@ -101,10 +130,36 @@ func (s *IgpsportSync) GetActivityList(pageNo int, pageSize int, beginTime strin
 	if err != nil {
 		return nil, fmt.Errorf("error decoding response: %v", err)
 	}
+	if activityListResp.Code != 0 {
+		return nil, fmt.Errorf("activity list API error: %s (code: %d)", activityListResp.Message, activityListResp.Code)
+	}
 
 	return &activityListResp, nil
 }
 
+// ListAllActivities walks every page of the activity list and returns all rows
+func (s *IgpsportSync) ListAllActivities(beginTime string, endTime string) ([]ActivityRow, error) {
+	return s.listAllActivities(context.Background(), beginTime, endTime)
+}
+
+func (s *IgpsportSync) listAllActivities(ctx context.Context, beginTime string, endTime string) ([]ActivityRow, error) {
+	var rows []ActivityRow
+	page := 1
+	for {
+		resp, err := s.getActivityList(ctx, page, DEFAULT_PAGE_SIZE, beginTime, endTime)
+		if err != nil {
+			return nil, fmt.Errorf("error getting activity list page %d: %v", page, err)
+		}
+		rows = append(rows, resp.Data.Rows...)
+
+		if page >= resp.Data.TotalPage {
+			break
+		}
+		page++
+	}
+	return rows, nil
+}
+
 // try to login and get access token
 func (s *IgpsportSync) Login() error {
 	req := map[string]string{
@@ -117,7 +172,7 @@ func (s *IgpsportSync) Login() error {
 		return err
 	}
 
-	res, err := s.client.Post(LOGIN_URL, "application/json", bytes.NewBuffer(body))
+	res, err := s.client.Post(s.url(LOGIN_URL), "application/json", bytes.NewBuffer(body))
 	if err != nil {
 		return fmt.Errorf("login failed, please check your username and password: %v", err)
 	}
@@ -0,0 +1,287 @@
+package igpsportsync
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MANIFEST_FILE is the name of the manifest written by DiskSink
+const MANIFEST_FILE = "manifest.jsonl"
+
+// ManifestEntry records one downloaded activity file.
+// A manifest is a JSON Lines file with one entry per line; when a ride is
+// downloaded again a new entry is appended and the latest one wins.
+type ManifestEntry struct {
+	RideID       int       `json:"rideId"`
+	Title        string    `json:"title"`
+	StartTime    string    `json:"startTime"`
+	Format       string    `json:"format"`
+	File         string    `json:"file,omitempty"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256,omitempty"`
+	SourceHost   string    `json:"sourceHost,omitempty"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+	Error        string    `json:"error,omitempty"`
+
+	// Requested is the format asked for when the file could not be
+	// converted to it, empty otherwise
+	Requested string `json:"requested,omitempty"`
+
+	// Deleted marks the file as removed because the ride is no longer
+	// listed by iGPSport
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// NewManifestEntry builds a manifest entry for a downloaded activity.
+// file is the path of the stored file relative to the manifest, empty when
+// nothing was stored.
+func NewManifestEntry(activity *DownloadedActivity, file string) ManifestEntry {
+	entry := ManifestEntry{
+		RideID:       activity.RideID,
+		Title:        activity.Title,
+		StartTime:    activity.StartTime,
+		Format:       activity.Extension.Name(),
+		File:         file,
+		DownloadedAt: time.Now().UTC(),
+	}
+	if activity.Requested != "" && activity.Requested.Name() != entry.Format {
+		entry.Requested = activity.Requested.Name()
+	}
+	if u, err := url.Parse(activity.SourceURL); err == nil {
+		entry.SourceHost = u.Host
+	}
+	if activity.Error != nil {
+		entry.Error = activity.Error.Error()
+		return entry
+	}
+	sum := sha256.Sum256(activity.Data)
+	entry.Size = int64(len(activity.Data))
+	entry.SHA256 = hex.EncodeToString(sum[:])
+	return entry
+}
+
+// ManifestWriter appends entries to a manifest file, safe for concurrent use
+type ManifestWriter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenManifest opens the manifest at path for appending, creating it if needed
+func OpenManifest(path string) (*ManifestWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening manifest: %v", err)
+	}
+	return &ManifestWriter{file: f}, nil
+}
+
+// Write appends one entry to the manifest
+func (m *ManifestWriter) Write(entry ManifestEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding manifest entry: %v", err)
+	}
+	line = append(line, '\n')
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.file.Write(line); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return nil
+}
+
+// Close closes the underlying manifest file
+func (m *ManifestWriter) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.file.Close()
+}
+
+// ReadManifest reads all entries of a manifest file in the order they were written
+func ReadManifest(path string) ([]ManifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening manifest: %v", err)
+	}
+	defer f.Close()
+
+	var entries []ManifestEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry ManifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error decoding manifest line %d: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+	return entries, nil
+}
+
+// LatestManifestEntries keeps only the last entry for each RideID and format,
+// preserving the order in which they were first seen
+func LatestManifestEntries(entries []ManifestEntry) []ManifestEntry {
+	index := make(map[string]int)
+	var latest []ManifestEntry
+	for _, entry := range entries {
+		key := strconv.Itoa(entry.RideID) + "." + entry.Format
+		if i, ok := index[key]; ok {
+			latest[i] = entry
+			continue
+		}
+		index[key] = len(latest)
+		latest = append(latest, entry)
+	}
+	return latest
+}
+
+// entryPerRide keeps one entry per RideID, the FIT file when the ride has
+// one, else the first seen, in the order rides were first seen
+func entryPerRide(entries []ManifestEntry) []ManifestEntry {
+	var rides []ManifestEntry
+	index := make(map[int]int)
+	for _, entry := range entries {
+		i, ok := index[entry.RideID]
+		switch {
+		case !ok:
+			index[entry.RideID] = len(rides)
+			rides = append(rides, entry)
+		case entry.Format == FIT.Name() && rides[i].Format != FIT.Name():
+			rides[i] = entry
+		}
+	}
+	return rides
+}
+
+// VerifyStatus is the outcome of verifying one manifest entry
+type VerifyStatus string
+
+const (
+	VERIFY_OK        VerifyStatus = "ok"
+	VERIFY_MISSING   VerifyStatus = "missing"
+	VERIFY_CORRUPTED VerifyStatus = "corrupted"
+	VERIFY_FAILED    VerifyStatus = "failed" // the download itself had failed
+)
+
+// VerifyResult is the verification outcome of a single manifest entry
+type VerifyResult struct {
+	Entry  ManifestEntry
+	Status VerifyStatus
+	Detail string
+}
+
+// VerifyReport summarizes the verification of a manifest
+type VerifyReport struct {
+	Results   []VerifyResult
+	OK        int
+	Missing   int
+	Corrupted int
+	Failed    int
+}
+
+// Problems returns the results that are not VERIFY_OK
+func (r *VerifyReport) Problems() []VerifyResult {
+	var problems []VerifyResult
+	for _, result := range r.Results {
+		if result.Status != VERIFY_OK {
+			problems = append(problems, result)
+		}
+	}
+	return problems
+}
+
+// VerifyManifest re-hashes the files listed in the manifest at path and
+// reports missing or corrupted ones. Files are read from storage, e.g. the
+// DiskSink.Storage that received them; a nil storage reads them from the
+// manifest's directory.
+func VerifyManifest(ctx context.Context, path string, storage Storage) (*VerifyReport, error) {
+	entries, err := ReadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	if storage == nil {
+		storage = &LocalStorage{Dir: filepath.Dir(path)}
+	}
+
+	report := &VerifyReport{}
+	for _, entry := range LatestManifestEntries(entries) {
+		if entry.Deleted {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		result := verifyEntry(ctx, storage, entry)
+		switch result.Status {
+		case VERIFY_OK:
+			report.OK++
+		case VERIFY_MISSING:
+			report.Missing++
+		case VERIFY_CORRUPTED:
+			report.Corrupted++
+		case VERIFY_FAILED:
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+func verifyEntry(ctx context.Context, storage Storage, entry ManifestEntry) VerifyResult {
+	result := VerifyResult{Entry: entry, Status: VERIFY_OK}
+	if entry.Error != "" || entry.File == "" {
+		result.Status = VERIFY_FAILED
+		result.Detail = entry.Error
+		return result
+	}
+	ext, err := ParseExtension(entry.Format)
+	if err != nil {
+		result.Status = VERIFY_CORRUPTED
+		result.Detail = err.Error()
+		return result
+	}
+
+	data, err := storage.Get(ctx, entry.RideID, ext)
+	if errors.Is(err, ErrNotStored) {
+		result.Status = VERIFY_MISSING
+		result.Detail = err.Error()
+		return result
+	}
+	if err != nil {
+		result.Status = VERIFY_CORRUPTED
+		result.Detail = err.Error()
+		return result
+	}
+
+	if size := int64(len(data)); size != entry.Size {
+		result.Status = VERIFY_CORRUPTED
+		result.Detail = fmt.Sprintf("size %d, expected %d", size, entry.Size)
+		return result
+	}
+	sum := sha256.Sum256(data)
+	if hash := hex.EncodeToString(sum[:]); hash != entry.SHA256 {
+		result.Status = VERIFY_CORRUPTED
+		result.Detail = fmt.Sprintf("sha256 %s, expected %s", hash, entry.SHA256)
+	}
+	return result
+}
@@ -0,0 +1,430 @@
+package igpsportsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EVENT_ACTIVITY_CREATED is the event of a newly synced activity
+const EVENT_ACTIVITY_CREATED = "activity.created"
+
+// Headers of a webhook delivery. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)), see SignWebhook.
+const (
+	WEBHOOK_SIGNATURE_HEADER = "X-Igpsport-Signature"
+	WEBHOOK_TIMESTAMP_HEADER = "X-Igpsport-Timestamp"
+	WEBHOOK_EVENT_HEADER     = "X-Igpsport-Event"
+)
+
+// ActivityEvent is the JSON payload POSTed to webhooks
+type ActivityEvent struct {
+	// ID is the same for every delivery of an activity, so receivers can
+	// drop repeated deliveries
+	ID     string `json:"id"`
+	Event  string `json:"event"`
+	RideID int    `json:"rideId"`
+
+	// Row and Detail are attached when the download had them
+	// (see DownloadOptions.IncludeDetail)
+	Row    *ActivityRow        `json:"row,omitempty"`
+	Detail *ActivityDetailData `json:"detail,omitempty"`
+
+	// Analytics is nil when the file has no power or heart rate data
+	Analytics *CatalogSummary `json:"analytics,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Webhook is a URL notified of new activities. Deliveries are signed when
+// Secret is set.
+type Webhook struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// NotifierConfig configures a Notifier
+type NotifierConfig struct {
+	Webhooks []Webhook
+
+	// MaxAttempts is the number of tries per webhook, default 5
+	MaxAttempts int
+
+	// Backoff is the wait after the first failed attempt, doubled after
+	// each further one, default 1s
+	Backoff time.Duration
+
+	// MaxDeliveryTime bounds all attempts of a delivery to one webhook,
+	// default 1 minute
+	MaxDeliveryTime time.Duration
+
+	// QueueSize is the number of events Callback queues for delivery,
+	// default 100. Events arriving at a full queue go to the dead letter
+	// file so a slow webhook never holds up the download.
+	QueueSize int
+
+	// DeadLetterPath is a JSON Lines file receiving deliveries that failed
+	// every attempt (optional)
+	DeadLetterPath string
+
+	// FTP and HeartRate are used for the analytics of the payload
+	FTP       int
+	HeartRate HeartRateOptions
+
+	// Client defaults to an http.Client with a 30 second timeout
+	Client *http.Client
+}
+
+// DeadLetter is a failed delivery kept in NotifierConfig.DeadLetterPath
+type DeadLetter struct {
+	URL      string          `json:"url"`
+	Event    json.RawMessage `json:"event"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	FailedAt time.Time       `json:"failedAt"`
+}
+
+// Notifier POSTs activity events to webhooks with retries. It is safe for
+// concurrent use.
+type Notifier struct {
+	config NotifierConfig
+	client *http.Client
+
+	mu         sync.Mutex // guards the dead letter file
+	redelivery sync.Mutex // serializes Redeliver
+
+	// queue feeds the worker started by the first Callback event
+	start sync.Once
+	stop  sync.Once
+	queue chan queuedEvent
+	done  chan struct{}
+}
+
+type queuedEvent struct {
+	ctx    context.Context
+	event  ActivityEvent
+	report func(rideID int, err error)
+}
+
+func NewNotifier(config NotifierConfig) *Notifier {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = time.Second
+	}
+	if config.MaxDeliveryTime <= 0 {
+		config.MaxDeliveryTime = time.Minute
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Notifier{config: config, client: client}
+}
+
+// SignWebhook returns the signature header value of a delivery
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewEvent builds the event of a downloaded activity, analyzing its file
+func (n *Notifier) NewEvent(activity *DownloadedActivity) ActivityEvent {
+	event := ActivityEvent{
+		ID:        "igpsport-" + strconv.Itoa(activity.RideID),
+		Event:     EVENT_ACTIVITY_CREATED,
+		RideID:    activity.RideID,
+		Row:       activity.Row,
+		Detail:    activity.Detail,
+		CreatedAt: time.Now().UTC(),
+	}
+	if activity.Error != nil {
+		return event
+	}
+	if track, err := DecodeTrack(activity.Data, activity.Extension); err == nil {
+		power, _ := AnalyzePower(track, n.config.FTP)
+		heartRate, _ := AnalyzeHeartRate(track, n.config.HeartRate)
+		if power != nil || heartRate != nil {
+			summary := NewCatalogSummary(power, heartRate)
+			event.Analytics = &summary
+		}
+	}
+	return event
+}
+
+// Notify delivers an event to every webhook. Deliveries failing all
+// attempts are written to the dead letter file and joined in the error.
+func (n *Notifier) Notify(ctx context.Context, event ActivityEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, hook := range n.config.Webhooks {
+		attempts, err := n.deliver(ctx, hook, event.Event, body)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("error notifying %s of activity %d: %v", hook.URL, event.RideID, err))
+		if err := n.deadLetter(hook.URL, body, err, attempts); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deliver POSTs the body until it is accepted, a client error other than
+// 429 is returned, or the attempts or MaxDeliveryTime run out
+func (n *Notifier) deliver(ctx context.Context, hook Webhook, event string, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, n.config.MaxDeliveryTime)
+	defer cancel()
+	backoff := n.config.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = n.post(ctx, hook, event, body)
+		if err == nil || !retry || attempt == n.config.MaxAttempts {
+			return attempt, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, hook Webhook, event string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_EVENT_HEADER, event)
+	req.Header.Set(WEBHOOK_TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	if hook.Secret != "" {
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, SignWebhook(hook.Secret, timestamp, body))
+	}
+	res, err := n.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	res.Body.Close()
+	if res.StatusCode < 300 {
+		return false, nil
+	}
+	retry = res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%s %s", res.Status, strings.TrimSpace(string(message)))
+}
+
+func (n *Notifier) deadLetter(url string, body []byte, cause error, attempts int) error {
+	if n.config.DeadLetterPath == "" {
+		return nil
+	}
+	line, err := json.Marshal(DeadLetter{
+		URL:      url,
+		Event:    body,
+		Error:    cause.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.config.DeadLetterPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening dead letter file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing dead letter file: %v", err)
+	}
+	return nil
+}
+
+func readDeadLetters(path string) ([]DeadLetter, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening dead letter file: %v", err)
+	}
+	defer f.Close()
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			return nil, fmt.Errorf("error decoding dead letter file: %v", err)
+		}
+		letters = append(letters, letter)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading dead letter file: %v", err)
+	}
+	return letters, nil
+}
+
+// Redeliver retries the deliveries of the dead letter file to webhooks
+// still configured and rewrites the file with the ones failing again,
+// keeping deliveries that failed meanwhile. It returns the number of
+// deliveries that succeeded.
+func (n *Notifier) Redeliver(ctx context.Context) (int, error) {
+	if n.config.DeadLetterPath == "" {
+		return 0, nil
+	}
+	n.redelivery.Lock()
+	defer n.redelivery.Unlock()
+
+	// the file is only appended to until it is rewritten below, so the
+	// letters read now stay its first lines
+	n.mu.Lock()
+	letters, err := readDeadLetters(n.config.DeadLetterPath)
+	n.mu.Unlock()
+	if err != nil || len(letters) == 0 {
+		return 0, err
+	}
+
+	hooks := make(map[string]Webhook)
+	for _, hook := range n.config.Webhooks {
+		hooks[hook.URL] = hook
+	}
+	delivered := 0
+	var remaining []DeadLetter
+	for _, letter := range letters {
+		hook, ok := hooks[letter.URL]
+		if ok && ctx.Err() == nil {
+			var event struct {
+				Event string `json:"event"`
+			}
+			json.Unmarshal(letter.Event, &event)
+			attempts, err := n.deliver(ctx, hook, event.Event, letter.Event)
+			if err == nil {
+				delivered++
+				continue
+			}
+			letter.Error, letter.Attempts, letter.FailedAt = err.Error(), letter.Attempts+attempts, time.Now().UTC()
+		}
+		remaining = append(remaining, letter)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	current, err := readDeadLetters(n.config.DeadLetterPath)
+	if err != nil {
+		return delivered, err
+	}
+	if len(current) > len(letters) {
+		remaining = append(remaining, current[len(letters):]...)
+	}
+	var buf bytes.Buffer
+	for _, letter := range remaining {
+		line, err := json.Marshal(letter)
+		if err != nil {
+			return delivered, err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if err := writeFileAtomic(n.config.DeadLetterPath, buf.Bytes()); err != nil {
+		return delivered, err
+	}
+	return delivered, ctx.Err()
+}
+
+// Callback returns a DownloadCallback that queues a notification of every
+// successfully downloaded activity, then calls next (if not nil). Use it
+// with DownloadOptions.State so only new activities are announced.
+// Notifications are delivered by a background worker, so a slow webhook
+// does not hold up the download; call Close when the download is done to
+// wait for them. Failed notifications are passed to report (if not nil)
+// from the worker.
+func (n *Notifier) Callback(ctx context.Context, report func(rideID int, err error), next DownloadCallback) DownloadCallback {
+	return func(activity *DownloadedActivity) bool {
+		if activity.Error == nil {
+			n.enqueue(ctx, n.NewEvent(activity), report)
+		}
+		if next == nil {
+			return true
+		}
+		return next(activity)
+	}
+}
+
+func (n *Notifier) enqueue(ctx context.Context, event ActivityEvent, report func(rideID int, err error)) {
+	n.start.Do(func() {
+		n.queue = make(chan queuedEvent, n.config.QueueSize)
+		n.done = make(chan struct{})
+		go n.work()
+	})
+	select {
+	case n.queue <- queuedEvent{ctx: ctx, event: event, report: report}:
+		return
+	default:
+	}
+
+	cause := errors.New("notification queue full")
+	errs := []error{cause}
+	body, err := json.Marshal(event)
+	if err == nil {
+		for _, hook := range n.config.Webhooks {
+			errs = append(errs, n.deadLetter(hook.URL, body, cause, 0))
+		}
+	}
+	if report != nil {
+		report(event.RideID, errors.Join(append(errs, err)...))
+	}
+}
+
+func (n *Notifier) work() {
+	defer close(n.done)
+	for queued := range n.queue {
+		err := n.Notify(queued.ctx, queued.event)
+		if err != nil && queued.report != nil {
+			queued.report(queued.event.RideID, err)
+		}
+	}
+}
+
+// Close waits for the notifications queued by Callback to be delivered
+// (or dead lettered). Callbacks must not be called after Close.
+func (n *Notifier) Close() {
+	n.start.Do(func() {})
+	n.stop.Do(func() {
+		if n.queue != nil {
+			close(n.queue)
+		}
+	})
+	if n.done != nil {
+		<-n.done
+	}
+}
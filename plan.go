@@ -0,0 +1,137 @@
+package igpsportsync
+
+import (
+	"context"
+	"fmt"
+)
+
+// PlanAction is what a sync would do with an activity
+type PlanAction string
+
+const (
+	PLAN_DOWNLOAD  PlanAction = "download"
+	PLAN_SYNCED    PlanAction = "synced"    // already in DownloadOptions.State
+	PLAN_FILTERED  PlanAction = "filtered"  // rejected by the filter
+	PLAN_DUPLICATE PlanAction = "duplicate" // duplicates an earlier listed ride
+	PLAN_DELETE    PlanAction = "delete"    // stored locally but no longer listed
+)
+
+// PlanItem is the planned action for one activity
+type PlanItem struct {
+	RideID      int        `json:"rideId"`
+	Title       string     `json:"title"`
+	StartTime   string     `json:"startTime"`
+	Action      PlanAction `json:"action"`
+	DuplicateOf int        `json:"duplicateOf,omitempty"`
+}
+
+// SyncPlan is the outcome of PlanDownload
+type SyncPlan struct {
+	// Items holds the listed activities in listing order, followed by the
+	// PLAN_DELETE ones
+	Items []PlanItem
+
+	Counts map[PlanAction]int
+
+	// Requests estimates the API requests of the run: the listing pages,
+	// then the download url and the file of every download, plus its
+	// detail with DownloadOptions.IncludeDetail
+	Requests int
+}
+
+// RideIDs returns the RideIDs planned for an action, in plan order
+func (p *SyncPlan) RideIDs(action PlanAction) []int {
+	var ids []int
+	for _, item := range p.Items {
+		if item.Action == action {
+			ids = append(ids, item.RideID)
+		}
+	}
+	return ids
+}
+
+// PlanDownload walks the activity list like DownloadAllActivitiesContext
+// would with the same options, without fetching any detail or file data,
+// and reports what the run would do. Rides in options.State that start
+// within BeginTime and EndTime but are no longer listed are planned for
+// deletion.
+//
+// Listed rows are recorded in options.Filter.Deduplicator, so the actual
+// run needs a fresh one.
+func (s *IgpsportSync) PlanDownload(ctx context.Context, options DownloadOptions) (*SyncPlan, error) {
+	plan := &SyncPlan{Counts: make(map[PlanAction]int)}
+	add := func(item PlanItem) {
+		plan.Items = append(plan.Items, item)
+		plan.Counts[item.Action]++
+	}
+
+	listed := make(map[int]bool)
+	page := 1
+	for {
+		resp, err := s.getActivityList(ctx, page, DEFAULT_PAGE_SIZE, options.BeginTime, options.EndTime)
+		if err != nil {
+			return nil, fmt.Errorf("error getting activity list page %d: %v", page, err)
+		}
+		plan.Requests++
+
+		for _, row := range resp.Data.Rows {
+			listed[row.RideID] = true
+			item := PlanItem{RideID: row.RideID, Title: row.Title, StartTime: row.StartTime, Action: PLAN_DOWNLOAD}
+			if !options.Filter.matchFields(row) {
+				item.Action = PLAN_FILTERED
+			} else if options.Filter != nil && options.Filter.Deduplicator != nil {
+				if of, dup := options.Filter.Deduplicator.CheckRow(row); dup {
+					item.Action, item.DuplicateOf = PLAN_DUPLICATE, of
+				}
+			}
+			if item.Action == PLAN_DOWNLOAD && options.State != nil && options.State.Synced(row.RideID, options.Extension) {
+				item.Action = PLAN_SYNCED
+			}
+			add(item)
+		}
+
+		if page >= resp.Data.TotalPage {
+			break
+		}
+		page++
+	}
+
+	perDownload := 2
+	if options.IncludeDetail {
+		perDownload++
+	}
+	plan.Requests += perDownload * plan.Counts[PLAN_DOWNLOAD]
+
+	if options.State == nil {
+		return plan, nil
+	}
+	entries, err := options.State.Activities()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if listed[entry.RideID] || !inListingWindow(entry.StartTime, options.BeginTime, options.EndTime) {
+			continue
+		}
+		listed[entry.RideID] = true // once per ride, whatever the formats
+		add(PlanItem{RideID: entry.RideID, Title: entry.Title, StartTime: entry.StartTime, Action: PLAN_DELETE})
+	}
+	return plan, nil
+}
+
+// inListingWindow reports whether an activity starting at startTime falls
+// within the "2006-01-02" beginTime and endTime of GetActivityList, both
+// inclusive and optional
+func inListingWindow(startTime, beginTime, endTime string) bool {
+	if len(startTime) < len(dateLayout) {
+		return beginTime == "" && endTime == ""
+	}
+	date := startTime[:len(dateLayout)]
+	if beginTime != "" && date < beginTime {
+		return false
+	}
+	if endTime != "" && date > endTime {
+		return false
+	}
+	return true
+}
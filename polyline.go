@@ -0,0 +1,74 @@
+package igpsportsync
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// LatLng is a coordinate in degrees
+type LatLng struct {
+	Lat float64
+	Lng float64
+}
+
+// EncodePolyline encodes coordinates with the Google encoded polyline
+// algorithm at precision 5, as used by Strava's summary_polyline
+func EncodePolyline(points []LatLng) string {
+	var sb strings.Builder
+	var lastLat, lastLng int64
+	for _, p := range points {
+		lat := int64(math.Round(p.Lat * 1e5))
+		lng := int64(math.Round(p.Lng * 1e5))
+		encodePolylineValue(&sb, lat-lastLat)
+		encodePolylineValue(&sb, lng-lastLng)
+		lastLat, lastLng = lat, lng
+	}
+	return sb.String()
+}
+
+func encodePolylineValue(sb *strings.Builder, v int64) {
+	u := uint64(v << 1)
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		sb.WriteByte(byte((0x20 | (u & 0x1F)) + 63))
+		u >>= 5
+	}
+	sb.WriteByte(byte(u + 63))
+}
+
+// DecodePolyline decodes a Google encoded polyline at precision 5
+func DecodePolyline(encoded string) ([]LatLng, error) {
+	var points []LatLng
+	var lat, lng int64
+	for i := 0; i < len(encoded); {
+		var deltas [2]int64
+		for j := range deltas {
+			var result uint64
+			var shift uint
+			for {
+				if i >= len(encoded) {
+					return nil, fmt.Errorf("polyline: truncated input")
+				}
+				b := uint64(encoded[i]) - 63
+				i++
+				result |= (b & 0x1F) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			if result&1 != 0 {
+				deltas[j] = ^int64(result >> 1)
+			} else {
+				deltas[j] = int64(result >> 1)
+			}
+		}
+		lat += deltas[0]
+		lng += deltas[1]
+		points = append(points, LatLng{Lat: float64(lat) / 1e5, Lng: float64(lng) / 1e5})
+	}
+	return points, nil
+}
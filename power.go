@@ -0,0 +1,230 @@
+package igpsportsync
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Zone is a training zone bounded by fractions of a reference value
+// (FTP for power, LTHR or max HR for heart rate). Max is exclusive.
+type Zone struct {
+	Number int
+	Name   string
+	Min    float64
+	Max    float64
+}
+
+// ZoneTime is the time spent in a zone
+type ZoneTime struct {
+	Zone     Zone
+	Duration time.Duration
+}
+
+// COGGAN_POWER_ZONES are Andrew Coggan's power zones as fractions of FTP
+var COGGAN_POWER_ZONES = []Zone{
+	{1, "Active Recovery", 0, 0.55},
+	{2, "Endurance", 0.55, 0.75},
+	{3, "Tempo", 0.75, 0.90},
+	{4, "Lactate Threshold", 0.90, 1.05},
+	{5, "VO2max", 1.05, 1.20},
+	{6, "Anaerobic Capacity", 1.20, 1.50},
+	{7, "Neuromuscular Power", 1.50, math.Inf(1)},
+}
+
+// POWER_CURVE_DURATIONS are the durations reported in PowerSummary.PowerCurve
+var POWER_CURVE_DURATIONS = []time.Duration{
+	5 * time.Second, time.Minute, 5 * time.Minute, 20 * time.Minute, 60 * time.Minute,
+}
+
+// MAX_SAMPLE_GAP is the longest gap between samples that is filled when
+// resampling to one second; longer gaps are treated as paused time
+const MAX_SAMPLE_GAP = 5 * time.Second
+
+// PowerCurvePoint is the best average power held for a duration
+type PowerCurvePoint struct {
+	Duration time.Duration
+	Watts    float64
+}
+
+// PowerSummary is the power analysis of one activity
+type PowerSummary struct {
+	FTP              int
+	Duration         time.Duration // time covered by power samples
+	AveragePower     float64
+	MaxPower         float64
+	NormalizedPower  float64
+	IntensityFactor  float64
+	TSS              float64
+	VariabilityIndex float64
+	Work             float64 // kJ
+
+	// PowerCurve has one point per POWER_CURVE_DURATIONS entry the ride is long enough for
+	PowerCurve  []PowerCurvePoint
+	TimeInZones []ZoneTime
+}
+
+// AnalyzePower computes power metrics of a track for a rider with the given
+// FTP, e.g. UserInfoResult.Ftp. FTP dependent values (IF, TSS, zones) are
+// left zero when ftp is not positive.
+func AnalyzePower(track *Track, ftp int) (*PowerSummary, error) {
+	samples := resample(track, func(p TrackPoint) (float64, bool) {
+		return float64(p.Power), p.HasPower
+	})
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("activity has no power data")
+	}
+
+	summary := &PowerSummary{
+		FTP:             ftp,
+		Duration:        time.Duration(len(samples)) * time.Second,
+		AveragePower:    mean(samples),
+		NormalizedPower: normalizedPower(samples),
+	}
+	for _, w := range samples {
+		summary.MaxPower = math.Max(summary.MaxPower, w)
+		summary.Work += w / 1000
+	}
+	if summary.AveragePower > 0 {
+		summary.VariabilityIndex = summary.NormalizedPower / summary.AveragePower
+	}
+	summary.PowerCurve = powerCurve(samples, POWER_CURVE_DURATIONS)
+
+	if ftp > 0 {
+		summary.IntensityFactor = summary.NormalizedPower / float64(ftp)
+		summary.TSS = summary.Duration.Seconds() * summary.NormalizedPower * summary.IntensityFactor / (float64(ftp) * 3600) * 100
+		summary.TimeInZones = timeInZones(samples, float64(ftp), COGGAN_POWER_ZONES)
+	}
+	return summary, nil
+}
+
+// resample returns one value per second of recording, see resamplePoints
+func resample(track *Track, value func(TrackPoint) (float64, bool)) []float64 {
+	points := resamplePoints(track, func(p TrackPoint) bool {
+		_, ok := value(p)
+		return ok
+	})
+	out := make([]float64, len(points))
+	for i, p := range points {
+		out[i], _ = value(p)
+	}
+	return out
+}
+
+// resamplePoints returns one point per second of recording among the points
+// accepted by keep. Each point holds until the next one; gaps longer than
+// MAX_SAMPLE_GAP contribute one second only. A single point yields one value.
+func resamplePoints(track *Track, keep func(TrackPoint) bool) []TrackPoint {
+	var out []TrackPoint
+	var last *TrackPoint
+	for i := range track.Points {
+		p := &track.Points[i]
+		if !keep(*p) || p.Time.IsZero() {
+			continue
+		}
+		if last != nil {
+			n := int(p.Time.Sub(last.Time) / time.Second)
+			if n > int(MAX_SAMPLE_GAP/time.Second) {
+				n = 1
+			}
+			for j := 0; j < n; j++ {
+				out = append(out, *last)
+			}
+		}
+		last = p
+	}
+	if last != nil && len(out) == 0 {
+		out = append(out, *last)
+	}
+	return out
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// normalizedPower is the fourth root of the mean of the fourth power of the
+// 30 second rolling average
+func normalizedPower(samples []float64) float64 {
+	const window = 30
+	if len(samples) < window {
+		return mean(samples)
+	}
+	var sum, total float64
+	n := 0
+	for i, w := range samples {
+		sum += w
+		if i >= window {
+			sum -= samples[i-window]
+		}
+		if i >= window-1 {
+			avg := sum / window
+			total += avg * avg * avg * avg
+			n++
+		}
+	}
+	return math.Pow(total/float64(n), 0.25)
+}
+
+// bestAverage returns the highest average over any window of n samples
+func bestAverage(samples []float64, n int) float64 {
+	if n <= 0 || n > len(samples) {
+		return 0
+	}
+	var sum float64
+	for _, v := range samples[:n] {
+		sum += v
+	}
+	best := sum
+	for i := n; i < len(samples); i++ {
+		sum += samples[i] - samples[i-n]
+		best = math.Max(best, sum)
+	}
+	return best / float64(n)
+}
+
+func powerCurve(samples []float64, durations []time.Duration) []PowerCurvePoint {
+	var curve []PowerCurvePoint
+	for _, d := range durations {
+		n := int(d / time.Second)
+		if n > len(samples) {
+			break
+		}
+		curve = append(curve, PowerCurvePoint{Duration: d, Watts: bestAverage(samples, n)})
+	}
+	return curve
+}
+
+// timeInZones counts one second per sample in the zone containing value/reference
+func timeInZones(samples []float64, reference float64, zones []Zone) []ZoneTime {
+	out := make([]ZoneTime, len(zones))
+	for i, zone := range zones {
+		out[i].Zone = zone
+	}
+	for _, v := range samples {
+		ratio := v / reference
+		for i, zone := range zones {
+			if ratio >= zone.Min && ratio < zone.Max {
+				out[i].Duration += time.Second
+				break
+			}
+		}
+	}
+	return out
+}
+
+// AnalyzePower analyzes a track with the FTP from the rider's profile
+func (s *IgpsportSync) AnalyzePower(track *Track) (*PowerSummary, error) {
+	userInfo, err := s.GetUserInfo()
+	if err != nil {
+		return nil, err
+	}
+	return AnalyzePower(track, userInfo.Data.Ftp)
+}
@@ -0,0 +1,355 @@
+package igpsportsync
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrivacyOptions selects the GPS points removed by SanitizeActivity
+type PrivacyOptions struct {
+	// Zones removes every point inside one of the zones
+	Zones []PrivacyZone
+
+	// TrimStart and TrimEnd remove the points within this many meters
+	// (along the route) of the start and the end
+	TrimStart float64
+	TrimEnd   float64
+}
+
+// SanitizeActivity returns a copy of the activity file with the points
+// selected by options removed and distances recomputed over the remaining
+// route. FIT records cannot be dropped in place, so their position is
+// blanked instead; GPX and TCX points are removed. data is not modified.
+func SanitizeActivity(data []byte, ext Extension, options PrivacyOptions) ([]byte, error) {
+	switch ext {
+	case GPX:
+		return sanitizeXML(data, "trkpt", options)
+	case TCX:
+		return sanitizeXML(data, "Trackpoint", options)
+	default:
+		return sanitizeFIT(data, options)
+	}
+}
+
+// Sanitized returns a copy of the activity with sanitized file data
+func (a *DownloadedActivity) Sanitized(options PrivacyOptions) (*DownloadedActivity, error) {
+	if a.Error != nil {
+		return nil, fmt.Errorf("activity %d has no data: %v", a.RideID, a.Error)
+	}
+	data, err := SanitizeActivity(a.Data, a.Extension, options)
+	if err != nil {
+		return nil, err
+	}
+	sanitized := *a
+	sanitized.Data = data
+	return &sanitized, nil
+}
+
+// privacyMask reports which points to remove, and the distance of every
+// point along the route of kept points
+func privacyMask(points []LatLng, hasPosition []bool, options PrivacyOptions) ([]bool, []float64) {
+	mask := make([]bool, len(points))
+	distances := make([]float64, len(points))
+
+	// distance along the original route, for start/end trimming
+	along := make([]float64, len(points))
+	var total float64
+	last := -1
+	for i := range points {
+		if !hasPosition[i] {
+			continue
+		}
+		if last >= 0 {
+			total += Haversine(points[last].Lat, points[last].Lng, points[i].Lat, points[i].Lng)
+		}
+		along[i] = total
+		last = i
+	}
+
+	first, final := -1, -1
+	for i := range points {
+		if !hasPosition[i] {
+			continue
+		}
+		mask[i] = along[i] < options.TrimStart || total-along[i] < options.TrimEnd || inAnyZone(points[i], options.Zones)
+		if !mask[i] {
+			if first < 0 {
+				first = i
+			}
+			final = i
+		}
+	}
+	if last < 0 {
+		return mask, distances // no positions at all, nothing to hide
+	}
+	// points without a position are kept only between the kept route ends
+	for i := range points {
+		if !hasPosition[i] && (first < 0 || i < first || i > final) {
+			mask[i] = true
+		}
+	}
+
+	var walked float64
+	kept := -1
+	for i := range points {
+		if hasPosition[i] && !mask[i] {
+			if kept >= 0 {
+				walked += Haversine(points[kept].Lat, points[kept].Lng, points[i].Lat, points[i].Lng)
+			}
+			kept = i
+		}
+		distances[i] = walked
+	}
+	return mask, distances
+}
+
+// FIT session and lap fields holding positions, blanked when sanitizing
+var fitSummaryPositionFields = map[uint16][]uint8{
+	FIT_MESG_SESSION: {3, 4, 29, 30, 31, 32},
+	FIT_MESG_LAP:     {3, 4, 5, 6},
+}
+
+func sanitizeFIT(original []byte, options PrivacyOptions) ([]byte, error) {
+	data := append([]byte(nil), original...)
+	f, err := decodeFIT(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*fitMessage
+	var points []LatLng
+	var hasPosition []bool
+	for i := range f.messages {
+		m := &f.messages[i]
+		if m.num != FIT_MESG_RECORD {
+			continue
+		}
+		lat, okLat := f.sint(m, 0)
+		lon, okLon := f.sint(m, 1)
+		records = append(records, m)
+		points = append(points, LatLng{Lat: fitSemicircles(lat), Lng: fitSemicircles(lon)})
+		hasPosition = append(hasPosition, okLat && okLon)
+	}
+
+	mask, distances := privacyMask(points, hasPosition, options)
+	var total float64
+	for i, m := range records {
+		if mask[i] {
+			f.invalidate(m, 0)
+			f.invalidate(m, 1)
+		}
+		if _, ok := f.uint(m, 5); ok {
+			f.put(m, 5, uint64(distances[i]*100+0.5))
+		}
+		total = distances[i]
+	}
+
+	// a lap covers the records since the previous lap message
+	var seen int
+	var lapStart float64
+	for i := range f.messages {
+		m := &f.messages[i]
+		for _, field := range fitSummaryPositionFields[m.num] {
+			f.invalidate(m, field)
+		}
+		switch m.num {
+		case FIT_MESG_RECORD:
+			seen++
+		case FIT_MESG_LAP:
+			lapEnd := lapStart
+			if seen > 0 {
+				lapEnd = distances[seen-1]
+			}
+			if _, ok := f.uint(m, 9); ok {
+				f.put(m, 9, uint64((lapEnd-lapStart)*100+0.5))
+			}
+			lapStart = lapEnd
+		case FIT_MESG_SESSION:
+			if _, ok := f.uint(m, 9); ok {
+				f.put(m, 9, uint64(total*100+0.5))
+			}
+		}
+	}
+	f.updateCRC()
+	return data, nil
+}
+
+// xmlPoint is a GPX trkpt or TCX Trackpoint with the byte ranges needed to
+// remove it or rewrite its distance
+type xmlPoint struct {
+	start, end         int
+	position           LatLng
+	hasPosition        bool
+	distStart, distEnd int
+}
+
+// xmlLap is a TCX Lap with the byte range of its DistanceMeters text
+type xmlLap struct {
+	distStart, distEnd int
+	first, last        int // point indices
+}
+
+func scanXMLPoints(data []byte, pointElem string) ([]xmlPoint, []xmlLap, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var points []xmlPoint
+	var laps []xmlLap
+	var stack []string
+	var current *xmlPoint
+	var hasLat, hasLon bool
+
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("xml: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			switch t.Name.Local {
+			case pointElem:
+				current = &xmlPoint{start: offset, distStart: -1}
+				hasLat, hasLon = false, false
+				for _, attr := range t.Attr {
+					v, err := strconv.ParseFloat(attr.Value, 64)
+					if err != nil {
+						continue
+					}
+					switch attr.Name.Local {
+					case "lat":
+						current.position.Lat, hasLat = v, true
+					case "lon":
+						current.position.Lng, hasLon = v, true
+					}
+				}
+			case "Lap":
+				laps = append(laps, xmlLap{distStart: -1, first: len(points), last: -1})
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			switch t.Name.Local {
+			case pointElem:
+				if current != nil {
+					current.end = int(decoder.InputOffset())
+					current.hasPosition = hasLat && hasLon
+					points = append(points, *current)
+					current = nil
+				}
+			case "Lap":
+				if len(laps) > 0 {
+					laps[len(laps)-1].last = len(points) - 1
+				}
+			}
+		case xml.CharData:
+			if len(stack) < 2 {
+				continue
+			}
+			name, parent := stack[len(stack)-1], stack[len(stack)-2]
+			text := strings.TrimSpace(string(t))
+			end := int(decoder.InputOffset())
+			switch {
+			case current != nil && name == "LatitudeDegrees" && parent == "Position":
+				current.position.Lat, _ = strconv.ParseFloat(text, 64)
+				hasLat = true
+			case current != nil && name == "LongitudeDegrees" && parent == "Position":
+				current.position.Lng, _ = strconv.ParseFloat(text, 64)
+				hasLon = true
+			case current != nil && name == "DistanceMeters" && parent == pointElem:
+				current.distStart, current.distEnd = offset, end
+			case name == "DistanceMeters" && parent == "Lap" && len(laps) > 0:
+				laps[len(laps)-1].distStart, laps[len(laps)-1].distEnd = offset, end
+			}
+		}
+	}
+	return points, laps, nil
+}
+
+type byteEdit struct {
+	start, end int
+	text       string
+}
+
+func sanitizeXML(data []byte, pointElem string, options PrivacyOptions) ([]byte, error) {
+	points, laps, err := scanXMLPoints(data, pointElem)
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make([]LatLng, len(points))
+	hasPosition := make([]bool, len(points))
+	for i, p := range points {
+		positions[i], hasPosition[i] = p.position, p.hasPosition
+	}
+	mask, distances := privacyMask(positions, hasPosition, options)
+
+	var edits []byteEdit
+	for i, p := range points {
+		if mask[i] {
+			edits = append(edits, byteEdit{start: trimLineStart(data, p.start), end: p.end})
+		} else if p.distStart >= 0 {
+			edits = append(edits, byteEdit{start: p.distStart, end: p.distEnd, text: formatMeters(distances[i])})
+		}
+	}
+	for _, lap := range laps {
+		if lap.distStart < 0 || lap.last < lap.first {
+			continue
+		}
+		var begin float64
+		if lap.first > 0 {
+			begin = distances[lap.first-1]
+		}
+		end := distances[lap.last]
+		edits = append(edits, byteEdit{start: lap.distStart, end: lap.distEnd, text: formatMeters(end - begin)})
+	}
+	return applyByteEdits(data, edits), nil
+}
+
+// trimLineStart extends start backwards over the indentation and line break
+// preceding an element, so removed elements do not leave blank lines
+func trimLineStart(data []byte, start int) int {
+	i := start
+	for i > 0 && (data[i-1] == ' ' || data[i-1] == '\t') {
+		i--
+	}
+	if i > 0 && data[i-1] == '\n' {
+		i--
+		if i > 0 && data[i-1] == '\r' {
+			i--
+		}
+		return i
+	}
+	return start
+}
+
+func formatMeters(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// applyByteEdits replaces the given ranges; edits nested in an earlier
+// edit's range are dropped
+func applyByteEdits(data []byte, edits []byteEdit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var out bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		if e.start < pos {
+			continue
+		}
+		out.Write(data[pos:e.start])
+		out.WriteString(e.text)
+		pos = e.end
+	}
+	out.Write(data[pos:])
+	return out.Bytes()
+}
@@ -0,0 +1,80 @@
+package igpsportsync
+
+import "time"
+
+// DownloadProgress is a snapshot of a bulk download, passed to
+// DownloadOptions.Progress
+type DownloadProgress struct {
+	// Total is the number of activities to go through: ActivityListData.TotalRows
+	// of the first page, or the number of ids for DownloadActivities.
+	// 0 until it is known.
+	Total int
+
+	// Completed counts the activities handled so far, including Failed
+	// and Skipped ones
+	Completed int
+	Failed    int
+	Skipped   int
+
+	// Bytes is the size of the files downloaded so far
+	Bytes int64
+
+	Elapsed time.Duration
+
+	// Throughput is Bytes per second of Elapsed
+	Throughput float64
+
+	// ETA estimates the time left from the average time per completed
+	// activity, 0 if unknown
+	ETA time.Duration
+}
+
+// Percent returns the completed share of Total from 0 to 100, 0 if Total is unknown
+func (p DownloadProgress) Percent() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return 100 * float64(min(p.Completed, p.Total)) / float64(p.Total)
+}
+
+// ProgressFunc receives progress updates. It is called from the same
+// goroutine as the download callback, right after each activity is handled.
+type ProgressFunc func(progress DownloadProgress)
+
+// progressTracker turns summary counters into DownloadProgress updates
+type progressTracker struct {
+	report  ProgressFunc
+	started time.Time
+	bytes   int64
+}
+
+func newProgressTracker(report ProgressFunc, started time.Time) *progressTracker {
+	return &progressTracker{report: report, started: started}
+}
+
+// update reports the state after an activity was handled; activity is nil
+// for skipped ones
+func (t *progressTracker) update(total, succeeded, failed, skipped int, activity *DownloadedActivity) {
+	if activity != nil {
+		t.bytes += int64(len(activity.Data))
+	}
+	if t.report == nil {
+		return
+	}
+	progress := DownloadProgress{
+		Total:     total,
+		Completed: succeeded + failed + skipped,
+		Failed:    failed,
+		Skipped:   skipped,
+		Bytes:     t.bytes,
+		Elapsed:   time.Since(t.started),
+	}
+	if seconds := progress.Elapsed.Seconds(); seconds > 0 {
+		progress.Throughput = float64(progress.Bytes) / seconds
+	}
+	if progress.Completed > 0 && total > progress.Completed {
+		perActivity := progress.Elapsed / time.Duration(progress.Completed)
+		progress.ETA = perActivity * time.Duration(total-progress.Completed)
+	}
+	t.report(progress)
+}
@@ -0,0 +1,284 @@
+package igpsportsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ReconcileAction is what Reconcile does about a remote change
+type ReconcileAction string
+
+const (
+	RECONCILE_REPORT ReconcileAction = "report" // change nothing
+	RECONCILE_DELETE ReconcileAction = "delete" // remove the files of deleted rides
+	RECONCILE_TRASH  ReconcileAction = "trash"  // move the files of deleted rides to TrashDir
+	RECONCILE_RENAME ReconcileAction = "rename" // record the new title and start time of edited rides
+)
+
+// ChangeKind tells how a ride changed on iGPSport since it was downloaded
+type ChangeKind string
+
+const (
+	CHANGE_DELETED ChangeKind = "deleted"
+	CHANGE_EDITED  ChangeKind = "edited"
+)
+
+// ActivityChange is a stored ride that was deleted or edited remotely
+type ActivityChange struct {
+	RideID int
+	Kind   ChangeKind
+
+	// Entries are the latest manifest entries of the ride, one per format
+	Entries []ManifestEntry
+
+	// Row is the current listing of an edited ride, nil for deleted ones
+	Row *ActivityRow
+
+	// Action is the action applied, RECONCILE_REPORT until ApplyChanges
+	// succeeded for this change
+	Action ReconcileAction
+}
+
+// ReconcileOptions configures Reconcile
+type ReconcileOptions struct {
+	// BeginTime and EndTime restrict the listing like DownloadOptions;
+	// stored rides starting outside of them are never considered deleted
+	BeginTime string
+	EndTime   string
+
+	// Deleted is RECONCILE_REPORT (the default), RECONCILE_DELETE or
+	// RECONCILE_TRASH
+	Deleted ReconcileAction
+
+	// TrashDir receives the files with RECONCILE_TRASH, default
+	// DiskSink.Dir/.trash
+	TrashDir string
+
+	// Edited is RECONCILE_REPORT (the default) or RECONCILE_RENAME
+	Edited ReconcileAction
+
+	// Force deletes or trashes rides even when more than half of the
+	// stored rides in the window are missing from the listing, which
+	// usually means the listing is incomplete rather than the rides gone
+	Force bool
+}
+
+func (o ReconcileOptions) validate() error {
+	switch o.Deleted {
+	case "", RECONCILE_REPORT, RECONCILE_DELETE, RECONCILE_TRASH:
+	default:
+		return fmt.Errorf("invalid action for deleted rides: %s", o.Deleted)
+	}
+	switch o.Edited {
+	case "", RECONCILE_REPORT, RECONCILE_RENAME:
+	default:
+		return fmt.Errorf("invalid action for edited rides: %s", o.Edited)
+	}
+	return nil
+}
+
+// DiffActivities compares the remote listing with the latest stored
+// entries and returns the rides deleted remotely (within beginTime and
+// endTime) or whose title or start time changed, in manifest order
+func DiffActivities(rows []ActivityRow, entries []ManifestEntry, beginTime, endTime string) []ActivityChange {
+	listed := make(map[int]ActivityRow, len(rows))
+	for _, row := range rows {
+		listed[row.RideID] = row
+	}
+
+	index := make(map[int]int)
+	var changes []ActivityChange
+	for _, entry := range entries {
+		if i, ok := index[entry.RideID]; ok {
+			changes[i].Entries = append(changes[i].Entries, entry)
+			continue
+		}
+		change := ActivityChange{RideID: entry.RideID, Entries: []ManifestEntry{entry}, Action: RECONCILE_REPORT}
+		row, ok := listed[entry.RideID]
+		switch {
+		case !ok && inListingWindow(entry.StartTime, beginTime, endTime):
+			change.Kind = CHANGE_DELETED
+		case ok && (row.Title != entry.Title || !sameActivityTime(row.StartTime, entry.StartTime)):
+			change.Kind, change.Row = CHANGE_EDITED, &row
+		default:
+			index[entry.RideID] = -1
+			continue
+		}
+		index[entry.RideID] = len(changes)
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// sameActivityTime compares start times by value, since detail-sourced
+// entries may use another layout than the listing. Unparsable times are
+// compared as strings.
+func sameActivityTime(a, b string) bool {
+	ta, errA := ParseActivityTime(a, nil)
+	tb, errB := ParseActivityTime(b, nil)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return ta.Equal(tb)
+}
+
+// Reconcile lists the activities, compares them with the manifest of the
+// sink and applies the configured actions. It returns the changes found,
+// with the action applied to each; failed actions are joined in the error.
+func (s *IgpsportSync) Reconcile(ctx context.Context, d *DiskSink, options ReconcileOptions) ([]ActivityChange, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	state, err := LoadManifestState(filepath.Join(d.Dir, MANIFEST_FILE))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.listAllActivities(ctx, options.BeginTime, options.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := state.Activities()
+	if err != nil {
+		return nil, err
+	}
+	changes := DiffActivities(rows, entries, options.BeginTime, options.EndTime)
+	if (options.Deleted == RECONCILE_DELETE || options.Deleted == RECONCILE_TRASH) && !options.Force {
+		if err := checkDeletions(changes, entries, options.BeginTime, options.EndTime); err != nil {
+			return changes, err
+		}
+	}
+	return changes, d.ApplyChanges(changes, options)
+}
+
+// checkDeletions refuses a listing missing more than half of the stored
+// rides within beginTime and endTime
+func checkDeletions(changes []ActivityChange, entries []ManifestEntry, beginTime, endTime string) error {
+	stored := make(map[int]bool)
+	for _, entry := range entries {
+		if inListingWindow(entry.StartTime, beginTime, endTime) {
+			stored[entry.RideID] = true
+		}
+	}
+	deleted := 0
+	for _, change := range changes {
+		if change.Kind == CHANGE_DELETED {
+			deleted++
+		}
+	}
+	if deleted > 0 && deleted*2 > len(stored) {
+		return fmt.Errorf("%d of %d stored rides are missing from the listing, refusing to remove them without force", deleted, len(stored))
+	}
+	return nil
+}
+
+// ApplyChanges applies the configured actions to the stored files (in
+// DiskSink.Storage; trashed files always go to a local directory), the
+// manifest and the catalog. Deleted rides get a Deleted manifest entry and
+// leave the catalog; edited rides get a manifest entry with the new title
+// and start time. Each applied change has its Action set.
+func (d *DiskSink) ApplyChanges(changes []ActivityChange, options ReconcileOptions) error {
+	if err := options.validate(); err != nil {
+		return err
+	}
+	deleted, edited := options.Deleted, options.Edited
+	if (deleted == RECONCILE_DELETE || deleted == RECONCILE_TRASH || edited == RECONCILE_RENAME) && d.Manifest == nil {
+		return fmt.Errorf("a manifest is required to record changes")
+	}
+	trashDir := options.TrashDir
+	if trashDir == "" {
+		trashDir = filepath.Join(d.Dir, ".trash")
+	}
+
+	var errs []error
+	for i := range changes {
+		change := &changes[i]
+		var err error
+		switch {
+		case change.Kind == CHANGE_DELETED && (deleted == RECONCILE_DELETE || deleted == RECONCILE_TRASH):
+			err = d.removeRide(change, deleted, trashDir)
+			if err == nil {
+				change.Action = deleted
+			}
+		case change.Kind == CHANGE_EDITED && edited == RECONCILE_RENAME:
+			err = d.renameRide(change)
+			if err == nil {
+				change.Action = edited
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ride %d: %v", change.RideID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *DiskSink) removeRide(change *ActivityChange, action ReconcileAction, trashDir string) error {
+	if action == RECONCILE_TRASH {
+		if err := os.MkdirAll(trashDir, 0o755); err != nil {
+			return fmt.Errorf("error creating trash directory: %v", err)
+		}
+	}
+	ctx := context.Background()
+	storage := d.storage()
+	for _, entry := range change.Entries {
+		ext, err := ParseExtension(entry.Format)
+		if err != nil {
+			return err
+		}
+		if action == RECONCILE_TRASH {
+			data, err := storage.Get(ctx, entry.RideID, ext)
+			if err == nil {
+				err = writeFileAtomic(filepath.Join(trashDir, storedFileName(entry.RideID, ext)), data)
+			}
+			if err != nil && !errors.Is(err, ErrNotStored) {
+				return err
+			}
+		}
+		if err := storage.Delete(ctx, entry.RideID, ext); err != nil {
+			return err
+		}
+		tombstone := ManifestEntry{
+			RideID:       entry.RideID,
+			Title:        entry.Title,
+			StartTime:    entry.StartTime,
+			Format:       entry.Format,
+			DownloadedAt: time.Now().UTC(),
+			Deleted:      true,
+		}
+		if err := d.Manifest.Write(tombstone); err != nil {
+			return err
+		}
+	}
+	if d.Catalog != nil {
+		return d.Catalog.Delete(change.RideID)
+	}
+	return nil
+}
+
+func (d *DiskSink) renameRide(change *ActivityChange) error {
+	for _, entry := range change.Entries {
+		entry.Title, entry.StartTime = change.Row.Title, change.Row.StartTime
+		if err := d.Manifest.Write(entry); err != nil {
+			return err
+		}
+	}
+	if d.Catalog != nil {
+		return d.Catalog.PutRow(*change.Row)
+	}
+	return nil
+}
+
+// ParseReconcileAction converts a flag value such as "trash" to a ReconcileAction
+func ParseReconcileAction(name string) (ReconcileAction, error) {
+	action := ReconcileAction(strings.ToLower(name))
+	switch action {
+	case RECONCILE_REPORT, RECONCILE_DELETE, RECONCILE_TRASH, RECONCILE_RENAME:
+		return action, nil
+	}
+	return "", fmt.Errorf("unknown action: %s", name)
+}
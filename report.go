@@ -0,0 +1,482 @@
+package igpsportsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Static HTML report of stored activities: an index with yearly totals, a
+// calendar heatmap per year and the ride list, and a page per activity with
+// its map, elevation and speed charts. Pages are self-contained (inline CSS
+// and SVG, no scripts or tiles) so the report works offline.
+
+// REPORT_CHART_POINTS is the maximum number of samples drawn per chart
+const REPORT_CHART_POINTS = 500
+
+// ReportOptions configures WriteReport
+type ReportOptions struct {
+	// Title heads the index page, default "iGPSport rides"
+	Title string
+
+	// Location is the rider's time zone, default time.Local
+	Location *time.Location
+
+	// Route trims and simplifies the maps, e.g. with privacy zones; a zero
+	// Tolerance simplifies to 5 meters
+	Route RouteOptions
+
+	// Rows are listing rows (e.g. from a Catalog) giving the distance of
+	// rides whose file has no samples, such as manual entries
+	Rows []ActivityRow
+}
+
+// ReportActivity is one ride of a report
+type ReportActivity struct {
+	RideStats
+	ElapsedTime      time.Duration
+	AverageSpeed     float64 // m/s over moving time
+	MaxSpeed         float64 // m/s
+	AverageHeartRate float64
+
+	// Page is the activity page relative to the report directory
+	Page string
+}
+
+// WriteReport decodes the stored files of manifest entries (e.g. the
+// Activities of a SyncState), one file per ride with FIT preferred, and
+// writes index.html and activities/<rideId>.html to dir. Rides whose file
+// has no samples are listed with the totals of options.Rows and the
+// manifest, without map or charts. Rides whose file cannot be read or
+// decoded are left out and joined in the error.
+func WriteReport(ctx context.Context, storage Storage, entries []ManifestEntry, dir string, options ReportOptions) ([]ReportActivity, error) {
+	if options.Title == "" {
+		options.Title = "iGPSport rides"
+	}
+	loc := options.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	if options.Route.Tolerance == 0 {
+		options.Route.Tolerance = 5
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "activities"), 0o755); err != nil {
+		return nil, err
+	}
+
+	rows := make(map[int]ActivityRow, len(options.Rows))
+	for _, row := range options.Rows {
+		rows[row.RideID] = row
+	}
+
+	var activities []ReportActivity
+	var errs []error
+	for _, entry := range entryPerRide(entries) {
+		if err := ctx.Err(); err != nil {
+			return activities, err
+		}
+		activity, err := writeReportActivity(ctx, storage, entry, rows[entry.RideID], dir, loc, options)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ride %d: %v", entry.RideID, err))
+			continue
+		}
+		activities = append(activities, *activity)
+	}
+	sort.SliceStable(activities, func(i, j int) bool { return activities[i].StartTime.After(activities[j].StartTime) })
+
+	if err := writeReportIndex(activities, dir, loc, options.Title); err != nil {
+		errs = append(errs, err)
+	}
+	return activities, errors.Join(errs...)
+}
+
+func writeReportActivity(ctx context.Context, storage Storage, entry ManifestEntry, row ActivityRow, dir string, loc *time.Location, options ReportOptions) (*ReportActivity, error) {
+	ext, err := ParseExtension(entry.Format)
+	if err != nil {
+		return nil, err
+	}
+	data, err := storage.Get(ctx, entry.RideID, ext)
+	if err != nil {
+		return nil, err
+	}
+	track, err := DecodeTrack(data, ext)
+	if err != nil {
+		return nil, err
+	}
+	title := entry.Title
+	if title == "" {
+		title = "Ride " + strconv.Itoa(entry.RideID)
+	}
+	if len(track.Points) == 0 {
+		return writeReportSummary(entry, row, title, dir, loc)
+	}
+	activity := &ReportActivity{
+		RideStats: RideStats{
+			RideID:     entry.RideID,
+			Title:      title,
+			StartTime:  track.StartTime().In(loc),
+			Distance:   track.Distance(),
+			MovingTime: track.MovingTime(),
+			Ascent:     track.ElevationGain(),
+		},
+		ElapsedTime:      track.ElapsedTime(),
+		AverageHeartRate: track.AverageHeartRate(),
+		Page:             "activities/" + strconv.Itoa(entry.RideID) + ".html",
+	}
+	if activity.MovingTime > 0 {
+		activity.AverageSpeed = activity.Distance / activity.MovingTime.Seconds()
+	}
+
+	var distances, elevations, speedDistances, speeds []float64
+	for i, p := range track.Points {
+		if p.HasAltitude {
+			distances = append(distances, p.Distance)
+			elevations = append(elevations, p.Altitude)
+		}
+		speed := p.Speed
+		if speed == 0 && i > 0 {
+			prev := track.Points[i-1]
+			if dt := p.Time.Sub(prev.Time).Seconds(); dt > 0 && dt <= MAX_SAMPLE_GAP.Seconds() {
+				speed = (p.Distance - prev.Distance) / dt
+			}
+		}
+		if i > 0 {
+			speedDistances = append(speedDistances, p.Distance)
+			speeds = append(speeds, speed)
+			activity.MaxSpeed = math.Max(activity.MaxSpeed, speed)
+		}
+	}
+
+	feature, err := track.GeoJSONFeature(nil, options.Route)
+	if err != nil {
+		return nil, err
+	}
+	feature.Properties["rideId"] = entry.RideID
+	feature.Properties["title"] = title
+
+	page := reportActivityPage{
+		Activity:  activity,
+		Map:       newReportMap(track.Route(options.Route), 640, 420),
+		Elevation: newReportChart(distances, elevations, 1, 640, 160),
+		Speed:     newReportChart(speedDistances, speeds, 3.6, 640, 160),
+		GeoJSON:   feature,
+	}
+	return activity, renderReport(reportActivityTemplate, page, filepath.Join(dir, activity.Page))
+}
+
+// writeReportSummary writes the page of a ride without samples from the
+// listing row and manifest entry
+func writeReportSummary(entry ManifestEntry, row ActivityRow, title, dir string, loc *time.Location) (*ReportActivity, error) {
+	startTime := row.StartTime
+	if startTime == "" {
+		startTime = entry.StartTime
+	}
+	start, err := ParseActivityTime(startTime, loc)
+	if err != nil {
+		return nil, fmt.Errorf("activity has no samples and %v", err)
+	}
+	activity := &ReportActivity{
+		RideStats: RideStats{
+			RideID:    entry.RideID,
+			Title:     title,
+			StartTime: start,
+			Distance:  row.RideDistance,
+		},
+		Page: "activities/" + strconv.Itoa(entry.RideID) + ".html",
+	}
+	page := reportActivityPage{Activity: activity}
+	return activity, renderReport(reportActivityTemplate, page, filepath.Join(dir, activity.Page))
+}
+
+func writeReportIndex(activities []ReportActivity, dir string, loc *time.Location, title string) error {
+	rides := make([]RideStats, len(activities))
+	for i, a := range activities {
+		rides[i] = a.RideStats
+	}
+	years := ComputeStats(rides, PERIOD_YEAR, StatsOptions{Location: loc})
+	sort.Slice(years, func(i, j int) bool { return years[i].Start.After(years[j].Start) })
+
+	var heatmaps []reportHeatmap
+	for _, year := range years {
+		heatmaps = append(heatmaps, newReportHeatmap(year.Start.Year(), rides, loc))
+	}
+	index := reportIndexPage{Title: title, Years: years, Heatmaps: heatmaps, Activities: activities}
+	return renderReport(reportIndexTemplate, index, filepath.Join(dir, "index.html"))
+}
+
+func renderReport(t *template.Template, data any, path string) error {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+type reportActivityPage struct {
+	Activity  *ReportActivity
+	Map       *reportMap
+	Elevation *reportChart
+	Speed     *reportChart
+	GeoJSON   *GeoJSONFeature
+}
+
+type reportIndexPage struct {
+	Title      string
+	Years      []StatsBucket
+	Heatmaps   []reportHeatmap
+	Activities []ReportActivity
+}
+
+// reportMap is a route projected with Web Mercator into a width x height box
+type reportMap struct {
+	Width, Height  float64
+	Points         string
+	StartX, StartY float64
+	EndX, EndY     float64
+}
+
+func newReportMap(route []LatLng, width, height float64) *reportMap {
+	if len(route) < 2 {
+		return nil
+	}
+	xs := make([]float64, len(route))
+	ys := make([]float64, len(route))
+	minX, maxX, minY, maxY := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	for i, p := range route {
+		xs[i] = p.Lng * math.Pi / 180
+		ys[i] = -math.Log(math.Tan(math.Pi/4 + p.Lat*math.Pi/360))
+		minX, maxX = math.Min(minX, xs[i]), math.Max(maxX, xs[i])
+		minY, maxY = math.Min(minY, ys[i]), math.Max(maxY, ys[i])
+	}
+	const padding = 10
+	scale := math.Min((width-2*padding)/math.Max(maxX-minX, 1e-9), (height-2*padding)/math.Max(maxY-minY, 1e-9))
+	offsetX := (width - (maxX-minX)*scale) / 2
+	offsetY := (height - (maxY-minY)*scale) / 2
+
+	m := &reportMap{Width: width, Height: height}
+	points := make([]string, len(route))
+	for i := range route {
+		x, y := offsetX+(xs[i]-minX)*scale, offsetY+(ys[i]-minY)*scale
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+		if i == 0 {
+			m.StartX, m.StartY = x, y
+		}
+		m.EndX, m.EndY = x, y
+	}
+	m.Points = strings.Join(points, " ")
+	return m
+}
+
+// reportChartMargin is the room left of a chart for the value labels
+const reportChartMargin = 50
+
+// reportChart is a line chart of ys (multiplied by factor) over xs
+type reportChart struct {
+	Width, Height float64
+	Points        string
+	Min, Max      float64
+	Distance      float64 // last x, meters
+}
+
+func newReportChart(xs, ys []float64, factor, width, height float64) *reportChart {
+	if len(xs) < 2 || xs[len(xs)-1] <= xs[0] {
+		return nil
+	}
+	step := (len(xs) + REPORT_CHART_POINTS - 1) / REPORT_CHART_POINTS
+	c := &reportChart{Width: width, Height: height, Min: math.Inf(1), Max: math.Inf(-1), Distance: xs[len(xs)-1]}
+	for _, y := range ys {
+		c.Min, c.Max = math.Min(c.Min, y*factor), math.Max(c.Max, y*factor)
+	}
+	span := math.Max(c.Max-c.Min, 1e-9)
+	var points []string
+	for i := 0; i < len(xs); i += step {
+		x := reportChartMargin + (xs[i]-xs[0])/(c.Distance-xs[0])*(width-reportChartMargin)
+		y := height - (ys[i]*factor-c.Min)/span*(height-10) - 5
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	c.Points = strings.Join(points, " ")
+	return c
+}
+
+// reportHeatmap is a year of days in week columns starting on Monday
+type reportHeatmap struct {
+	Year   int
+	Width  int
+	Days   []reportDay
+	Months []reportLabel
+}
+
+type reportDay struct {
+	X, Y  int
+	Level int // 0 without rides, 1 to 4 by distance relative to the longest day
+	Title string
+}
+
+type reportLabel struct {
+	X    int
+	Text string
+}
+
+const reportCell = 13
+
+func newReportHeatmap(year int, rides []RideStats, loc *time.Location) reportHeatmap {
+	distances := make(map[string]float64)
+	counts := make(map[string]int)
+	longest := 0.0
+	for _, ride := range rides {
+		start := ride.StartTime.In(loc)
+		if start.Year() != year {
+			continue
+		}
+		day := start.Format(dateLayout)
+		distances[day] += ride.Distance
+		counts[day]++
+		longest = math.Max(longest, distances[day])
+	}
+
+	first := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	offset := (int(first.Weekday()) + 6) % 7 // Monday is row 0
+	h := reportHeatmap{Year: year}
+	for day := first; day.Year() == year; day = day.AddDate(0, 0, 1) {
+		n := day.YearDay() - 1 + offset
+		x, y := 30+n/7*reportCell, 15+n%7*reportCell
+		key := day.Format(dateLayout)
+		d := reportDay{X: x, Y: y, Title: key}
+		if counts[key] > 0 {
+			d.Level = 1 + int(math.Min(3, math.Floor(distances[key]/longest*4)))
+			d.Title = fmt.Sprintf("%s: %d rides, %.1f km", key, counts[key], distances[key]/1000)
+		}
+		h.Days = append(h.Days, d)
+		if day.Day() == 1 {
+			h.Months = append(h.Months, reportLabel{X: x, Text: day.Format("Jan")})
+		}
+		h.Width = x + reportCell
+	}
+	return h
+}
+
+var reportFuncs = template.FuncMap{
+	"km":  func(meters float64) string { return fmt.Sprintf("%.1f", meters/1000) },
+	"kmh": func(speed float64) string { return fmt.Sprintf("%.1f", speed*3.6) },
+	"hours": func(d time.Duration) string {
+		d = d.Round(time.Minute)
+		return fmt.Sprintf("%d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	},
+	"f0": func(v float64) string { return fmt.Sprintf("%.0f", v) },
+	"f1": func(v float64) string { return fmt.Sprintf("%.1f", v) },
+}
+
+const reportStyle = `<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; margin: 2em auto; max-width: 720px; color: #222; }
+h1, h2 { font-weight: 600; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: right; padding: 4px 8px; border-bottom: 1px solid #eee; }
+th:first-child, td:first-child { text-align: left; }
+svg { display: block; margin: 1em 0; }
+.route { fill: none; stroke: #e8590c; stroke-width: 2.5; stroke-linejoin: round; }
+.line { fill: none; stroke: #1c7ed6; stroke-width: 1.5; }
+.axis { font-size: 11px; fill: #666; }
+.l0 { fill: #ebedf0; } .l1 { fill: #c6e48b; } .l2 { fill: #7bc96f; } .l3 { fill: #239a3b; } .l4 { fill: #196127; }
+</style>`
+
+var reportActivityTemplate = template.Must(template.New("activity").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Activity.Title}}</title>
+` + reportStyle + `
+</head>
+<body>
+<p><a href="../index.html">All rides</a></p>
+<h1>{{.Activity.Title}}</h1>
+<p>{{.Activity.StartTime.Format "Monday, 2 January 2006 15:04"}}</p>
+<table>
+<tr><td>Distance</td><td>{{km .Activity.Distance}} km</td></tr>
+<tr><td>Moving time</td><td>{{hours .Activity.MovingTime}}</td></tr>
+<tr><td>Elapsed time</td><td>{{hours .Activity.ElapsedTime}}</td></tr>
+<tr><td>Average speed</td><td>{{kmh .Activity.AverageSpeed}} km/h</td></tr>
+<tr><td>Max speed</td><td>{{kmh .Activity.MaxSpeed}} km/h</td></tr>
+<tr><td>Ascent</td><td>{{f0 .Activity.Ascent}} m</td></tr>
+{{- if .Activity.AverageHeartRate}}
+<tr><td>Average heart rate</td><td>{{f0 .Activity.AverageHeartRate}} bpm</td></tr>
+{{- end}}
+</table>
+{{- with .Map}}
+<h2>Route</h2>
+<svg viewBox="0 0 {{.Width}} {{.Height}}" width="{{.Width}}" height="{{.Height}}">
+<polyline class="route" points="{{.Points}}"/>
+<circle cx="{{f1 .StartX}}" cy="{{f1 .StartY}}" r="5" fill="#2f9e44"/>
+<circle cx="{{f1 .EndX}}" cy="{{f1 .EndY}}" r="5" fill="#c92a2a"/>
+</svg>
+{{- end}}
+{{- with .Elevation}}
+<h2>Elevation</h2>
+<svg viewBox="0 0 {{.Width}} {{.Height}}" width="{{.Width}}" height="{{.Height}}">
+<polyline class="line" points="{{.Points}}"/>
+<text class="axis" x="0" y="12">{{f0 .Max}} m</text>
+<text class="axis" x="0" y="{{.Height}}">{{f0 .Min}} m</text>
+</svg>
+{{- end}}
+{{- with .Speed}}
+<h2>Speed</h2>
+<svg viewBox="0 0 {{.Width}} {{.Height}}" width="{{.Width}}" height="{{.Height}}">
+<polyline class="line" points="{{.Points}}"/>
+<text class="axis" x="0" y="12">{{f0 .Max}} km/h</text>
+<text class="axis" x="0" y="{{.Height}}">{{f0 .Min}} km/h</text>
+</svg>
+{{- end}}
+{{- with .GeoJSON}}
+<script type="application/json" id="route-geojson">{{.}}</script>
+{{- end}}
+</body>
+</html>
+`))
+
+var reportIndexTemplate = template.Must(template.New("index").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+` + reportStyle + `
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<h2>Yearly totals</h2>
+<table>
+<tr><th>Year</th><th>Rides</th><th>km</th><th>Moving</th><th>Ascent m</th><th>Longest km</th></tr>
+{{- range .Years}}
+<tr><td>{{.Label}}</td><td>{{.Rides}}</td><td>{{km .Distance}}</td><td>{{hours .MovingTime}}</td><td>{{f0 .Ascent}}</td><td>{{km .LongestRide.Distance}}</td></tr>
+{{- end}}
+</table>
+{{- range .Heatmaps}}
+<h2>{{.Year}}</h2>
+<svg viewBox="0 0 {{.Width}} 108" width="{{.Width}}" height="108">
+{{- range .Months}}
+<text class="axis" x="{{.X}}" y="10">{{.Text}}</text>
+{{- end}}
+<text class="axis" x="0" y="24">Mon</text>
+<text class="axis" x="0" y="102">Sun</text>
+{{- range .Days}}
+<rect class="l{{.Level}}" x="{{.X}}" y="{{.Y}}" width="11" height="11" rx="2"><title>{{.Title}}</title></rect>
+{{- end}}
+</svg>
+{{- end}}
+<h2>Rides</h2>
+<table>
+<tr><th>Date</th><th>Title</th><th>km</th><th>Moving</th><th>Ascent m</th></tr>
+{{- range .Activities}}
+<tr><td>{{.StartTime.Format "2006-01-02"}}</td><td><a href="{{.Page}}">{{.Title}}</a></td><td>{{km .Distance}}</td><td>{{hours .MovingTime}}</td><td>{{f0 .Ascent}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
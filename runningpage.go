@@ -0,0 +1,278 @@
+package igpsportsync
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Export to the data layout of running_page
+// (https://github.com/yihong0618/running_page): the `activities` table of
+// its SQLite database and the generated activities.json.
+
+const runningPageSchema = `CREATE TABLE IF NOT EXISTS activities (
+	run_id INTEGER NOT NULL,
+	name VARCHAR,
+	distance FLOAT,
+	moving_time DATETIME,
+	elapsed_time DATETIME,
+	type VARCHAR,
+	subtype VARCHAR,
+	start_date VARCHAR,
+	start_date_local VARCHAR,
+	location_country VARCHAR,
+	summary_polyline VARCHAR,
+	average_heartrate FLOAT,
+	average_speed FLOAT,
+	elevation_gain FLOAT,
+	PRIMARY KEY (run_id)
+)`
+
+const runningPageDateLayout = "2006-01-02 15:04:05"
+
+// running_page stores intervals as datetimes relative to the unix epoch
+const runningPageIntervalLayout = "2006-01-02 15:04:05.000000"
+
+// RunningPageActivity is one row of running_page's activities table
+type RunningPageActivity struct {
+	RunID            int64
+	Name             string
+	Distance         float64 // meters
+	MovingTime       time.Duration
+	ElapsedTime      time.Duration
+	Type             string
+	Subtype          string
+	StartDate        string // UTC, "2006-01-02 15:04:05"
+	StartDateLocal   string
+	LocationCountry  string
+	SummaryPolyline  string
+	AverageHeartrate *float64
+	AverageSpeed     float64 // m/s over moving time
+	ElevationGain    *float64
+}
+
+var runningPageTypes = map[string]string{
+	"running": "Run", "walking": "Walk", "hiking": "Hike", "swimming": "Swim", "e_biking": "EBikeRide",
+}
+
+// NewRunningPageActivity builds a running_page activity from a decoded track.
+// loc is the rider's time zone used for start_date_local (time.Local if nil).
+func NewRunningPageActivity(rideID int, title string, track *Track, loc *time.Location) RunningPageActivity {
+	if loc == nil {
+		loc = time.Local
+	}
+	activityType, ok := runningPageTypes[track.Sport]
+	if !ok {
+		activityType = "Ride"
+	}
+
+	start := track.StartTime()
+	a := RunningPageActivity{
+		RunID:           int64(rideID),
+		Name:            title,
+		Distance:        track.Distance(),
+		MovingTime:      track.MovingTime(),
+		ElapsedTime:     track.ElapsedTime(),
+		Type:            activityType,
+		Subtype:         activityType,
+		StartDate:       start.UTC().Format(runningPageDateLayout),
+		StartDateLocal:  start.In(loc).Format(runningPageDateLayout),
+		SummaryPolyline: EncodePolyline(track.Positions()),
+	}
+	if a.MovingTime > 0 {
+		a.AverageSpeed = a.Distance / a.MovingTime.Seconds()
+	}
+	if hr := track.AverageHeartRate(); hr > 0 {
+		a.AverageHeartrate = &hr
+	}
+	if gain := track.ElevationGain(); gain > 0 {
+		a.ElevationGain = &gain
+	}
+	return a
+}
+
+// MarshalJSON encodes the activity the way running_page writes activities.json
+func (a RunningPageActivity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.toJSON(0))
+}
+
+type runningPageJSON struct {
+	RunID            int64    `json:"run_id"`
+	Name             string   `json:"name"`
+	Distance         float64  `json:"distance"`
+	MovingTime       string   `json:"moving_time"`
+	Type             string   `json:"type"`
+	Subtype          string   `json:"subtype"`
+	StartDate        string   `json:"start_date"`
+	StartDateLocal   string   `json:"start_date_local"`
+	LocationCountry  string   `json:"location_country"`
+	SummaryPolyline  string   `json:"summary_polyline"`
+	AverageHeartrate *float64 `json:"average_heartrate"`
+	AverageSpeed     float64  `json:"average_speed"`
+	ElevationGain    *float64 `json:"elevation_gain"`
+	Streak           int      `json:"streak,omitempty"`
+}
+
+func (a RunningPageActivity) toJSON(streak int) runningPageJSON {
+	return runningPageJSON{
+		RunID:            a.RunID,
+		Name:             a.Name,
+		Distance:         a.Distance,
+		MovingTime:       pythonTimedelta(a.MovingTime),
+		Type:             a.Type,
+		Subtype:          a.Subtype,
+		StartDate:        a.StartDate,
+		StartDateLocal:   a.StartDateLocal,
+		LocationCountry:  a.LocationCountry,
+		SummaryPolyline:  a.SummaryPolyline,
+		AverageHeartrate: a.AverageHeartrate,
+		AverageSpeed:     a.AverageSpeed,
+		ElevationGain:    a.ElevationGain,
+		Streak:           streak,
+	}
+}
+
+// pythonTimedelta formats a duration like str(datetime.timedelta)
+func pythonTimedelta(d time.Duration) string {
+	total := int64(d / time.Second)
+	days := total / 86400
+	rest := total % 86400
+	hms := fmt.Sprintf("%d:%02d:%02d", rest/3600, rest%3600/60, rest%60)
+	switch {
+	case days == 1:
+		return "1 day, " + hms
+	case days > 1:
+		return fmt.Sprintf("%d days, %s", days, hms)
+	}
+	return hms
+}
+
+// RunningPageExporter writes activities into a running_page SQLite database.
+// The caller opens db with a SQLite driver of its choice.
+type RunningPageExporter struct {
+	db *sql.DB
+}
+
+// NewRunningPageExporter creates the activities table if it does not exist
+func NewRunningPageExporter(db *sql.DB) (*RunningPageExporter, error) {
+	if _, err := db.Exec(runningPageSchema); err != nil {
+		return nil, fmt.Errorf("error creating running_page schema: %v", err)
+	}
+	return &RunningPageExporter{db: db}, nil
+}
+
+// Upsert inserts the activity or replaces the row with the same run_id
+func (e *RunningPageExporter) Upsert(a RunningPageActivity) error {
+	epoch := time.Unix(0, 0).UTC()
+	_, err := e.db.Exec(`INSERT OR REPLACE INTO activities (
+		run_id, name, distance, moving_time, elapsed_time, type, subtype,
+		start_date, start_date_local, location_country, summary_polyline,
+		average_heartrate, average_speed, elevation_gain
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.RunID, a.Name, a.Distance,
+		epoch.Add(a.MovingTime).Format(runningPageIntervalLayout),
+		epoch.Add(a.ElapsedTime).Format(runningPageIntervalLayout),
+		a.Type, a.Subtype, a.StartDate, a.StartDateLocal, a.LocationCountry,
+		a.SummaryPolyline, a.AverageHeartrate, a.AverageSpeed, a.ElevationGain,
+	)
+	if err != nil {
+		return fmt.Errorf("error writing running_page activity %d: %v", a.RunID, err)
+	}
+	return nil
+}
+
+// Activities returns all rows ordered by start_date_local
+func (e *RunningPageExporter) Activities() ([]RunningPageActivity, error) {
+	rows, err := e.db.Query(`SELECT run_id, name, distance, moving_time, elapsed_time,
+		type, subtype, start_date, start_date_local, location_country,
+		summary_polyline, average_heartrate, average_speed, elevation_gain
+		FROM activities ORDER BY start_date_local`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying running_page activities: %v", err)
+	}
+	defer rows.Close()
+
+	var activities []RunningPageActivity
+	for rows.Next() {
+		var a RunningPageActivity
+		var name, activityType, subtype, country, polyline sql.NullString
+		var moving, elapsed any // drivers may return DATETIME columns as time.Time
+		var distance, speed sql.NullFloat64
+		var hr, gain sql.NullFloat64
+		err := rows.Scan(&a.RunID, &name, &distance, &moving, &elapsed,
+			&activityType, &subtype, &a.StartDate, &a.StartDateLocal, &country,
+			&polyline, &hr, &speed, &gain)
+		if err != nil {
+			return nil, fmt.Errorf("error reading running_page activity: %v", err)
+		}
+		a.Name, a.Type, a.Subtype = name.String, activityType.String, subtype.String
+		a.LocationCountry, a.SummaryPolyline = country.String, polyline.String
+		a.Distance, a.AverageSpeed = distance.Float64, speed.Float64
+		a.MovingTime = parseRunningPageInterval(moving)
+		a.ElapsedTime = parseRunningPageInterval(elapsed)
+		if hr.Valid {
+			a.AverageHeartrate = &hr.Float64
+		}
+		if gain.Valid {
+			a.ElevationGain = &gain.Float64
+		}
+		activities = append(activities, a)
+	}
+	return activities, rows.Err()
+}
+
+func parseRunningPageInterval(value any) time.Duration {
+	epoch := time.Unix(0, 0).UTC()
+	switch v := value.(type) {
+	case time.Time:
+		return v.Sub(epoch)
+	case []byte:
+		value = string(v)
+	}
+	str, _ := value.(string)
+	for _, layout := range []string{runningPageIntervalLayout, runningPageDateLayout, time.RFC3339Nano} {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t.Sub(epoch)
+		}
+	}
+	return 0
+}
+
+// WriteJSON writes all activities to path in running_page's activities.json
+// format, including the riding day streak
+func (e *RunningPageExporter) WriteJSON(path string) error {
+	activities, err := e.Activities()
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(activities, func(i, j int) bool {
+		return activities[i].StartDateLocal < activities[j].StartDateLocal
+	})
+
+	out := make([]runningPageJSON, 0, len(activities))
+	streak := 0
+	var lastDay time.Time
+	for _, a := range activities {
+		day, err := time.Parse(runningPageDateLayout, a.StartDateLocal)
+		if err == nil {
+			day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+			switch {
+			case day.Equal(lastDay):
+			case day.Equal(lastDay.AddDate(0, 0, 1)):
+				streak++
+			default:
+				streak = 1
+			}
+			lastDay = day
+		}
+		out = append(out, a.toJSON(streak))
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return fmt.Errorf("error encoding activities.json: %v", err)
+	}
+	return writeFileAtomic(path, data)
+}
@@ -0,0 +1,275 @@
+package igpsportsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config configures an S3Storage. Any S3-compatible service works, e.g.
+// MinIO; requests use path-style urls signed with AWS Signature Version 4.
+type S3Config struct {
+	// Endpoint is the service url, e.g. "https://s3.eu-west-1.amazonaws.com"
+	// or "http://localhost:9000"
+	Endpoint string
+
+	// Region defaults to "us-east-1"
+	Region string
+
+	Bucket string
+
+	// Prefix is prepended to every object key, e.g. "igpsport/"
+	Prefix string
+
+	AccessKey string
+	SecretKey string
+
+	// Client defaults to an http.Client with a 60 second timeout
+	Client *http.Client
+}
+
+// S3Storage keeps files as objects <Prefix><rideId>.<ext> in a bucket
+type S3Storage struct {
+	config S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewS3Storage(config S3Config) *S3Storage {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &S3Storage{config: config, client: client, now: time.Now}
+}
+
+// Location returns the s3:// url of a file
+func (s *S3Storage) Location(rideID int, ext Extension) string {
+	return "s3://" + s.config.Bucket + "/" + s.key(rideID, ext)
+}
+
+func (s *S3Storage) key(rideID int, ext Extension) string {
+	return s.config.Prefix + storedFileName(rideID, ext)
+}
+
+func (s *S3Storage) Put(ctx context.Context, rideID int, ext Extension, data []byte) error {
+	res, err := s.do(ctx, "PUT", s.key(rideID, ext), nil, data)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, rideID int, ext Extension) ([]byte, error) {
+	res, err := s.do(ctx, "GET", s.key(rideID, ext), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotStored
+	}
+	return io.ReadAll(res.Body)
+}
+
+func (s *S3Storage) Exists(ctx context.Context, rideID int, ext Extension) (bool, error) {
+	res, err := s.do(ctx, "HEAD", s.key(rideID, ext), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	return res.StatusCode != http.StatusNotFound, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, rideID int, ext Extension) error {
+	res, err := s.do(ctx, "DELETE", s.key(rideID, ext), nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// s3ListResult is a ListObjectsV2 response
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+func (s *S3Storage) List(ctx context.Context) ([]StoredFile, error) {
+	var files []StoredFile
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if s.config.Prefix != "" {
+			query.Set("prefix", s.config.Prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		res, err := s.do(ctx, "GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding bucket listing: %v", err)
+		}
+		for _, object := range result.Contents {
+			rideID, ext, ok := parseStoredFileName(strings.TrimPrefix(object.Key, s.config.Prefix))
+			if ok {
+				files = append(files, StoredFile{RideID: rideID, Extension: ext, Size: object.Size})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sortStoredFiles(files)
+	return files, nil
+}
+
+// do sends a signed request for an object key, or for the bucket when key
+// is empty. Error statuses other than 404 are returned as errors.
+func (s *S3Storage) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(s.config.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %v", err)
+	}
+	u.Path += "/" + s.config.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, body)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotFound {
+		defer res.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("s3 %s %s: %s %s", method, u.Path, res.Status, strings.TrimSpace(string(message)))
+	}
+	return res, nil
+}
+
+// sign adds the AWS Signature Version 4 headers
+func (s *S3Storage) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" || name == "range" {
+			signed[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	date := now.Format("20060102")
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the RFC 3986 unreserved
+// characters, keeping "/" when keepSlash is set
+func s3Escape(value string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(path string) string {
+	return s3Escape(path, true)
+}
+
+// s3CanonicalQuery encodes a query sorted by key, as signed
+func s3CanonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
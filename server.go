@@ -0,0 +1,366 @@
+package igpsportsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Read-only REST API over the local catalog and stored files:
+//
+//	GET /activities                        paged list, newest first
+//	GET /activities/{rideId}               one activity
+//	GET /activities/{rideId}/file.{ext}    stored fit, gpx or tcx file
+//	GET /me                                rider profile
+//	GET /stats                             totals per week, month or year
+//
+// /activities and /stats accept from and to (2006-01-02, inclusive) and
+// minDistance, maxDistance, text and device with the ActivityFilter
+// semantics; /activities also page and pageSize, /stats period.
+
+const (
+	SERVER_PAGE_SIZE     = 20
+	SERVER_MAX_PAGE_SIZE = 100
+)
+
+// ServerConfig configures a Server
+type ServerConfig struct {
+	// Catalog provides the activities, required
+	Catalog *Catalog
+
+	// Storage provides the activity files, required
+	Storage Storage
+
+	// Profile is served at /me, which is not found if nil
+	Profile *UserInfoResult
+
+	// Token, when set, must be sent as "Authorization: Bearer <Token>"
+	Token string
+}
+
+// APIActivity is an activity returned by the server
+type APIActivity struct {
+	ActivityRow
+	Detail  *ActivityDetailData `json:"detail,omitempty"`
+	Summary *CatalogSummary     `json:"summary,omitempty"`
+	Files   []APIFile           `json:"files"`
+}
+
+// APIFile is a stored file of an APIActivity
+type APIFile struct {
+	Format string `json:"format"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	URL    string `json:"url"`
+}
+
+// APIActivityList is a page of /activities, shaped like ActivityListData
+type APIActivityList struct {
+	Rows      []APIActivity `json:"rows"`
+	TotalPage int           `json:"totalPage"`
+	PageNo    int           `json:"pageNo"`
+	PageSize  int           `json:"pageSize"`
+	TotalRows int           `json:"totalRows"`
+}
+
+// APIStatsBucket is a StatsBucket with durations in seconds
+type APIStatsBucket struct {
+	Label         string    `json:"label"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Rides         int       `json:"rides"`
+	Distance      float64   `json:"distance"`
+	MovingTime    int64     `json:"movingTime"`
+	Ascent        float64   `json:"ascent"`
+	AverageSpeed  float64   `json:"averageSpeed"`
+	LongestRideID int       `json:"longestRideId"`
+}
+
+// APIStats is the /stats response; streaks are counted up to now
+type APIStats struct {
+	Period            string           `json:"period"`
+	Buckets           []APIStatsBucket `json:"buckets"`
+	CurrentDayStreak  int              `json:"currentDayStreak"`
+	LongestDayStreak  int              `json:"longestDayStreak"`
+	CurrentWeekStreak int              `json:"currentWeekStreak"`
+	LongestWeekStreak int              `json:"longestWeekStreak"`
+}
+
+// Server serves the REST API, see NewServer
+type Server struct {
+	config ServerConfig
+	mux    *http.ServeMux
+}
+
+func NewServer(config ServerConfig) *Server {
+	s := &Server{config: config, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /activities", s.listActivities)
+	s.mux.HandleFunc("GET /activities/{rideId}", s.getActivity)
+	s.mux.HandleFunc("GET /activities/{rideId}/{file}", s.getFile)
+	s.mux.HandleFunc("GET /me", s.getProfile)
+	s.mux.HandleFunc("GET /stats", s.getStats)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.config.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="igpsport-sync"`)
+			writeAPIError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// writeAPIJSON writes v with an ETag of its encoding, answering a matching
+// If-None-Match with 304 Not Modified
+func writeAPIJSON(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func etagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// query selects the catalog entries of the date range and ActivityFilter
+// parameters of a request
+func (s *Server) query(r *http.Request) ([]CatalogEntry, error) {
+	params := r.URL.Query()
+	var q CatalogQuery
+	loc := s.config.Catalog.loc
+	if from := params.Get("from"); from != "" {
+		t, err := time.ParseInLocation(dateLayout, from, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %s", from)
+		}
+		q.From = t
+	}
+	if to := params.Get("to"); to != "" {
+		t, err := time.ParseInLocation(dateLayout, to, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to: %s", to)
+		}
+		q.To = t.AddDate(0, 0, 1)
+	}
+	filter := ActivityFilter{Text: params.Get("text"), Device: params.Get("device")}
+	for name, value := range map[string]*float64{"minDistance": &filter.MinDistance, "maxDistance": &filter.MaxDistance} {
+		if v := params.Get(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", name, v)
+			}
+			*value = f
+		}
+	}
+
+	entries, err := s.config.Catalog.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	matched := entries[:0]
+	for _, entry := range entries {
+		if filter.matchFields(entry.Row) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched, nil
+}
+
+func apiActivity(entry CatalogEntry) APIActivity {
+	activity := APIActivity{ActivityRow: entry.Row, Detail: entry.Detail, Summary: entry.Summary, Files: []APIFile{}}
+	for _, f := range entry.Files {
+		activity.Files = append(activity.Files, APIFile{
+			Format: f.Format,
+			Size:   f.Size,
+			SHA256: f.SHA256,
+			URL:    fmt.Sprintf("/activities/%d/file.%s", entry.Row.RideID, f.Format),
+		})
+	}
+	return activity
+}
+
+func (s *Server) listActivities(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.query(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, pageSize := 1, SERVER_PAGE_SIZE
+	if v := r.URL.Query().Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			writeAPIError(w, http.StatusBadRequest, "invalid page: "+v)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("pageSize"); v != "" {
+		if pageSize, err = strconv.Atoi(v); err != nil || pageSize < 1 || pageSize > SERVER_MAX_PAGE_SIZE {
+			writeAPIError(w, http.StatusBadRequest, "invalid pageSize: "+v)
+			return
+		}
+	}
+
+	list := APIActivityList{
+		Rows:      []APIActivity{},
+		PageNo:    page,
+		PageSize:  pageSize,
+		TotalRows: len(entries),
+		TotalPage: (len(entries) + pageSize - 1) / pageSize,
+	}
+	// pages past the end are empty; checked first so the offset cannot overflow
+	if page-1 <= len(entries)/pageSize {
+		from := (page - 1) * pageSize
+		for _, entry := range entries[from:min(from+pageSize, len(entries))] {
+			list.Rows = append(list.Rows, apiActivity(entry))
+		}
+	}
+	writeAPIJSON(w, r, list)
+}
+
+func (s *Server) getActivity(w http.ResponseWriter, r *http.Request) {
+	rideID, err := strconv.Atoi(r.PathValue("rideId"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "activity not found")
+		return
+	}
+	entry, err := s.config.Catalog.Get(rideID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entry == nil {
+		writeAPIError(w, http.StatusNotFound, "activity not found")
+		return
+	}
+	writeAPIJSON(w, r, apiActivity(*entry))
+}
+
+var apiContentTypes = map[Extension]string{
+	FIT: "application/vnd.ant.fit",
+	GPX: "application/gpx+xml",
+	TCX: "application/vnd.garmin.tcx+xml",
+}
+
+func (s *Server) getFile(w http.ResponseWriter, r *http.Request) {
+	rideID, err := strconv.Atoi(r.PathValue("rideId"))
+	name, ok := strings.CutPrefix(r.PathValue("file"), "file.")
+	if err != nil || !ok {
+		writeAPIError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	ext, err := ParseExtension(name)
+	if err != nil || ext.Name() != name {
+		writeAPIError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	data, err := s.config.Storage.Get(r.Context(), rideID, ext)
+	if errors.Is(err, ErrNotStored) {
+		writeAPIError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sum := sha256.Sum256(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", apiContentTypes[ext])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+storedFileName(rideID, ext)+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func (s *Server) getProfile(w http.ResponseWriter, r *http.Request) {
+	if s.config.Profile == nil {
+		writeAPIError(w, http.StatusNotFound, "profile not available")
+		return
+	}
+	writeAPIJSON(w, r, s.config.Profile)
+}
+
+var apiPeriods = map[string]StatsPeriod{"week": PERIOD_WEEK, "month": PERIOD_MONTH, "year": PERIOD_YEAR}
+
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("period")
+	if name == "" {
+		name = "month"
+	}
+	period, ok := apiPeriods[name]
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid period: "+name)
+		return
+	}
+	entries, err := s.query(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	loc := s.config.Catalog.loc
+	var rides []RideStats
+	for _, entry := range entries {
+		var ride RideStats
+		if entry.Detail != nil {
+			ride, err = RideStatsFromDetail(*entry.Detail, loc)
+		} else {
+			ride, err = RideStatsFromRow(entry.Row, loc)
+		}
+		if err == nil {
+			rides = append(rides, ride)
+		}
+	}
+
+	options := StatsOptions{Location: loc}
+	stats := APIStats{Period: name, Buckets: []APIStatsBucket{}}
+	for _, b := range ComputeStats(rides, period, options) {
+		stats.Buckets = append(stats.Buckets, APIStatsBucket{
+			Label:         b.Label,
+			Start:         b.Start,
+			End:           b.End,
+			Rides:         b.Rides,
+			Distance:      b.Distance,
+			MovingTime:    int64(b.MovingTime / time.Second),
+			Ascent:        b.Ascent,
+			AverageSpeed:  b.AverageSpeed,
+			LongestRideID: b.LongestRide.RideID,
+		})
+	}
+	streaks := Streaks(rides, time.Now(), options)
+	stats.CurrentDayStreak, stats.LongestDayStreak = streaks.CurrentDays.Length, streaks.LongestDays.Length
+	stats.CurrentWeekStreak, stats.LongestWeekStreak = streaks.CurrentWeeks.Length, streaks.LongestWeeks.Length
+	writeAPIJSON(w, r, stats)
+}
@@ -0,0 +1,124 @@
+package igpsportsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DiskSink stores downloaded activities as files in a directory,
+// named <rideId>.<ext>, and optionally records them in a manifest.
+// It is safe for use from concurrent download callbacks.
+type DiskSink struct {
+	Dir string
+
+	// Storage receives the files instead of Dir when set, e.g. an
+	// S3Storage; the manifest stays in Dir
+	Storage Storage
+
+	// Manifest receives one entry per saved (or failed) activity, nil to disable
+	Manifest *ManifestWriter
+
+	// Catalog records every saved file, nil to disable
+	Catalog *Catalog
+}
+
+// NewDiskSink creates the directory if needed and, when withManifest is set,
+// opens MANIFEST_FILE inside it for appending
+func NewDiskSink(dir string, withManifest bool) (*DiskSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating directory: %v", err)
+	}
+	sink := &DiskSink{Dir: dir, Storage: &LocalStorage{Dir: dir}}
+	if withManifest {
+		manifest, err := OpenManifest(filepath.Join(dir, MANIFEST_FILE))
+		if err != nil {
+			return nil, err
+		}
+		sink.Manifest = manifest
+	}
+	return sink, nil
+}
+
+// FileName returns the file name used for an activity
+func (d *DiskSink) FileName(rideID int, ext Extension) string {
+	return storedFileName(rideID, ext)
+}
+
+// storage returns Storage, or a LocalStorage on Dir
+func (d *DiskSink) storage() Storage {
+	if d.Storage == nil {
+		return &LocalStorage{Dir: d.Dir}
+	}
+	return d.Storage
+}
+
+// Save writes the activity data to the storage. Activities carrying an
+// Error are not written but are still recorded in the manifest.
+func (d *DiskSink) Save(ctx context.Context, activity *DownloadedActivity) error {
+	file := ""
+	if activity.Error == nil {
+		file = d.FileName(activity.RideID, activity.Extension)
+		if err := d.storage().Put(ctx, activity.RideID, activity.Extension, activity.Data); err != nil {
+			return fmt.Errorf("error saving activity %d: %v", activity.RideID, err)
+		}
+	}
+	entry := NewManifestEntry(activity, file)
+	if d.Catalog != nil && file != "" {
+		if err := d.Catalog.PutFile(entry, storageLocation(d.storage(), activity.RideID, activity.Extension)); err != nil {
+			return err
+		}
+	}
+	if d.Manifest != nil {
+		return d.Manifest.Write(entry)
+	}
+	return nil
+}
+
+// Callback returns a DownloadCallback that saves every activity with ctx
+// and then calls next (if not nil). A failed save is reported to next
+// through activity.Error.
+func (d *DiskSink) Callback(ctx context.Context, next DownloadCallback) DownloadCallback {
+	return func(activity *DownloadedActivity) bool {
+		if err := d.Save(ctx, activity); err != nil && activity.Error == nil {
+			activity.Error = err
+		}
+		if next == nil {
+			return true
+		}
+		return next(activity)
+	}
+}
+
+// Close closes the manifest, if any
+func (d *DiskSink) Close() error {
+	if d.Manifest != nil {
+		return d.Manifest.Close()
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+// so an interrupted download never leaves a truncated file behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
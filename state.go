@@ -0,0 +1,64 @@
+package igpsportsync
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// SyncState tells which activities are already stored locally, so a sync
+// only downloads new ones. Implementations must be safe for concurrent use.
+type SyncState interface {
+	// Synced reports whether the activity is stored in the given format
+	Synced(rideID int, ext Extension) bool
+
+	// Activities returns the latest entry of every stored activity file
+	Activities() ([]ManifestEntry, error)
+}
+
+// ManifestState is a SyncState read from a manifest. Only entries whose
+// latest download succeeded count as synced, in their format and in the
+// format requested when the file could not be converted to it.
+type ManifestState struct {
+	entries []ManifestEntry
+	synced  map[string]bool
+}
+
+// NewManifestState builds the state from manifest entries in the order
+// they were written
+func NewManifestState(entries []ManifestEntry) *ManifestState {
+	state := &ManifestState{synced: make(map[string]bool)}
+	for _, entry := range LatestManifestEntries(entries) {
+		if entry.Error != "" || entry.File == "" {
+			continue
+		}
+		state.entries = append(state.entries, entry)
+		state.synced[strconv.Itoa(entry.RideID)+"."+entry.Format] = true
+		if entry.Requested != "" {
+			state.synced[strconv.Itoa(entry.RideID)+"."+entry.Requested] = true
+		}
+	}
+	return state
+}
+
+// LoadManifestState reads the manifest at path; a missing manifest is an
+// empty state
+func LoadManifestState(path string) (*ManifestState, error) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return NewManifestState(nil), nil
+	}
+	entries, err := ReadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewManifestState(entries), nil
+}
+
+func (m *ManifestState) Synced(rideID int, ext Extension) bool {
+	return m.synced[strconv.Itoa(rideID)+"."+ext.Name()]
+}
+
+func (m *ManifestState) Activities() ([]ManifestEntry, error) {
+	return m.entries, nil
+}
@@ -0,0 +1,328 @@
+package igpsportsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// StatsPeriod is the bucket size of ComputeStats
+type StatsPeriod int
+
+const (
+	PERIOD_WEEK StatsPeriod = iota
+	PERIOD_MONTH
+	PERIOD_YEAR
+)
+
+// StatsOptions configures stats bucketing
+type StatsOptions struct {
+	// Location is the rider's time zone. Start times without a zone are read
+	// as wall time there, others are converted to it before bucketing.
+	// Defaults to time.Local.
+	Location *time.Location
+
+	// WeekStart is the first day of a week bucket, Monday if nil
+	WeekStart *time.Weekday
+}
+
+func (o StatsOptions) location() *time.Location {
+	if o.Location == nil {
+		return time.Local
+	}
+	return o.Location
+}
+
+func (o StatsOptions) weekStart() time.Weekday {
+	if o.WeekStart == nil {
+		return time.Monday
+	}
+	return *o.WeekStart
+}
+
+// RideStats is the per activity input of the stats functions. MovingTime and
+// Ascent are only known from the activity detail and are zero otherwise.
+type RideStats struct {
+	RideID     int
+	Title      string
+	StartTime  time.Time
+	Distance   float64 // meters
+	MovingTime time.Duration
+	Ascent     float64 // meters
+}
+
+// RideStatsFromRow builds stats input from a listing row
+func RideStatsFromRow(row ActivityRow, loc *time.Location) (RideStats, error) {
+	start, err := ParseActivityTime(row.StartTime, loc)
+	if err != nil {
+		return RideStats{}, fmt.Errorf("ride %d: %v", row.RideID, err)
+	}
+	return RideStats{RideID: row.RideID, Title: row.Title, StartTime: start, Distance: row.RideDistance}, nil
+}
+
+// RideStatsFromDetail builds stats input from activity detail
+func RideStatsFromDetail(detail ActivityDetailData, loc *time.Location) (RideStats, error) {
+	start, err := ParseActivityTime(detail.StartTime, loc)
+	if err != nil {
+		return RideStats{}, fmt.Errorf("ride %d: %v", detail.RideId, err)
+	}
+	return RideStats{
+		RideID:     detail.RideId,
+		Title:      detail.Title,
+		StartTime:  start,
+		Distance:   float64(detail.RideDistance),
+		MovingTime: time.Duration(detail.MovingTime) * time.Second,
+		Ascent:     float64(detail.TotalAscent),
+	}, nil
+}
+
+// StatsBucket holds the totals of one week, month or year
+type StatsBucket struct {
+	Period StatsPeriod
+	Start  time.Time // first instant of the bucket in the rider's time zone
+	End    time.Time // exclusive
+	Label  string    // 2024-W18, 2024-05 or 2024
+
+	Rides      int
+	Distance   float64
+	MovingTime time.Duration
+	Ascent     float64
+
+	// LongestRide is the ride with the largest distance
+	LongestRide RideStats
+
+	// AverageSpeed is Distance / MovingTime in m/s, zero without moving time
+	AverageSpeed float64
+}
+
+func (b *StatsBucket) add(ride RideStats) {
+	b.Rides++
+	b.Distance += ride.Distance
+	b.MovingTime += ride.MovingTime
+	b.Ascent += ride.Ascent
+	if b.Rides == 1 || ride.Distance > b.LongestRide.Distance {
+		b.LongestRide = ride
+	}
+	if b.MovingTime > 0 {
+		b.AverageSpeed = b.Distance / b.MovingTime.Seconds()
+	}
+}
+
+// bucketStart returns the start of the period containing t in loc
+func bucketStart(t time.Time, period StatsPeriod, options StatsOptions) time.Time {
+	t = t.In(options.location())
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch period {
+	case PERIOD_WEEK:
+		offset := (int(day.Weekday()) - int(options.weekStart()) + 7) % 7
+		return day.AddDate(0, 0, -offset)
+	case PERIOD_MONTH:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+func newStatsBucket(start time.Time, period StatsPeriod) StatsBucket {
+	b := StatsBucket{Period: period, Start: start}
+	switch period {
+	case PERIOD_WEEK:
+		b.End = start.AddDate(0, 0, 7)
+		year, week := start.AddDate(0, 0, 3).ISOWeek()
+		b.Label = fmt.Sprintf("%d-W%02d", year, week)
+	case PERIOD_MONTH:
+		b.End = start.AddDate(0, 1, 0)
+		b.Label = start.Format("2006-01")
+	default:
+		b.End = start.AddDate(1, 0, 0)
+		b.Label = start.Format("2006")
+	}
+	return b
+}
+
+// ComputeStats groups rides into weeks, months or years of the rider's time
+// zone and returns the buckets in chronological order. Periods without
+// rides between the first and the last ride are included with zero totals.
+func ComputeStats(rides []RideStats, period StatsPeriod, options StatsOptions) []StatsBucket {
+	if len(rides) == 0 {
+		return nil
+	}
+	byStart := make(map[int64]*StatsBucket)
+	var first, last time.Time
+	for _, ride := range rides {
+		start := bucketStart(ride.StartTime, period, options)
+		b, ok := byStart[start.Unix()]
+		if !ok {
+			bucket := newStatsBucket(start, period)
+			b = &bucket
+			byStart[start.Unix()] = b
+		}
+		b.add(ride)
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if start.After(last) {
+			last = start
+		}
+	}
+
+	var buckets []StatsBucket
+	for start := first; !start.After(last); {
+		b, ok := byStart[start.Unix()]
+		if !ok {
+			bucket := newStatsBucket(start, period)
+			b = &bucket
+		}
+		buckets = append(buckets, *b)
+		start = b.End
+	}
+	return buckets
+}
+
+// YearComparison compares the totals of this year so far with the same
+// part of the previous year
+type YearComparison struct {
+	Current  StatsBucket
+	Previous StatsBucket
+
+	// Changes in percent, zero when the previous value is zero
+	RidesChange      float64
+	DistanceChange   float64
+	MovingTimeChange float64
+	AscentChange     float64
+}
+
+// CompareYears sums rides from January 1st to asOf (exclusive) and the same
+// span of the previous year
+func CompareYears(rides []RideStats, asOf time.Time, options StatsOptions) YearComparison {
+	loc := options.location()
+	asOf = asOf.In(loc)
+	yearStart := time.Date(asOf.Year(), 1, 1, 0, 0, 0, 0, loc)
+	previousStart := yearStart.AddDate(-1, 0, 0)
+	previousEnd := asOf.AddDate(-1, 0, 0)
+
+	c := YearComparison{
+		Current:  StatsBucket{Period: PERIOD_YEAR, Start: yearStart, End: asOf, Label: yearStart.Format("2006")},
+		Previous: StatsBucket{Period: PERIOD_YEAR, Start: previousStart, End: previousEnd, Label: previousStart.Format("2006")},
+	}
+	for _, ride := range rides {
+		switch {
+		case !ride.StartTime.Before(yearStart) && ride.StartTime.Before(asOf):
+			c.Current.add(ride)
+		case !ride.StartTime.Before(previousStart) && ride.StartTime.Before(previousEnd):
+			c.Previous.add(ride)
+		}
+	}
+	c.RidesChange = percentChange(float64(c.Current.Rides), float64(c.Previous.Rides))
+	c.DistanceChange = percentChange(c.Current.Distance, c.Previous.Distance)
+	c.MovingTimeChange = percentChange(c.Current.MovingTime.Seconds(), c.Previous.MovingTime.Seconds())
+	c.AscentChange = percentChange(c.Current.Ascent, c.Previous.Ascent)
+	return c
+}
+
+func percentChange(current, previous float64) float64 {
+	if previous == 0 {
+		return 0
+	}
+	return (current - previous) / previous * 100
+}
+
+// Streak is a run of consecutive days or weeks with at least one ride
+type Streak struct {
+	Length int
+	Start  time.Time // first day or week start
+	End    time.Time // last day or week start
+}
+
+// StreakStats holds the current and longest day and week streaks
+type StreakStats struct {
+	// CurrentDays is the streak ending asOf, or the day before if there is
+	// no ride on asOf yet
+	CurrentDays  Streak
+	LongestDays  Streak
+	CurrentWeeks Streak
+	LongestWeeks Streak
+}
+
+// Streaks computes riding streaks in the rider's time zone as of asOf
+func Streaks(rides []RideStats, asOf time.Time, options StatsOptions) StreakStats {
+	var s StreakStats
+	s.CurrentDays, s.LongestDays = streaks(rides, asOf, func(t time.Time) time.Time {
+		t = t.In(options.location())
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}, func(t time.Time) time.Time { return t.AddDate(0, 0, 1) })
+	s.CurrentWeeks, s.LongestWeeks = streaks(rides, asOf, func(t time.Time) time.Time {
+		return bucketStart(t, PERIOD_WEEK, options)
+	}, func(t time.Time) time.Time { return t.AddDate(0, 0, 7) })
+	return s
+}
+
+// streaks finds runs of consecutive periods; period maps a time to the
+// start of its period and next steps to the following period
+func streaks(rides []RideStats, asOf time.Time, period, next func(time.Time) time.Time) (current, longest Streak) {
+	seen := make(map[int64]bool)
+	var starts []time.Time
+	for _, ride := range rides {
+		p := period(ride.StartTime)
+		if !seen[p.Unix()] {
+			seen[p.Unix()] = true
+			starts = append(starts, p)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	var run Streak
+	for _, p := range starts {
+		if run.Length > 0 && next(run.End).Equal(p) {
+			run.Length++
+			run.End = p
+		} else {
+			run = Streak{Length: 1, Start: p, End: p}
+		}
+		if run.Length > longest.Length {
+			longest = run
+		}
+	}
+
+	// the current streak may end in the current or the previous period
+	now := period(asOf)
+	if run.Length > 0 && (run.End.Equal(now) || next(run.End).Equal(now)) {
+		current = run
+	}
+	return current, longest
+}
+
+// ListRideStats lists the activities between beginTime and endTime as stats
+// input. With withDetail the details are fetched with GetActivityDetails to
+// fill in moving time and ascent.
+func (s *IgpsportSync) ListRideStats(beginTime, endTime string, withDetail bool, loc *time.Location) ([]RideStats, error) {
+	rows, err := s.ListAllActivities(beginTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	var details map[int]ActivityDetailData
+	if withDetail {
+		ids := make([]int, len(rows))
+		for i, row := range rows {
+			ids[i] = row.RideID
+		}
+		if details, err = s.GetActivityDetails(context.Background(), ids); err != nil {
+			return nil, err
+		}
+	}
+	rides := make([]RideStats, 0, len(rows))
+	for _, row := range rows {
+		var ride RideStats
+		if withDetail {
+			ride, err = RideStatsFromDetail(details[row.RideID], loc)
+		} else {
+			ride, err = RideStatsFromRow(row, loc)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rides = append(rides, ride)
+	}
+	return rides, nil
+}
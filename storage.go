@@ -0,0 +1,243 @@
+package igpsportsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNotStored is returned by Storage.Get for a missing file
+var ErrNotStored = errors.New("activity file not stored")
+
+// Storage keeps activity files by RideID and format. Implementations must
+// be safe for concurrent use.
+type Storage interface {
+	Put(ctx context.Context, rideID int, ext Extension, data []byte) error
+
+	// Get returns ErrNotStored if the file does not exist
+	Get(ctx context.Context, rideID int, ext Extension) ([]byte, error)
+
+	Exists(ctx context.Context, rideID int, ext Extension) (bool, error)
+
+	// List returns every stored file ordered by RideID and format
+	List(ctx context.Context) ([]StoredFile, error)
+
+	// Delete removes a file; deleting a missing file is not an error
+	Delete(ctx context.Context, rideID int, ext Extension) error
+}
+
+// StoredFile is a file listed by a Storage
+type StoredFile struct {
+	RideID    int
+	Extension Extension
+	Size      int64
+}
+
+// storedFileName returns the <rideId>.<ext> name every storage uses
+func storedFileName(rideID int, ext Extension) string {
+	return strconv.Itoa(rideID) + "." + ext.Name()
+}
+
+// parseStoredFileName is the inverse of storedFileName
+func parseStoredFileName(name string) (int, Extension, bool) {
+	base, suffix, ok := strings.Cut(name, ".")
+	if !ok {
+		return 0, "", false
+	}
+	rideID, err := strconv.Atoi(base)
+	if err != nil {
+		return 0, "", false
+	}
+	ext, err := ParseExtension(suffix)
+	if err != nil || ext.Name() != suffix {
+		return 0, "", false
+	}
+	return rideID, ext, true
+}
+
+func sortStoredFiles(files []StoredFile) {
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].RideID != files[j].RideID {
+			return files[i].RideID < files[j].RideID
+		}
+		return files[i].Extension.Name() < files[j].Extension.Name()
+	})
+}
+
+// storageLocation describes where a storage keeps a file, for the catalog
+func storageLocation(storage Storage, rideID int, ext Extension) string {
+	if l, ok := storage.(interface {
+		Location(rideID int, ext Extension) string
+	}); ok {
+		return l.Location(rideID, ext)
+	}
+	return storedFileName(rideID, ext)
+}
+
+// LocalStorage keeps files as <rideId>.<ext> in a directory
+type LocalStorage struct {
+	Dir string
+}
+
+// NewLocalStorage creates the directory if needed
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating directory: %v", err)
+	}
+	return &LocalStorage{Dir: dir}, nil
+}
+
+// Location returns the path of a file
+func (l *LocalStorage) Location(rideID int, ext Extension) string {
+	return filepath.Join(l.Dir, storedFileName(rideID, ext))
+}
+
+func (l *LocalStorage) Put(ctx context.Context, rideID int, ext Extension, data []byte) error {
+	return writeFileAtomic(l.Location(rideID, ext), data)
+}
+
+func (l *LocalStorage) Get(ctx context.Context, rideID int, ext Extension) ([]byte, error) {
+	data, err := os.ReadFile(l.Location(rideID, ext))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotStored
+	}
+	return data, err
+}
+
+func (l *LocalStorage) Exists(ctx context.Context, rideID int, ext Extension) (bool, error) {
+	_, err := os.Stat(l.Location(rideID, ext))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *LocalStorage) List(ctx context.Context) ([]StoredFile, error) {
+	entries, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []StoredFile
+	for _, entry := range entries {
+		rideID, ext, ok := parseStoredFileName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, StoredFile{RideID: rideID, Extension: ext, Size: info.Size()})
+	}
+	sortStoredFiles(files)
+	return files, nil
+}
+
+func (l *LocalStorage) Delete(ctx context.Context, rideID int, ext Extension) error {
+	err := os.Remove(l.Location(rideID, ext))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// MemoryStorage keeps files in memory, e.g. for tests
+type MemoryStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string][]byte)}
+}
+
+// Location returns a memory:// url of a file
+func (m *MemoryStorage) Location(rideID int, ext Extension) string {
+	return "memory://" + storedFileName(rideID, ext)
+}
+
+func (m *MemoryStorage) Put(ctx context.Context, rideID int, ext Extension, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[storedFileName(rideID, ext)] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *MemoryStorage) Get(ctx context.Context, rideID int, ext Extension) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[storedFileName(rideID, ext)]
+	if !ok {
+		return nil, ErrNotStored
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (m *MemoryStorage) Exists(ctx context.Context, rideID int, ext Extension) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.files[storedFileName(rideID, ext)]
+	return ok, nil
+}
+
+func (m *MemoryStorage) List(ctx context.Context) ([]StoredFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var files []StoredFile
+	for name, data := range m.files {
+		rideID, ext, _ := parseStoredFileName(name)
+		files = append(files, StoredFile{RideID: rideID, Extension: ext, Size: int64(len(data))})
+	}
+	sortStoredFiles(files)
+	return files, nil
+}
+
+func (m *MemoryStorage) Delete(ctx context.Context, rideID int, ext Extension) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, storedFileName(rideID, ext))
+	return nil
+}
+
+// StorageState is a SyncState listing a Storage once, for syncs whose
+// manifest is not at hand. Its entries only carry RideID, Format, File and
+// Size.
+type StorageState struct {
+	entries []ManifestEntry
+	synced  map[string]bool
+}
+
+// NewStorageState lists the storage
+func NewStorageState(ctx context.Context, storage Storage) (*StorageState, error) {
+	files, err := storage.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing storage: %v", err)
+	}
+	state := &StorageState{synced: make(map[string]bool)}
+	for _, file := range files {
+		name := storedFileName(file.RideID, file.Extension)
+		state.synced[name] = true
+		state.entries = append(state.entries, ManifestEntry{
+			RideID: file.RideID,
+			Format: file.Extension.Name(),
+			File:   name,
+			Size:   file.Size,
+		})
+	}
+	return state, nil
+}
+
+func (s *StorageState) Synced(rideID int, ext Extension) bool {
+	return s.synced[storedFileName(rideID, ext)]
+}
+
+func (s *StorageState) Activities() ([]ManifestEntry, error) {
+	return s.entries, nil
+}
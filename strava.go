@@ -0,0 +1,242 @@
+package igpsportsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const STRAVA_URL = "https://www.strava.com"
+
+// StravaToken is an OAuth token of the Strava API
+type StravaToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// StravaConfig configures a StravaUploader. The token needs the
+// activity:write scope.
+type StravaConfig struct {
+	ClientID     string
+	ClientSecret string
+
+	// Token is the initial token; only RefreshToken is required, an
+	// expired or missing access token is refreshed before the first upload
+	Token StravaToken
+
+	// OnToken is called with every refreshed token so it can be stored,
+	// Strava invalidates the previous refresh token (optional)
+	OnToken func(StravaToken) error
+
+	// BaseURL replaces STRAVA_URL, e.g. for tests
+	BaseURL string
+
+	// PollInterval is the wait between upload status checks, default 2s
+	PollInterval time.Duration
+
+	// PollTimeout bounds the wait for processing, default 2 minutes
+	PollTimeout time.Duration
+
+	// Client defaults to an http.Client with a 60 second timeout
+	Client *http.Client
+}
+
+// StravaUploader uploads activity files to Strava
+type StravaUploader struct {
+	config StravaConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	token StravaToken
+}
+
+func NewStravaUploader(config StravaConfig) *StravaUploader {
+	if config.BaseURL == "" {
+		config.BaseURL = STRAVA_URL
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 2 * time.Second
+	}
+	if config.PollTimeout <= 0 {
+		config.PollTimeout = 2 * time.Minute
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &StravaUploader{config: config, client: client, token: config.Token}
+}
+
+func (u *StravaUploader) Name() string {
+	return "strava"
+}
+
+// stravaUpload is the upload status returned by the uploads endpoints
+type stravaUpload struct {
+	ID         int64  `json:"id"`
+	ExternalID string `json:"external_id"`
+	Error      string `json:"error"`
+	Status     string `json:"status"`
+	ActivityID int64  `json:"activity_id"`
+}
+
+// stravaDuplicate matches the activity id in errors such as
+// "1.fit duplicate of <a href='/activities/123'>Morning Ride</a>"
+var stravaDuplicate = regexp.MustCompile(`duplicate of (?:.*?activities/)?(\d+)`)
+
+// Upload sends the file with the iGPSport RideID as external_id and polls
+// until Strava has created the activity
+func (u *StravaUploader) Upload(ctx context.Context, activity *DownloadedActivity) (UploadResult, error) {
+	result := UploadResult{Destination: u.Name(), RideID: activity.RideID}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("data_type", activity.Extension.Name())
+	form.WriteField("external_id", "igpsport-"+strconv.Itoa(activity.RideID))
+	if activity.Title != "" {
+		form.WriteField("name", activity.Title)
+	}
+	file, err := form.CreateFormFile("file", storedFileName(activity.RideID, activity.Extension))
+	if err != nil {
+		return result, err
+	}
+	file.Write(activity.Data)
+	if err := form.Close(); err != nil {
+		return result, err
+	}
+
+	var upload stravaUpload
+	err = u.do(ctx, "POST", "/api/v3/uploads", form.FormDataContentType(), body.Bytes(), &upload)
+	if err != nil {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.config.PollTimeout)
+	defer cancel()
+	for {
+		if upload.Error != "" {
+			if m := stravaDuplicate.FindStringSubmatch(upload.Error); m != nil {
+				result.Duplicate, result.RemoteID = true, m[1]
+				result.UploadedAt = time.Now().UTC()
+				return result, nil
+			}
+			return result, fmt.Errorf("strava: %s", upload.Error)
+		}
+		if upload.ActivityID != 0 {
+			result.RemoteID = strconv.FormatInt(upload.ActivityID, 10)
+			result.UploadedAt = time.Now().UTC()
+			return result, nil
+		}
+		select {
+		case <-ctx.Done():
+			return result, fmt.Errorf("strava upload %d still processing: %v", upload.ID, ctx.Err())
+		case <-time.After(u.config.PollInterval):
+		}
+		err := u.do(ctx, "GET", "/api/v3/uploads/"+strconv.FormatInt(upload.ID, 10), "", nil, &upload)
+		if err != nil {
+			return result, err
+		}
+	}
+}
+
+// do sends an authorized API request and decodes the JSON response. A 401
+// refreshes the token once and retries.
+func (u *StravaUploader) do(ctx context.Context, method, path, contentType string, body []byte, v any) error {
+	for attempt := 0; ; attempt++ {
+		token, err := u.accessToken(ctx, attempt > 0)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u.config.BaseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		res, err := u.client.Do(req)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		if res.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			continue
+		}
+		if res.StatusCode >= 300 {
+			return fmt.Errorf("strava %s %s: %s %s", method, path, res.Status, strings.TrimSpace(string(data)))
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("error decoding strava response: %v", err)
+		}
+		return nil
+	}
+}
+
+// accessToken returns a valid access token, refreshing it when it expires
+// within a minute or when force is set
+func (u *StravaUploader) accessToken(ctx context.Context, force bool) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !force && u.token.AccessToken != "" && time.Until(u.token.ExpiresAt) > time.Minute {
+		return u.token.AccessToken, nil
+	}
+
+	form := url.Values{
+		"client_id":     {u.config.ClientID},
+		"client_secret": {u.config.ClientSecret},
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {u.token.RefreshToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u.config.BaseURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := u.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return "", fmt.Errorf("error refreshing strava token: %s %s", res.Status, strings.TrimSpace(string(message)))
+	}
+	var refreshed struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresAt    int64  `json:"expires_at"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&refreshed); err != nil {
+		return "", fmt.Errorf("error decoding strava token: %v", err)
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = u.token.RefreshToken
+	}
+	u.token = StravaToken{
+		AccessToken:  refreshed.AccessToken,
+		RefreshToken: refreshed.RefreshToken,
+		ExpiresAt:    time.Unix(refreshed.ExpiresAt, 0),
+	}
+	if u.config.OnToken != nil {
+		if err := u.config.OnToken(u.token); err != nil {
+			return "", err
+		}
+	}
+	return u.token.AccessToken, nil
+}
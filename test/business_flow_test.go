@@ -120,14 +120,16 @@ func TestBusinessFlow(t *testing.T) {
 	// ===== Step 7: Test DownloadSingleActivity =====
 	t.Log("Step 7: Testing DownloadSingleActivity...")
 	singleDownloaded := false
-	err = client.DownloadSingleActivity(firstActivity.RideID, func(activity *igpsportsync.DownloadedActivity) bool {
-		if activity.Error != nil {
-			t.Logf("Step 7 WARNING: Error in callback: %v", activity.Error)
-			return false
-		}
-		singleDownloaded = true
-		t.Logf("  ✓ Downloaded: %s (%d bytes)", activity.Title, len(activity.Data))
-		return true
+	err = client.DownloadSingleActivity(firstActivity.RideID, igpsportsync.DownloadOptions{
+		Callback: func(activity *igpsportsync.DownloadedActivity) bool {
+			if activity.Error != nil {
+				t.Logf("Step 7 WARNING: Error in callback: %v", activity.Error)
+				return false
+			}
+			singleDownloaded = true
+			t.Logf("  ✓ Downloaded: %s (%d bytes)", activity.Title, len(activity.Data))
+			return true
+		},
 	})
 
 	if err != nil {
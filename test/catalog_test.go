@@ -0,0 +1,173 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+func openCatalog(t *testing.T, dir string) *igpsportsync.Catalog {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(dir, "catalog.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	catalog, err := igpsportsync.NewCatalog(db, time.UTC)
+	if err != nil {
+		t.Fatalf("Failed to create catalog: %v", err)
+	}
+	return catalog
+}
+
+// TestCatalogQuery tests storing rows and details and querying them offline
+func TestCatalogQuery(t *testing.T) {
+	catalog := openCatalog(t, t.TempDir())
+
+	rows := []igpsportsync.ActivityRow{
+		{RideID: 1, Title: "Morning Ride", RideDistance: 20000, StartTime: "2024-05-01 07:00:00", ProductName: "iGS630"},
+		{RideID: 2, Title: "Century", RideDistance: 160000, StartTime: "2024-05-04 06:00:00", ProductName: "iGS630"},
+		{RideID: 3, Title: "Evening commute", RideDistance: 12000, StartTime: "2024-05-10 18:00:00", ProductName: "BSC300"},
+	}
+	for _, row := range rows {
+		if err := catalog.PutRow(row); err != nil {
+			t.Fatalf("Failed to store row: %v", err)
+		}
+	}
+	err := catalog.PutDetail(igpsportsync.ActivityDetailData{
+		RideId: 2, Title: "Century", RideDistance: 160000, StartTime: "2024-05-04 06:00:00",
+		TotalAscent: 1200, MovingTime: 21600, DeviceInfo: igpsportsync.DeviceInfo{DeviceName: "iGPSPORT iGS630"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to store detail: %v", err)
+	}
+	// a later listing must not drop the detail
+	if err := catalog.PutRow(rows[1]); err != nil {
+		t.Fatalf("Failed to store row: %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		query igpsportsync.CatalogQuery
+		want  []int
+	}{
+		{"all", igpsportsync.CatalogQuery{}, []int{3, 2, 1}},
+		{"dates", igpsportsync.CatalogQuery{From: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)}, []int{2}},
+		{"distance", igpsportsync.CatalogQuery{MinDistance: 15000, MaxDistance: 100000}, []int{1}},
+		{"device", igpsportsync.CatalogQuery{Device: "igs630"}, []int{2, 1}},
+		{"text", igpsportsync.CatalogQuery{Text: "COMMUTE"}, []int{3}},
+		{"limit", igpsportsync.CatalogQuery{Limit: 1}, []int{3}},
+	}
+	for _, c := range cases {
+		entries, err := catalog.Query(c.query)
+		if err != nil {
+			t.Fatalf("%s: query failed: %v", c.name, err)
+		}
+		var got []int
+		for _, e := range entries {
+			got = append(got, e.Row.RideID)
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+
+	entry, err := catalog.Get(2)
+	if err != nil || entry == nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	if entry.Detail == nil || entry.Detail.TotalAscent != 1200 || entry.Row.ProductName != "iGS630" {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	if missing, err := catalog.Get(99); err != nil || missing != nil {
+		t.Errorf("Expected no entry for unknown ride, got %+v (%v)", missing, err)
+	}
+}
+
+// TestCatalogDiskSink tests that saved files and summaries land in the catalog
+func TestCatalogDiskSink(t *testing.T) {
+	dir := t.TempDir()
+	catalog := openCatalog(t, dir)
+	sink, err := igpsportsync.NewDiskSink(filepath.Join(dir, "activities"), false)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	sink.Catalog = catalog
+
+	activity := &igpsportsync.DownloadedActivity{
+		RideID:    7,
+		Title:     "Test ride",
+		StartTime: "2024-05-01 08:00:00",
+		Extension: igpsportsync.FIT,
+		Data:      BuildFITFile(SampleRide(time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC))),
+	}
+	reported := make(map[int]error)
+	report := func(rideID int, err error) { reported[rideID] = err }
+	callback := sink.Callback(context.Background(), catalog.Callback(250, igpsportsync.HeartRateOptions{MaxHR: 190, LTHR: 170}, report, nil))
+	callback(activity)
+	if activity.Error != nil {
+		t.Fatalf("Failed to save activity: %v", activity.Error)
+	}
+	if len(reported) != 0 {
+		t.Errorf("Unexpected catalog errors: %v", reported)
+	}
+
+	// an undecodable file is reported, not dropped
+	callback(&igpsportsync.DownloadedActivity{RideID: 8, Extension: igpsportsync.FIT, Data: []byte("not a fit file")})
+	if reported[8] == nil {
+		t.Errorf("Analyze failure not reported")
+	}
+
+	entry, err := catalog.Get(7)
+	if err != nil || entry == nil {
+		t.Fatalf("Activity not in catalog: %v", err)
+	}
+	if entry.Row.Title != "Test ride" || entry.Row.StartTime != "2024-05-01 08:00:00" {
+		t.Errorf("Unexpected row: %+v", entry.Row)
+	}
+	if len(entry.Files) != 1 || entry.Files[0].Format != "fit" || entry.Files[0].Size != int64(len(activity.Data)) {
+		t.Errorf("Unexpected files: %+v", entry.Files)
+	}
+	if entry.Summary == nil || entry.Summary.AveragePower != 200 || entry.Summary.AverageHeartRate != 140 || entry.Summary.HrTSS == 0 {
+		t.Errorf("Unexpected summary: %+v", entry.Summary)
+	}
+}
+
+// TestSyncCatalog tests mirroring the activity list and details from the API
+func TestSyncCatalog(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(25, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	client := server.Client(t, igpsportsync.Config{})
+	catalog := openCatalog(t, t.TempDir())
+
+	n, err := client.SyncCatalog(catalog, "", "", true)
+	if err != nil || n != 25 {
+		t.Fatalf("Synced %d activities: %v", n, err)
+	}
+	// details already stored are not fetched again
+	if _, err := client.SyncCatalog(catalog, "", "", true); err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if got := server.Requests("detail"); got != 25 {
+		t.Errorf("Expected 25 detail requests, got %d", got)
+	}
+
+	entries, err := catalog.Query(igpsportsync.CatalogQuery{Text: "ride 2"})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	// Ride 2 and Ride 20..25
+	if len(entries) != 7 || entries[0].Detail == nil || entries[0].Detail.MovingTime != 600 {
+		t.Errorf("Unexpected entries: %d", len(entries))
+	}
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestDeduplicatorMetadata tests duplicate detection on start time, duration and distance
+func TestDeduplicatorMetadata(t *testing.T) {
+	dedup := igpsportsync.NewDeduplicator(igpsportsync.DedupOptions{Location: time.UTC})
+
+	rows := []igpsportsync.ActivityRow{
+		{RideID: 10, StartTime: "2024-05-01 08:00:00", RideDistance: 42000},
+		{RideID: 11, StartTime: "2024-05-01 08:00:30", RideDistance: 42100}, // re-sync
+		{RideID: 12, StartTime: "2024-05-01 08:10:00", RideDistance: 42000}, // too late
+		{RideID: 13, StartTime: "2024-05-01 08:00:10", RideDistance: 30000}, // too short
+		{RideID: 10, StartTime: "2024-05-01 08:00:00", RideDistance: 42000}, // same ride, other format
+	}
+	want := []bool{false, true, false, false, false}
+	for i, row := range rows {
+		if _, dup := dedup.CheckRow(row); dup != want[i] {
+			t.Errorf("Row %d (ride %d): duplicate=%v, want %v", i, row.RideID, dup, want[i])
+		}
+	}
+
+	// a known duration mismatch rules out a duplicate
+	pairs := igpsportsync.FindDuplicates([]igpsportsync.ActivityFingerprint{
+		{RideID: 1, StartTime: time.Unix(0, 0), Duration: time.Hour, Distance: 100},
+		{RideID: 2, StartTime: time.Unix(30, 0), Duration: 2 * time.Hour, Distance: 100},
+		{RideID: 3, StartTime: time.Unix(60, 0), Duration: time.Hour + time.Minute, Distance: 101},
+	}, igpsportsync.DedupOptions{})
+	if len(pairs) != 1 || pairs[0].RideID != 3 || pairs[0].DuplicateOf != 1 {
+		t.Errorf("Unexpected pairs: %+v", pairs)
+	}
+
+	// rides without distance need a matching duration or device
+	pairs = igpsportsync.FindDuplicates([]igpsportsync.ActivityFingerprint{
+		{RideID: 1, StartTime: time.Unix(0, 0), Duration: time.Hour, Device: "iGS630"},
+		{RideID: 2, StartTime: time.Unix(30, 0)},                                    // unknown duration and device
+		{RideID: 3, StartTime: time.Unix(30, 0), Device: "iGS BSC300"},              // other device
+		{RideID: 4, StartTime: time.Unix(60, 0), Duration: time.Hour + time.Minute}, // same duration
+		{RideID: 5, StartTime: time.Unix(60, 0), Device: "igs630"},                  // same device
+	}, igpsportsync.DedupOptions{})
+	if len(pairs) != 2 || pairs[0].RideID != 4 || pairs[0].DuplicateOf != 1 || pairs[1].RideID != 5 || pairs[1].DuplicateOf != 1 {
+		t.Errorf("Unexpected zero distance pairs: %+v", pairs)
+	}
+}
+
+// TestDeduplicatorContent tests duplicate detection on file content
+func TestDeduplicatorContent(t *testing.T) {
+	dedup := igpsportsync.NewDeduplicator(igpsportsync.DedupOptions{})
+
+	if _, dup := dedup.CheckContent(1, []byte("fit data")); dup {
+		t.Errorf("First content reported as duplicate")
+	}
+	if _, dup := dedup.CheckContent(1, []byte("fit data")); dup {
+		t.Errorf("Same ride reported as its own duplicate")
+	}
+	original, dup := dedup.CheckContent(2, []byte("fit data"))
+	if !dup || original != 1 {
+		t.Errorf("Expected ride 2 to duplicate ride 1, got %d %v", original, dup)
+	}
+
+	pairs := dedup.Pairs()
+	if len(pairs) != 1 || pairs[0].Reason != igpsportsync.DUPLICATE_CONTENT {
+		t.Errorf("Unexpected pairs: %+v", pairs)
+	}
+}
+
+// TestActivityFilter tests the listing filter
+func TestActivityFilter(t *testing.T) {
+	filter := &igpsportsync.ActivityFilter{MinDistance: 1000, Text: "commute", Device: "bsc"}
+
+	cases := []struct {
+		row  igpsportsync.ActivityRow
+		want bool
+	}{
+		{igpsportsync.ActivityRow{RideID: 1, Title: "Morning Commute", ProductName: "iGS BSC300", RideDistance: 5000}, true},
+		{igpsportsync.ActivityRow{RideID: 2, Title: "Morning Commute", ProductName: "iGS BSC300", RideDistance: 500}, false},
+		{igpsportsync.ActivityRow{RideID: 3, Title: "Race", ProductName: "iGS BSC300", RideDistance: 5000}, false},
+		{igpsportsync.ActivityRow{RideID: 4, Title: "Commute", ProductName: "iGS630", RideDistance: 5000}, false},
+	}
+	for _, c := range cases {
+		if got := filter.Match(c.row); got != c.want {
+			t.Errorf("Ride %d: Match=%v, want %v", c.row.RideID, got, c.want)
+		}
+	}
+
+	var nilFilter *igpsportsync.ActivityFilter
+	if !nilFilter.Match(cases[1].row) {
+		t.Errorf("Nil filter should match everything")
+	}
+}
@@ -0,0 +1,135 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestGetActivityDetailsConcurrency tests bounded concurrency and partial failures
+func TestGetActivityDetailsConcurrency(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(20, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	server.Delay = 20 * time.Millisecond
+	server.FailDetail[7] = true
+	client := server.Client(t, igpsportsync.Config{DetailConcurrency: 4})
+
+	ids := []int{}
+	for id := 1; id <= 20; id++ {
+		ids = append(ids, id)
+	}
+	ids = append(ids, 3, 3) // duplicates are fetched once
+
+	details, err := client.GetActivityDetails(context.Background(), ids)
+	if err == nil {
+		t.Errorf("Expected an error for ride 7")
+	}
+	if len(details) != 19 {
+		t.Fatalf("Expected 19 details, got %d", len(details))
+	}
+	if details[3].RideId != 3 || details[3].TotalAscent != 10 {
+		t.Errorf("Unexpected detail: %+v", details[3])
+	}
+	if _, ok := details[7]; ok {
+		t.Errorf("Failed detail must not be returned")
+	}
+	if n := server.Requests("detail"); n != 20 {
+		t.Errorf("Expected 20 detail requests, got %d", n)
+	}
+	if max := server.MaxInFlight(); max > 4 || max < 2 {
+		t.Errorf("Expected up to 4 concurrent requests, got %d", max)
+	}
+}
+
+// TestGetActivityDetailsCache tests memory and disk caches
+func TestGetActivityDetailsCache(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(5, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	disk, err := igpsportsync.NewDiskDetailCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create disk cache: %v", err)
+	}
+	caches := []struct {
+		name  string
+		cache igpsportsync.DetailCache
+	}{
+		{"memory", igpsportsync.NewMemoryDetailCache(time.Hour)},
+		{"disk", disk},
+	}
+	for _, c := range caches {
+		before := server.Requests("detail")
+		client := server.Client(t, igpsportsync.Config{DetailCache: c.cache})
+		if _, err := client.GetActivityDetails(context.Background(), []int{1, 2, 3}); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		details, err := client.GetActivityDetails(context.Background(), []int{1, 2, 3, 4})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(details) != 4 || details[2].Title != "Ride 2" {
+			t.Errorf("%s: unexpected details %+v", c.name, details)
+		}
+		if n := server.Requests("detail") - before; n != 4 {
+			t.Errorf("%s: expected 4 detail requests, got %d", c.name, n)
+		}
+	}
+
+	// expired entries are fetched again
+	memory := igpsportsync.NewMemoryDetailCache(time.Millisecond)
+	memory.Set(1, igpsportsync.ActivityDetailData{RideId: 1})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := memory.Get(1); ok {
+		t.Errorf("Expected memory entry to expire")
+	}
+
+	// a failing cache does not fail the fetch and is reported
+	var mu sync.Mutex
+	var reported []error
+	client := server.Client(t, igpsportsync.Config{DetailCache: failingCache{}, OnCacheError: func(rideID int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, err)
+	}})
+	details, err := client.GetActivityDetails(context.Background(), []int{1, 2})
+	if err != nil || len(details) != 2 {
+		t.Errorf("Cache failure failed the fetch: %d details, %v", len(details), err)
+	}
+	if len(reported) != 2 || !strings.Contains(reported[0].Error(), "disk full") {
+		t.Errorf("Expected 2 cache errors reported, got %v", reported)
+	}
+}
+
+// failingCache is a DetailCache whose writes always fail
+type failingCache struct{}
+
+func (failingCache) Get(int) (igpsportsync.ActivityDetailData, bool) {
+	return igpsportsync.ActivityDetailData{}, false
+}
+
+func (failingCache) Set(int, igpsportsync.ActivityDetailData) error {
+	return errors.New("disk full")
+}
+
+// TestGetActivityDetailsCancel tests that a cancelled context stops pending requests
+func TestGetActivityDetailsCancel(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(10, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	server.Delay = time.Second
+	client := server.Client(t, igpsportsync.Config{DetailConcurrency: 2})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	details, err := client.GetActivityDetails(ctx, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+	if err == nil || len(details) != 0 {
+		t.Errorf("Expected cancellation, got %d details and %v", len(details), err)
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("Cancellation took %v", elapsed)
+	}
+	if n := server.Requests("detail"); n > 2 {
+		t.Errorf("Expected no requests after cancellation, got %d", n)
+	}
+}
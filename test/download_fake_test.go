@@ -0,0 +1,414 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestDownloadIncludeDetail tests attaching the row and detail to downloads
+func TestDownloadIncludeDetail(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(6, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	server.Delay = 10 * time.Millisecond
+	server.FailDetail[4] = true
+	client := server.Client(t, igpsportsync.Config{})
+
+	var mu sync.Mutex
+	got := make(map[int]*igpsportsync.DownloadedActivity)
+	err := client.DownloadAllActivitiesWithConcurrency(igpsportsync.DownloadOptions{
+		Extension:      igpsportsync.FIT,
+		MaxConcurrency: 3,
+		IncludeDetail:  true,
+		Callback: func(activity *igpsportsync.DownloadedActivity) bool {
+			mu.Lock()
+			got[activity.RideID] = activity
+			mu.Unlock()
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if len(got) != 6 {
+		t.Fatalf("Expected 6 activities, got %d", len(got))
+	}
+	for id, activity := range got {
+		if activity.Error != nil || len(activity.Data) == 0 {
+			t.Errorf("Activity %d not downloaded: %v", id, activity.Error)
+		}
+		if activity.Row == nil || activity.Row.ProductName != "iGS630" {
+			t.Errorf("Activity %d has no row", id)
+		}
+		if id == 4 {
+			if activity.Detail != nil || activity.DetailError == nil {
+				t.Errorf("Expected a detail error for activity 4")
+			}
+			continue
+		}
+		if activity.Detail == nil || activity.Detail.RideId != id || activity.Detail.TotalAscent != 10 {
+			t.Errorf("Activity %d has unexpected detail %+v (%v)", id, activity.Detail, activity.DetailError)
+		}
+	}
+
+	// without the option no detail is requested
+	before := server.Requests("detail")
+	err = client.DownloadAllActivities(igpsportsync.DownloadOptions{
+		Callback: func(activity *igpsportsync.DownloadedActivity) bool {
+			if activity.Detail != nil || activity.Row == nil {
+				t.Errorf("Unexpected enrichment of activity %d", activity.RideID)
+			}
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if server.Requests("detail") != before {
+		t.Errorf("Detail requested without IncludeDetail")
+	}
+}
+
+// TestDownloadActivities tests downloading an explicit RideID list into a sink
+func TestDownloadActivities(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(5, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	client := server.Client(t, igpsportsync.Config{})
+	sink, err := igpsportsync.NewDiskSink(t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	defer sink.Close()
+
+	var got []*igpsportsync.DownloadedActivity
+	summary, err := client.DownloadActivities(context.Background(), []int{4, 99, 2}, igpsportsync.DownloadOptions{
+		Extension: igpsportsync.GPX,
+		Sink:      sink,
+		Callback: func(activity *igpsportsync.DownloadedActivity) bool {
+			got = append(got, activity)
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if len(got) != 3 || got[0].RideID != 4 || got[1].RideID != 99 || got[2].RideID != 2 {
+		t.Fatalf("Unexpected activities: %+v", got)
+	}
+	if got[0].Title != "Ride 4" || got[0].Detail == nil || got[0].Extension != igpsportsync.GPX {
+		t.Errorf("Unexpected metadata: %+v", got[0])
+	}
+	if got[1].Error == nil || got[1].DetailError == nil {
+		t.Errorf("Expected errors for unknown ride 99")
+	}
+	data, err := os.ReadFile(filepath.Join(sink.Dir, "2.gpx"))
+	if err != nil {
+		t.Fatalf("Sink did not store the file: %v", err)
+	}
+	if track, err := igpsportsync.DecodeTrack(data, igpsportsync.GPX); err != nil || len(track.Points) != 601 {
+		t.Errorf("Stored file is not the GPX track: %v", err)
+	}
+	if server.Requests("list") != 0 {
+		t.Errorf("An explicit id list must not list activities")
+	}
+	if summary.Succeeded != 2 || summary.Failed != 1 || summary.Skipped != 0 || summary.StoppedEarly {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	// a cancelled context stops before downloading
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary, err = client.DownloadActivities(ctx, []int{1}, igpsportsync.DownloadOptions{Sink: sink})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if !summary.StoppedEarly || summary.Skipped != 1 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+}
+
+// TestDownloadSingleActivityOptions tests the single download path with a sink only
+func TestDownloadSingleActivityOptions(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(2, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	client := server.Client(t, igpsportsync.Config{})
+	sink, err := igpsportsync.NewDiskSink(t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+
+	if err := client.DownloadSingleActivity(1, igpsportsync.DownloadOptions{Extension: igpsportsync.TCX, Sink: sink}); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(sink.Dir, "1.tcx"))
+	if err != nil {
+		t.Fatalf("Sink did not store the file: %v", err)
+	}
+	if track, err := igpsportsync.DecodeTrack(data, igpsportsync.TCX); err != nil || len(track.Points) != 601 {
+		t.Errorf("Stored file is not the TCX track: %v", err)
+	}
+	if err := client.DownloadSingleActivity(1, igpsportsync.DownloadOptions{}); err == nil {
+		t.Errorf("Expected an error without callback or sink")
+	}
+	if err := client.DownloadSingleActivity(42, igpsportsync.DownloadOptions{Sink: sink}); err == nil {
+		t.Errorf("Expected an error for an unknown ride")
+	}
+}
+
+// TestDownloadFormatFallback tests recording the format served when the
+// file cannot be converted to the requested one
+func TestDownloadFormatFallback(t *testing.T) {
+	activities := FakeActivities(2, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC))
+	gpx, err := igpsportsync.ConvertActivity(activities[1].Data, igpsportsync.GPX)
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	activities[1].Data = gpx // ride 1
+	server := NewFakeServer(t, activities)
+	client := server.Client(t, igpsportsync.Config{})
+	sink, err := igpsportsync.NewDiskSink(t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	defer sink.Close()
+
+	var got *igpsportsync.DownloadedActivity
+	err = client.DownloadSingleActivity(1, igpsportsync.DownloadOptions{
+		Extension: igpsportsync.FIT,
+		Sink:      sink,
+		Callback: func(activity *igpsportsync.DownloadedActivity) bool {
+			got = activity
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if got.Extension != igpsportsync.GPX {
+		t.Errorf("Expected the GPX format, got %s", got.Extension.Name())
+	}
+	if _, err := os.Stat(filepath.Join(sink.Dir, "1.gpx")); err != nil {
+		t.Errorf("GPX data not stored as 1.gpx: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sink.Dir, "1.fit")); !os.IsNotExist(err) {
+		t.Errorf("GPX data stored as 1.fit")
+	}
+	report, err := igpsportsync.VerifyManifest(context.Background(), filepath.Join(sink.Dir, igpsportsync.MANIFEST_FILE), nil)
+	if err != nil || report.OK != 1 || len(report.Problems()) != 0 {
+		t.Errorf("Unexpected verify report: %+v (%v)", report, err)
+	}
+
+	// the ride counts as synced in the requested format too
+	state, err := igpsportsync.LoadManifestState(filepath.Join(sink.Dir, igpsportsync.MANIFEST_FILE))
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if !state.Synced(1, igpsportsync.FIT) || !state.Synced(1, igpsportsync.GPX) || state.Synced(1, igpsportsync.TCX) {
+		t.Errorf("Unexpected synced formats")
+	}
+	plan, err := client.PlanDownload(context.Background(), igpsportsync.DownloadOptions{Extension: igpsportsync.FIT, State: state})
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if ids := plan.RideIDs(igpsportsync.PLAN_DOWNLOAD); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("Expected only ride 2 to be downloaded, got %v", ids)
+	}
+}
+
+// TestDownloadOrderedDelivery tests listing-order delivery and serialized callbacks
+func TestDownloadOrderedDelivery(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(12, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	server.Delay = 5 * time.Millisecond
+	client := server.Client(t, igpsportsync.Config{})
+
+	for _, ordered := range []bool{false, true} {
+		var active int32
+		var got []int
+		err := client.DownloadAllActivitiesWithConcurrency(igpsportsync.DownloadOptions{
+			Extension:       igpsportsync.FIT,
+			MaxConcurrency:  4,
+			OrderedDelivery: ordered,
+			ReorderBuffer:   3,
+			Callback: func(activity *igpsportsync.DownloadedActivity) bool {
+				if atomic.AddInt32(&active, 1) != 1 {
+					t.Errorf("Callbacks overlap")
+				}
+				got = append(got, activity.RideID)
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&active, -1)
+				return true
+			},
+		})
+		if err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+		if len(got) != 12 {
+			t.Fatalf("ordered=%v: expected 12 activities, got %v", ordered, got)
+		}
+		if !ordered {
+			continue
+		}
+		for i, id := range got {
+			if id != 12-i {
+				t.Fatalf("Expected listing order, got %v", got)
+			}
+		}
+	}
+}
+
+// checkNoPipelineGoroutines fails if download goroutines outlive the call
+func checkNoPipelineGoroutines(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		buf := make([]byte, 1<<20)
+		stacks := string(buf[:runtime.Stack(buf, true)])
+		if !strings.Contains(stacks, "igpsportsync.(*IgpsportSync).downloadAll") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Download goroutines leaked:\n%s", stacks)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestDownloadSummary tests the summary of a complete run
+func TestDownloadSummary(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(25, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	client := server.Client(t, igpsportsync.Config{})
+
+	summary, err := client.DownloadAllActivitiesContext(context.Background(), igpsportsync.DownloadOptions{
+		MaxConcurrency: 4,
+		Filter:         &igpsportsync.ActivityFilter{Text: "ride 1"}, // Ride 1, 10..19
+		Callback:       func(activity *igpsportsync.DownloadedActivity) bool { return true },
+	})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if summary.Listed != 25 || summary.Succeeded != 11 || summary.Failed != 0 || summary.Skipped != 14 || summary.StoppedEarly {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if summary.Duration <= 0 {
+		t.Errorf("Duration not set")
+	}
+	checkNoPipelineGoroutines(t)
+}
+
+// TestDownloadStop tests that returning false stops the pipeline
+func TestDownloadStop(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(45, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	server.Delay = 20 * time.Millisecond
+	client := server.Client(t, igpsportsync.Config{})
+
+	for _, ordered := range []bool{false, true} {
+		before := server.Requests("file")
+		delivered := 0
+		summary, err := client.DownloadAllActivitiesContext(context.Background(), igpsportsync.DownloadOptions{
+			MaxConcurrency:  4,
+			OrderedDelivery: ordered,
+			Callback: func(activity *igpsportsync.DownloadedActivity) bool {
+				delivered++
+				if delivered > 3 {
+					t.Errorf("Callback called after returning false")
+				}
+				return delivered < 3
+			},
+		})
+		if err != nil {
+			t.Fatalf("ordered=%v: unexpected error %v", ordered, err)
+		}
+		if !summary.StoppedEarly || summary.Succeeded != 3 || summary.Listed != summary.Succeeded+summary.Failed+summary.Skipped {
+			t.Errorf("ordered=%v: unexpected summary %+v", ordered, summary)
+		}
+		if n := server.Requests("file") - before; n >= 20 {
+			t.Errorf("ordered=%v: %d files requested after stopping", ordered, n)
+		}
+		checkNoPipelineGoroutines(t)
+	}
+}
+
+// TestDownloadCancel tests that cancelling the context ends the run promptly
+func TestDownloadCancel(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(30, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	server.Delay = time.Second
+	client := server.Client(t, igpsportsync.Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	summary, err := client.DownloadAllActivitiesContext(ctx, igpsportsync.DownloadOptions{
+		MaxConcurrency:  3,
+		OrderedDelivery: true,
+		Callback: func(activity *igpsportsync.DownloadedActivity) bool {
+			t.Errorf("Unexpected delivery of %d", activity.RideID)
+			return true
+		},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("Cancellation took %v", elapsed)
+	}
+	if !summary.StoppedEarly || summary.Succeeded != 0 || summary.Skipped != summary.Listed {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	checkNoPipelineGoroutines(t)
+}
+
+// TestDownloadProgress tests progress updates of a filtered run
+func TestDownloadProgress(t *testing.T) {
+	activities := FakeActivities(25, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC))
+	server := NewFakeServer(t, activities)
+	client := server.Client(t, igpsportsync.Config{})
+
+	var updates []igpsportsync.DownloadProgress
+	_, err := client.DownloadAllActivitiesContext(context.Background(), igpsportsync.DownloadOptions{
+		MaxConcurrency: 3,
+		Filter:         &igpsportsync.ActivityFilter{Text: "ride 2"}, // Ride 2, 20..25
+		Callback:       func(activity *igpsportsync.DownloadedActivity) bool { return true },
+		Progress: func(progress igpsportsync.DownloadProgress) {
+			updates = append(updates, progress)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if len(updates) != 25 {
+		t.Fatalf("Expected 25 updates, got %d", len(updates))
+	}
+	for i, update := range updates {
+		if update.Total != 25 || update.Completed != i+1 {
+			t.Fatalf("Unexpected update %d: %+v", i, update)
+		}
+	}
+	last := updates[24]
+	if last.Skipped != 18 || last.Failed != 0 || last.Percent() != 100 || last.ETA != 0 {
+		t.Errorf("Unexpected last update: %+v", last)
+	}
+	if want := int64(7 * len(activities[0].Data)); last.Bytes != want || last.Throughput <= 0 {
+		t.Errorf("Expected %d bytes, got %+v", want, last)
+	}
+
+	// explicit ids report against their count
+	updates = nil
+	_, err = client.DownloadActivities(context.Background(), []int{1, 99}, igpsportsync.DownloadOptions{
+		Callback: func(activity *igpsportsync.DownloadedActivity) bool { return true },
+		Progress: func(progress igpsportsync.DownloadProgress) {
+			updates = append(updates, progress)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if len(updates) != 2 || updates[0].Total != 2 || updates[0].ETA <= 0 || updates[1].Failed != 1 {
+		t.Errorf("Unexpected updates: %+v", updates)
+	}
+}
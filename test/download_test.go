@@ -72,20 +72,23 @@ func TestDownloadSingleActivity(t *testing.T) {
 	t.Logf("Testing DownloadSingleActivity with ride ID: %d", rideID)
 
 	downloaded := false
-	err = client.DownloadSingleActivity(rideID, func(activity *igpsportsync.DownloadedActivity) bool {
-		if activity.Error != nil {
-			t.Fatalf("Error downloading activity: %v", activity.Error)
-			return false
-		}
-
-		t.Logf("Successfully downloaded activity:")
-		t.Logf("  RideID: %d", activity.RideID)
-		t.Logf("  Title: %s", activity.Title)
-		t.Logf("  StartTime: %s", activity.StartTime)
-		t.Logf("  Size: %d bytes", len(activity.Data))
-
-		downloaded = true
-		return true
+	err = client.DownloadSingleActivity(rideID, igpsportsync.DownloadOptions{
+		Extension: igpsportsync.FIT,
+		Callback: func(activity *igpsportsync.DownloadedActivity) bool {
+			if activity.Error != nil {
+				t.Fatalf("Error downloading activity: %v", activity.Error)
+				return false
+			}
+
+			t.Logf("Successfully downloaded activity:")
+			t.Logf("  RideID: %d", activity.RideID)
+			t.Logf("  Title: %s", activity.Title)
+			t.Logf("  StartTime: %s", activity.StartTime)
+			t.Logf("  Size: %d bytes", len(activity.Data))
+
+			downloaded = true
+			return true
+		},
 	})
 
 	if err != nil {
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// FakeIntervalsActivity is an activity received by FakeIntervals
+type FakeIntervalsActivity struct {
+	ID         string
+	ExternalID string
+	Name       string
+	FileName   string
+	Size       int
+}
+
+// FakeIntervals is an in-process stand-in for the intervals.icu activity upload API
+type FakeIntervals struct {
+	*httptest.Server
+
+	APIKey string
+
+	mu         sync.Mutex
+	activities map[string]FakeIntervalsActivity // by external id
+}
+
+// NewFakeIntervals starts a fake intervals.icu API. It is closed when the test ends.
+func NewFakeIntervals(t *testing.T) *FakeIntervals {
+	t.Helper()
+	f := &FakeIntervals{APIKey: "intervals-key", activities: make(map[string]FakeIntervalsActivity)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Activities returns the uploaded activities by external id
+func (f *FakeIntervals) Activities() map[string]FakeIntervalsActivity {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	activities := make(map[string]FakeIntervalsActivity)
+	for id, a := range f.activities {
+		activities[id] = a
+	}
+	return activities
+}
+
+func (f *FakeIntervals) handle(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != "API_KEY" || password != f.APIKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeJSON(w, map[string]any{"status": 401, "error": "Unauthorized"})
+		return
+	}
+	if r.Method != "POST" || r.URL.Path != "/api/v1/athlete/0/activities" {
+		http.NotFound(w, r)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		writeJSON(w, map[string]any{"status": 422, "error": "file required"})
+		return
+	}
+	data, _ := io.ReadAll(file)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	externalID := r.URL.Query().Get("external_id")
+	if _, ok := f.activities[externalID]; ok && externalID != "" {
+		w.WriteHeader(http.StatusConflict)
+		writeJSON(w, map[string]any{"status": 409, "error": "Duplicate activity"})
+		return
+	}
+	activity := FakeIntervalsActivity{
+		ID:         fmt.Sprintf("i%d", 100+len(f.activities)),
+		ExternalID: externalID,
+		Name:       r.URL.Query().Get("name"),
+		FileName:   header.Filename,
+		Size:       len(data),
+	}
+	f.activities[externalID] = activity
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, map[string]any{"id": activity.ID, "name": activity.Name, "external_id": externalID})
+}
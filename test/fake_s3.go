@@ -0,0 +1,228 @@
+package test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// FakeS3 is an in-process S3-compatible bucket store, like a local MinIO.
+// It checks the Signature Version 4 of every request.
+type FakeS3 struct {
+	*httptest.Server
+
+	AccessKey string
+	SecretKey string
+	Region    string
+
+	// PageSize limits ListObjectsV2 results per page
+	PageSize int
+
+	mu      sync.Mutex
+	objects map[string][]byte // "bucket/key"
+}
+
+// NewFakeS3 starts a fake S3 with one bucket. It is closed when the test ends.
+func NewFakeS3(t *testing.T, bucket string) *FakeS3 {
+	t.Helper()
+	f := &FakeS3{
+		AccessKey: "minio",
+		SecretKey: "minio-secret",
+		Region:    "us-east-1",
+		PageSize:  1000,
+		objects:   map[string][]byte{bucket + "/": nil},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Object returns a stored object
+func (f *FakeS3) Object(bucket, key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[bucket+"/"+key]
+	return data, ok
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(s3Error{Code: code, Message: code})
+}
+
+func (f *FakeS3) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := f.verify(r, body); err != nil {
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch: "+err.Error())
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.objects[bucket+"/"]; !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	if key == "" && r.Method == "GET" {
+		f.list(w, bucket, r.URL.Query())
+		return
+	}
+	name := bucket + "/" + key
+	switch r.Method {
+	case "PUT":
+		f.objects[name] = body
+	case "GET", "HEAD":
+		data, ok := f.objects[name]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	case "DELETE":
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
+	Contents              []listBucketObject
+}
+
+type listBucketObject struct {
+	Key  string `xml:"Key"`
+	Size int    `xml:"Size"`
+}
+
+// list serves ListObjectsV2; the continuation token is the last key returned
+func (f *FakeS3) list(w http.ResponseWriter, bucket string, query url.Values) {
+	var keys []string
+	for name := range f.objects {
+		key, ok := strings.CutPrefix(name, bucket+"/")
+		if ok && key != "" && strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	result := listBucketResult{}
+	if len(keys) > f.PageSize {
+		keys = keys[:f.PageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, listBucketObject{Key: key, Size: len(f.objects[bucket+"/"+key])})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// verify recomputes the signature of a request
+func (f *FakeS3) verify(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		fields[name] = value
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != f.AccessKey || credential[2] != f.Region {
+		return fmt.Errorf("bad credential %q", fields["Credential"])
+	}
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != r.Header.Get("X-Amz-Content-Sha256") {
+		return fmt.Errorf("payload hash mismatch")
+	}
+
+	headers := map[string]string{}
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		if name == "host" {
+			headers[name] = r.Host
+		} else {
+			headers[name] = r.Header.Get(name)
+		}
+	}
+	want := SignV4(r.Method, r.URL.Path, r.URL.Query(), headers, r.Header.Get("X-Amz-Content-Sha256"),
+		r.Header.Get("X-Amz-Date"), f.Region, f.SecretKey)
+	if want != fields["Signature"] {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// SignV4 computes an S3 Signature Version 4 from the request parts, for
+// checking requests independently of the client under test
+func SignV4(method, path string, query url.Values, headers map[string]string, payloadHash, amzDate, region, secret string) string {
+	escape := func(s string, slash bool) string {
+		var b strings.Builder
+		for _, c := range []byte(s) {
+			if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~", c) >= 0 || slash && c == '/' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		return b.String()
+	}
+
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, escape(key, false)+"="+escape(value, false))
+		}
+	}
+	sort.Strings(params)
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + strings.TrimSpace(headers[name]) + "\n"
+	}
+
+	canonical := method + "\n" + escape(path, true) + "\n" + strings.Join(params, "&") + "\n" +
+		canonicalHeaders + "\n" + strings.Join(names, ";") + "\n" + payloadHash
+	hash := sha256.Sum256([]byte(canonical))
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+secret), amzDate[:8])
+	key = mac(key, region)
+	key = mac(key, "s3")
+	key = mac(key, "aws4_request")
+	return hex.EncodeToString(mac(key, stringToSign))
+}
@@ -0,0 +1,231 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// FakeActivity is one activity served by FakeServer
+type FakeActivity struct {
+	Row    igpsportsync.ActivityRow
+	Detail igpsportsync.ActivityDetailData
+	Data   []byte
+}
+
+// FakeServer is an in-process stand-in for the iGPSport API, for tests
+// that must not reach the real service
+type FakeServer struct {
+	*httptest.Server
+
+	// Delay is added to every detail and file request
+	Delay time.Duration
+
+	// FailDetail makes the detail request of these rides return an API error
+	FailDetail map[int]bool
+
+	mu         sync.Mutex
+	activities []FakeActivity
+	requests   map[string]int
+
+	inFlight    int32
+	maxInFlight int32
+}
+
+// NewFakeServer starts a fake API serving the given activities, newest first
+// as listed. It is closed when the test ends.
+func NewFakeServer(t *testing.T, activities []FakeActivity) *FakeServer {
+	t.Helper()
+	f := &FakeServer{activities: activities, requests: make(map[string]int), FailDetail: make(map[int]bool)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// FakeActivities builds n activities one day apart with FIT files,
+// RideIDs 1..n and the newest first
+func FakeActivities(n int, start time.Time) []FakeActivity {
+	var activities []FakeActivity
+	for i := n; i >= 1; i-- {
+		begin := start.AddDate(0, 0, i-1)
+		startTime := begin.Format("2006-01-02 15:04:05")
+		title := fmt.Sprintf("Ride %d", i)
+		activities = append(activities, FakeActivity{
+			Row: igpsportsync.ActivityRow{RideID: i, Title: title, RideDistance: 3000, StartTime: startTime, ProductName: "iGS630"},
+			Detail: igpsportsync.ActivityDetailData{
+				RideId: i, Title: title, StartTime: startTime, RideDistance: 3000,
+				MovingTime: 600, TotalTime: 600, TotalAscent: 10,
+				DeviceInfo: igpsportsync.DeviceInfo{DeviceName: "iGS630"},
+			},
+			Data: BuildFITFile(SampleRide(begin)),
+		})
+	}
+	return activities
+}
+
+// Client logs in to the fake server
+func (f *FakeServer) Client(t *testing.T, config igpsportsync.Config) *igpsportsync.IgpsportSync {
+	t.Helper()
+	config.BaseURL = f.URL
+	config.Username, config.Password = "rider", "secret"
+	client, err := igpsportsync.New(config)
+	if err != nil {
+		t.Fatalf("Failed to log in to fake server: %v", err)
+	}
+	return client
+}
+
+// Requests returns how many requests an endpoint received:
+// "login", "list", "detail", "downloadUrl", "file" or "userInfo"
+func (f *FakeServer) Requests(endpoint string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[endpoint]
+}
+
+// MaxInFlight returns the highest number of concurrent detail and file requests
+func (f *FakeServer) MaxInFlight() int {
+	return int(atomic.LoadInt32(&f.maxInFlight))
+}
+
+// Delete removes an activity, as if the rider deleted it in the app
+func (f *FakeServer) Delete(id int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, a := range f.activities {
+		if a.Row.RideID == id {
+			f.activities = append(f.activities[:i], f.activities[i+1:]...)
+			return
+		}
+	}
+}
+
+// Retitle changes the title of an activity in the list and its detail
+func (f *FakeServer) Retitle(id int, title string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.activities {
+		if f.activities[i].Row.RideID == id {
+			f.activities[i].Row.Title = title
+			f.activities[i].Detail.Title = title
+		}
+	}
+}
+
+func (f *FakeServer) find(id int) (FakeActivity, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, a := range f.activities {
+		if a.Row.RideID == id {
+			return a, true
+		}
+	}
+	return FakeActivity{}, false
+}
+
+func (f *FakeServer) count(endpoint string) {
+	f.mu.Lock()
+	f.requests[endpoint]++
+	f.mu.Unlock()
+}
+
+// slow tracks concurrency and applies Delay; the request context ends it early
+func (f *FakeServer) slow(r *http.Request) {
+	n := atomic.AddInt32(&f.inFlight, 1)
+	defer atomic.AddInt32(&f.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&f.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(&f.maxInFlight, max, n) {
+			break
+		}
+	}
+	select {
+	case <-time.After(f.Delay):
+	case <-r.Context().Done():
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *FakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	const activity = "web-gateway/web-analyze/activity/"
+	id, _ := strconv.Atoi(path[strings.LastIndex(path, "/")+1:])
+
+	if path != "auth/account/login" && !strings.HasPrefix(path, "files/") && r.Header.Get("Authorization") != "Bearer fake-token" {
+		writeJSON(w, map[string]any{"code": 401, "message": "unauthorized"})
+		return
+	}
+
+	switch {
+	case path == "auth/account/login":
+		f.count("login")
+		writeJSON(w, map[string]any{"code": 0, "data": map[string]any{"access_token": "fake-token"}})
+
+	case path == "mobile/api/User/UserInfo":
+		f.count("userInfo")
+		writeJSON(w, map[string]any{"code": 0, "data": map[string]any{"memberId": 1, "ftp": 250, "mhr": 190, "lthr": 170}})
+
+	case path == activity+"queryMyActivity":
+		f.count("list")
+		pageNo, _ := strconv.Atoi(r.URL.Query().Get("pageNo"))
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+		f.mu.Lock()
+		var rows []igpsportsync.ActivityRow
+		for _, a := range f.activities {
+			rows = append(rows, a.Row)
+		}
+		f.mu.Unlock()
+		total := len(rows)
+		from, to := min((pageNo-1)*pageSize, total), min(pageNo*pageSize, total)
+		writeJSON(w, map[string]any{"code": 0, "data": igpsportsync.ActivityListData{
+			Rows: rows[from:to], PageNo: pageNo, PageSize: pageSize, TotalRows: total,
+			TotalPage: (total + pageSize - 1) / pageSize,
+		}})
+
+	case strings.HasPrefix(path, activity+"queryActivityDetail/"):
+		f.count("detail")
+		f.slow(r)
+		a, ok := f.find(id)
+		if !ok || f.FailDetail[id] {
+			writeJSON(w, map[string]any{"code": 500, "message": "detail unavailable"})
+			return
+		}
+		detail := a.Detail
+		detail.FitUrl = f.URL + "/files/" + strconv.Itoa(id)
+		writeJSON(w, map[string]any{"code": 0, "data": detail})
+
+	case strings.HasPrefix(path, activity+"getDownloadUrl/"):
+		f.count("downloadUrl")
+		if _, ok := f.find(id); !ok {
+			writeJSON(w, map[string]any{"code": 404, "message": "activity not found", "data": nil})
+			return
+		}
+		writeJSON(w, map[string]any{"code": 0, "data": f.URL + "/files/" + strconv.Itoa(id)})
+
+	case strings.HasPrefix(path, "files/"):
+		f.count("file")
+		f.slow(r)
+		a, ok := f.find(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(a.Data)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
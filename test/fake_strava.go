@@ -0,0 +1,180 @@
+package test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeStravaUpload is an upload received by FakeStrava
+type FakeStravaUpload struct {
+	ID         int64
+	ExternalID string
+	Name       string
+	DataType   string
+	ActivityID int64
+	Error      string
+	polls      int
+}
+
+// FakeStrava is an in-process stand-in for the Strava OAuth and uploads API
+type FakeStrava struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	// TokenTTL is the lifetime of issued access tokens
+	TokenTTL time.Duration
+
+	// ProcessingPolls is how many status polls an upload stays in processing
+	ProcessingPolls int
+
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+	expires      time.Time
+	refreshes    int
+	uploads      map[int64]*FakeStravaUpload
+	activities   map[[32]byte]int64 // file hash to activity id
+	nextID       int64
+}
+
+// NewFakeStrava starts a fake Strava API accepting refreshToken. It is
+// closed when the test ends.
+func NewFakeStrava(t *testing.T, refreshToken string) *FakeStrava {
+	t.Helper()
+	f := &FakeStrava{
+		ClientID:        "123",
+		ClientSecret:    "client-secret",
+		TokenTTL:        6 * time.Hour,
+		ProcessingPolls: 1,
+		refreshToken:    refreshToken,
+		uploads:         make(map[int64]*FakeStravaUpload),
+		activities:      make(map[[32]byte]int64),
+		nextID:          1000,
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Refreshes returns how many tokens were issued
+func (f *FakeStrava) Refreshes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refreshes
+}
+
+// Revoke invalidates the current access token
+func (f *FakeStrava) Revoke() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.accessToken = ""
+}
+
+// Uploads returns the uploads received, by id
+func (f *FakeStrava) Uploads() map[int64]FakeStravaUpload {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	uploads := make(map[int64]FakeStravaUpload)
+	for id, upload := range f.uploads {
+		uploads[id] = *upload
+	}
+	return uploads
+}
+
+func (f *FakeStrava) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/oauth/token" {
+		r.ParseForm()
+		if r.Form.Get("client_id") != f.ClientID || r.Form.Get("client_secret") != f.ClientSecret ||
+			r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != f.refreshToken {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]any{"message": "Bad Request"})
+			return
+		}
+		f.refreshes++
+		f.accessToken = fmt.Sprintf("access-%d", f.refreshes)
+		f.refreshToken = fmt.Sprintf("refresh-%d", f.refreshes)
+		f.expires = time.Now().Add(f.TokenTTL)
+		writeJSON(w, map[string]any{
+			"token_type": "Bearer", "access_token": f.accessToken, "refresh_token": f.refreshToken,
+			"expires_at": f.expires.Unix(), "expires_in": int(f.TokenTTL.Seconds()),
+		})
+		return
+	}
+
+	if f.accessToken == "" || r.Header.Get("Authorization") != "Bearer "+f.accessToken || time.Now().After(f.expires) {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeJSON(w, map[string]any{"message": "Authorization Error"})
+		return
+	}
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/api/v3/uploads":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]any{"message": "file required"})
+			return
+		}
+		data, _ := io.ReadAll(file)
+		f.nextID++
+		upload := &FakeStravaUpload{
+			ID:         f.nextID,
+			ExternalID: r.FormValue("external_id"),
+			Name:       r.FormValue("name"),
+			DataType:   r.FormValue("data_type"),
+		}
+		hash := sha256.Sum256(data)
+		if existing, ok := f.activities[hash]; ok {
+			upload.Error = fmt.Sprintf("%s duplicate of <a href='/activities/%d' target='_blank'>activity</a>", upload.ExternalID, existing)
+		} else {
+			f.nextID++
+			upload.ActivityID = f.nextID
+			f.activities[hash] = upload.ActivityID
+		}
+		f.uploads[upload.ID] = upload
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, f.status(upload))
+
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/api/v3/uploads/"):
+		id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/v3/uploads/"), 10, 64)
+		upload, ok := f.uploads[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(w, map[string]any{"message": "Record Not Found"})
+			return
+		}
+		upload.polls++
+		writeJSON(w, f.status(upload))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// status reports an upload as processing until it was polled ProcessingPolls times
+func (f *FakeStrava) status(upload *FakeStravaUpload) map[string]any {
+	status := map[string]any{"id": upload.ID, "id_str": strconv.FormatInt(upload.ID, 10), "external_id": upload.ExternalID,
+		"error": nil, "status": "Your activity is still being processed.", "activity_id": nil}
+	if upload.polls < f.ProcessingPolls {
+		return status
+	}
+	if upload.Error != "" {
+		status["error"], status["status"] = upload.Error, "There was an error processing your activity."
+		return status
+	}
+	status["status"], status["activity_id"] = "Your activity is ready.", upload.ActivityID
+	return status
+}
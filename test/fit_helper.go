@@ -0,0 +1,167 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// FITPoint is a record sample written by BuildFITFile
+type FITPoint struct {
+	Time      time.Time
+	Lat       float64
+	Lon       float64
+	Altitude  float64
+	Distance  float64
+	Speed     float64
+	HeartRate uint8
+	Power     uint16
+}
+
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// BuildFITFile encodes a minimal cycling activity FIT file with a file_id,
+// one record per point and a session message
+func BuildFITFile(points []FITPoint) []byte {
+	return BuildFITFileWithLaps(points, 1)
+}
+
+// BuildFITFileWithLaps is BuildFITFile with the records split into laps of
+// equal length, each followed by a lap message; a single lap writes none
+func BuildFITFileWithLaps(points []FITPoint, laps int) []byte {
+	var body bytes.Buffer
+	le := binary.LittleEndian
+
+	// file_id: type, manufacturer, product, time_created
+	body.Write([]byte{0x40, 0, 0, 0, 0, 4, 0, 1, 0x00, 1, 2, 0x84, 2, 2, 0x84, 4, 4, 0x86})
+	body.WriteByte(0x00)
+	body.WriteByte(4) // activity file
+	binary.Write(&body, le, uint16(260))
+	binary.Write(&body, le, uint16(1))
+	binary.Write(&body, le, fitTimestamp(points[0].Time))
+
+	// record: timestamp, lat, long, altitude, heart_rate, distance, speed, power
+	body.Write([]byte{0x41, 0, 0, 20, 0, 8,
+		253, 4, 0x86, 0, 4, 0x85, 1, 4, 0x85, 2, 2, 0x84,
+		3, 1, 0x02, 5, 4, 0x86, 6, 2, 0x84, 7, 2, 0x84})
+	lapSize := (len(points) + laps - 1) / laps
+	var lapStart float64
+	for i, p := range points {
+		body.WriteByte(0x01)
+		binary.Write(&body, le, fitTimestamp(p.Time))
+		binary.Write(&body, le, int32(p.Lat*(1<<31)/180))
+		binary.Write(&body, le, int32(p.Lon*(1<<31)/180))
+		binary.Write(&body, le, uint16((p.Altitude+500)*5))
+		body.WriteByte(p.HeartRate)
+		binary.Write(&body, le, uint32(p.Distance*100))
+		binary.Write(&body, le, uint16(p.Speed*1000))
+		binary.Write(&body, le, p.Power)
+
+		// lap: timestamp, total_distance
+		if laps > 1 && ((i+1)%lapSize == 0 || i == len(points)-1) {
+			if i+1 == lapSize {
+				body.Write([]byte{0x43, 0, 0, 19, 0, 2, 253, 4, 0x86, 9, 4, 0x86})
+			}
+			body.WriteByte(0x03)
+			binary.Write(&body, le, fitTimestamp(p.Time))
+			binary.Write(&body, le, uint32((p.Distance-lapStart)*100))
+			lapStart = p.Distance
+		}
+	}
+
+	// session: timestamp, sport, total_distance
+	last := points[len(points)-1]
+	body.Write([]byte{0x42, 0, 0, 18, 0, 3, 253, 4, 0x86, 5, 1, 0x00, 9, 4, 0x86})
+	body.WriteByte(0x02)
+	binary.Write(&body, le, fitTimestamp(last.Time))
+	body.WriteByte(2) // cycling
+	binary.Write(&body, le, uint32(last.Distance*100))
+
+	var file bytes.Buffer
+	file.WriteByte(12)
+	file.WriteByte(0x20)
+	binary.Write(&file, le, uint16(2132))
+	binary.Write(&file, le, uint32(body.Len()))
+	file.WriteString(".FIT")
+	file.Write(body.Bytes())
+
+	var crc uint16
+	for _, b := range file.Bytes() {
+		crc = fitCRC(crc, b)
+	}
+	binary.Write(&file, le, crc)
+	return file.Bytes()
+}
+
+func fitTimestamp(t time.Time) uint32 {
+	return uint32(t.Sub(fitEpoch) / time.Second)
+}
+
+var fitCRCTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+func fitCRC(crc uint16, b byte) uint16 {
+	tmp := fitCRCTable[crc&0xF]
+	crc = (crc >> 4) & 0x0FFF
+	crc = crc ^ tmp ^ fitCRCTable[b&0xF]
+	tmp = fitCRCTable[crc&0xF]
+	crc = (crc >> 4) & 0x0FFF
+	return crc ^ tmp ^ fitCRCTable[(b>>4)&0xF]
+}
+
+// SampleRide returns a 10 minute ride heading north at 5 m/s, one sample per second
+func SampleRide(start time.Time) []FITPoint {
+	var points []FITPoint
+	for i := 0; i <= 600; i++ {
+		points = append(points, FITPoint{
+			Time:      start.Add(time.Duration(i) * time.Second),
+			Lat:       31.2 + float64(i)*5/111195,
+			Lon:       121.5,
+			Altitude:  10 + float64(i%100)/10,
+			Distance:  float64(i) * 5,
+			Speed:     5,
+			HeartRate: 140,
+			Power:     200,
+		})
+	}
+	return points
+}
+
+// FITLapDistances returns the lap total_distance values of a FIT file
+// written by BuildFITFileWithLaps, in meters
+func FITLapDistances(data []byte) []float64 {
+	type field struct{ num, size uint8 }
+	type definition struct {
+		num    uint16
+		fields []field
+	}
+	definitions := make(map[uint8]definition)
+	var distances []float64
+	end := 12 + int(binary.LittleEndian.Uint32(data[4:8]))
+	for pos := 12; pos < end; {
+		header := data[pos]
+		local := header & 0x0f
+		pos++
+		if header&0x40 != 0 {
+			def := definition{num: binary.LittleEndian.Uint16(data[pos+2:])}
+			n := int(data[pos+4])
+			pos += 5
+			for i := 0; i < n; i++ {
+				def.fields = append(def.fields, field{data[pos], data[pos+1]})
+				pos += 3
+			}
+			definitions[local] = def
+			continue
+		}
+		def := definitions[local]
+		for _, f := range def.fields {
+			if def.num == 19 && f.num == 9 {
+				distances = append(distances, float64(binary.LittleEndian.Uint32(data[pos:]))/100)
+			}
+			pos += int(f.size)
+		}
+	}
+	return distances
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestGarminFIT tests rewriting the device of a FIT file
+func TestGarminFIT(t *testing.T) {
+	original := BuildFITFile(SampleRide(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)))
+	patched, err := igpsportsync.GarminFIT(original, igpsportsync.GARMIN_MANUFACTURER, igpsportsync.GARMIN_PRODUCT_EDGE_530)
+	if err != nil {
+		t.Fatalf("GarminFIT failed: %v", err)
+	}
+
+	// the file_id data message follows the 12 byte header and its definition
+	if m := binary.LittleEndian.Uint16(patched[32:]); m != 1 {
+		t.Errorf("Manufacturer %d, want 1", m)
+	}
+	if p := binary.LittleEndian.Uint16(patched[34:]); p != 3121 {
+		t.Errorf("Product %d, want 3121", p)
+	}
+	if m := binary.LittleEndian.Uint16(original[32:]); m != 260 {
+		t.Errorf("Original modified: manufacturer %d", m)
+	}
+	var crc uint16
+	for _, b := range patched[:len(patched)-2] {
+		crc = fitCRC(crc, b)
+	}
+	if crc != binary.LittleEndian.Uint16(patched[len(patched)-2:]) {
+		t.Errorf("Invalid CRC")
+	}
+	if _, err := igpsportsync.DecodeTrack(patched, igpsportsync.FIT); err != nil {
+		t.Errorf("Patched file does not decode: %v", err)
+	}
+
+	if _, err := igpsportsync.GarminFIT([]byte("not a fit file"), 1, 1); err == nil {
+		t.Errorf("Expected an error for invalid data")
+	}
+}
+
+// TestExportGarmin tests bundling one file per ride under the size limit
+func TestExportGarmin(t *testing.T) {
+	ctx := context.Background()
+	storage := igpsportsync.NewMemoryStorage()
+	fit := BuildFITFile(SampleRide(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)))
+	var entries []igpsportsync.ManifestEntry
+	for id := 1; id <= 3; id++ {
+		storage.Put(ctx, id, igpsportsync.FIT, fit)
+		entries = append(entries, igpsportsync.ManifestEntry{RideID: id, Title: "Ride", Format: "fit"})
+	}
+	// ride 1 also has a GPX file, ride 4 only a GPX file
+	storage.Put(ctx, 1, igpsportsync.GPX, []byte("<gpx/>"))
+	storage.Put(ctx, 4, igpsportsync.GPX, []byte("<gpx/>"))
+	entries = append([]igpsportsync.ManifestEntry{{RideID: 1, Format: "gpx"}}, entries...)
+	entries = append(entries, igpsportsync.ManifestEntry{RideID: 4, Format: "gpx"})
+
+	dir := t.TempDir()
+	limit := int64(len(fit)*2 + 400)
+	exported, err := igpsportsync.ExportGarmin(ctx, storage, entries, dir, igpsportsync.GarminExportOptions{MaxBundleSize: limit})
+	if err != nil {
+		t.Fatalf("ExportGarmin failed: %v", err)
+	}
+	if len(exported) != 4 {
+		t.Fatalf("Exported %d rides, want 4", len(exported))
+	}
+	if exported[0].File != "1.fit" || !exported[0].Patched || exported[3].File != "4.gpx" || exported[3].Patched {
+		t.Errorf("Unexpected files: %+v", exported)
+	}
+
+	bundles := map[string][]string{}
+	for _, e := range exported {
+		bundles[e.Bundle] = append(bundles[e.Bundle], e.File)
+	}
+	if len(bundles) != 2 || len(bundles["garmin-001.zip"]) != 2 {
+		t.Errorf("Unexpected bundles: %v", bundles)
+	}
+	for name, files := range bundles {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Missing bundle %s: %v", name, err)
+		}
+		if info.Size() > limit {
+			t.Errorf("Bundle %s has %d bytes, limit %d", name, info.Size(), limit)
+		}
+		r, err := zip.OpenReader(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Invalid zip %s: %v", name, err)
+		}
+		for i, f := range r.File {
+			if f.Name != files[i] {
+				t.Errorf("Bundle %s has %s, want %s", name, f.Name, files[i])
+			}
+			if f.Name == "2.fit" {
+				rc, _ := f.Open()
+				data, _ := io.ReadAll(rc)
+				rc.Close()
+				if binary.LittleEndian.Uint16(data[32:]) != igpsportsync.GARMIN_MANUFACTURER {
+					t.Errorf("FIT file in bundle not patched")
+				}
+			}
+		}
+		r.Close()
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, igpsportsync.GARMIN_EXPORT_MANIFEST))
+	if err != nil {
+		t.Fatalf("Missing export manifest: %v", err)
+	}
+	var manifest []igpsportsync.GarminExportEntry
+	if err := json.Unmarshal(data, &manifest); err != nil || len(manifest) != 4 || manifest[2].RideID != 3 {
+		t.Errorf("Unexpected export manifest: %s (%v)", data, err)
+	}
+}
@@ -0,0 +1,85 @@
+package test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestSimplifyRoute tests Douglas-Peucker simplification
+func TestSimplifyRoute(t *testing.T) {
+	// a straight line with an L-shaped corner
+	var points []igpsportsync.LatLng
+	for i := 0; i <= 10; i++ {
+		points = append(points, igpsportsync.LatLng{Lat: 31 + float64(i)*0.001, Lng: 121})
+	}
+	for i := 1; i <= 10; i++ {
+		points = append(points, igpsportsync.LatLng{Lat: 31.01, Lng: 121 + float64(i)*0.001})
+	}
+
+	simplified := igpsportsync.SimplifyRoute(points, 5)
+	if len(simplified) != 3 || simplified[1] != points[10] {
+		t.Errorf("Expected start, corner and end, got %v", simplified)
+	}
+	if got := igpsportsync.SimplifyRoute(points, 0); len(got) != len(points) {
+		t.Errorf("Zero tolerance should keep every point, got %d", len(got))
+	}
+}
+
+// TestTrimRoute tests privacy zone and distance trimming of route ends
+func TestTrimRoute(t *testing.T) {
+	track, err := igpsportsync.DecodeTrack(BuildFITFile(SampleRide(time.Now())), igpsportsync.FIT)
+	if err != nil {
+		t.Fatalf("Failed to decode FIT: %v", err)
+	}
+	positions := track.Positions()
+	home := igpsportsync.PrivacyZone{Lat: positions[0].Lat, Lng: positions[0].Lng, Radius: 202}
+
+	route := track.Route(igpsportsync.RouteOptions{PrivacyZones: []igpsportsync.PrivacyZone{home}, TrimEnd: 502})
+	if len(route) == 0 {
+		t.Fatalf("Route trimmed completely")
+	}
+	if home.Contains(route[0]) {
+		t.Errorf("Route starts inside the privacy zone")
+	}
+	// points are 5 m apart: the 202 m zone drops 41, the 502 m end trim drops 101
+	if len(route) != len(positions)-41-101 {
+		t.Errorf("Unexpected route length %d of %d", len(route), len(positions))
+	}
+
+	all := igpsportsync.RouteOptions{PrivacyZones: []igpsportsync.PrivacyZone{{Lat: home.Lat, Lng: home.Lng, Radius: 10000}}}
+	if got := track.Route(all); len(got) != 0 {
+		t.Errorf("Expected empty route, got %d points", len(got))
+	}
+}
+
+// TestGeoJSONFeature tests the GeoJSON output of a track
+func TestGeoJSONFeature(t *testing.T) {
+	track, err := igpsportsync.DecodeTrack(BuildFITFile(SampleRide(time.Now())), igpsportsync.FIT)
+	if err != nil {
+		t.Fatalf("Failed to decode FIT: %v", err)
+	}
+
+	detail := &igpsportsync.ActivityDetailData{RideId: 42, Title: "Commute", FitUrl: "https://secret"}
+	feature, err := track.GeoJSONFeature(detail, igpsportsync.RouteOptions{Tolerance: 1})
+	if err != nil {
+		t.Fatalf("Failed to build feature: %v", err)
+	}
+	if feature.Geometry.Type != "LineString" || len(feature.Geometry.Coordinates) != 2 {
+		t.Errorf("Expected a simplified 2 point LineString, got %d points", len(feature.Geometry.Coordinates))
+	}
+	if c := feature.Geometry.Coordinates[0]; math.Abs(c[0]-121.5) > 1e-6 || math.Abs(c[1]-31.2) > 1e-6 {
+		t.Errorf("Expected [lng, lat] order, got %v", c)
+	}
+	if feature.Properties["title"] != "Commute" || feature.Properties["fitUrl"] != nil {
+		t.Errorf("Unexpected properties: %v", feature.Properties)
+	}
+
+	data, err := json.Marshal(igpsportsync.NewGeoJSONFeatureCollection(*feature))
+	if err != nil || len(data) == 0 {
+		t.Errorf("Failed to encode feature collection: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestAnalyzeHeartRate tests zones, TRIMP and hrTSS on a steady ride
+func TestAnalyzeHeartRate(t *testing.T) {
+	track, err := igpsportsync.DecodeTrack(BuildFITFile(SampleRide(time.Now())), igpsportsync.FIT)
+	if err != nil {
+		t.Fatalf("Failed to decode FIT: %v", err)
+	}
+
+	options := igpsportsync.HeartRateOptionsFromProfile(
+		igpsportsync.UserInfoResult{Mhr: 190, Lthr: 170, Sex: 1},
+		igpsportsync.HeartRateOptions{LTHR: 160},
+	)
+	if options.MaxHR != 190 || options.LTHR != 160 || options.Female == nil || *options.Female {
+		t.Fatalf("Overrides not applied: %+v", options)
+	}
+
+	summary, err := igpsportsync.AnalyzeHeartRate(track, options)
+	if err != nil {
+		t.Fatalf("Failed to analyze heart rate: %v", err)
+	}
+	if summary.AverageHeartRate != 140 || summary.Duration != 10*time.Minute {
+		t.Errorf("Unexpected average %.1f or duration %v", summary.AverageHeartRate, summary.Duration)
+	}
+	// 140 / 160 = 0.875 is Friel zone 2, 140 / 190 = 0.74 is max HR zone 3
+	if summary.LTHRZones[1].Duration != 10*time.Minute || summary.MaxHRZones[2].Duration != 10*time.Minute {
+		t.Errorf("Unexpected zones: %+v %+v", summary.LTHRZones, summary.MaxHRZones)
+	}
+
+	ratio := 80.0 / 130.0
+	want := 10 * ratio * 0.64 * math.Exp(1.92*ratio)
+	if math.Abs(summary.TRIMP-want) > 1e-6 {
+		t.Errorf("TRIMP %.3f, want %.3f", summary.TRIMP, want)
+	}
+	if summary.HrTSS <= 0 || summary.HrTSS >= 100.0/6 {
+		t.Errorf("10 minutes below LTHR must score under 16.7 hrTSS, got %.2f", summary.HrTSS)
+	}
+	if summary.HeartRateDrift != 0 || summary.AerobicDecoupling != 0 {
+		t.Errorf("Steady ride must not drift: %.2f %.2f", summary.HeartRateDrift, summary.AerobicDecoupling)
+	}
+}
+
+// TestHeartRateDecoupling tests drift and Pa:HR decoupling when heart rate rises at constant power
+func TestHeartRateDecoupling(t *testing.T) {
+	points := SampleRide(time.Now())
+	for i := range points {
+		if i >= len(points)/2 {
+			points[i].HeartRate = 154
+		}
+	}
+	track, err := igpsportsync.DecodeTrack(BuildFITFile(points), igpsportsync.FIT)
+	if err != nil {
+		t.Fatalf("Failed to decode FIT: %v", err)
+	}
+
+	summary, err := igpsportsync.AnalyzeHeartRate(track, igpsportsync.HeartRateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to analyze heart rate: %v", err)
+	}
+	if math.Abs(summary.HeartRateDrift-10) > 0.5 {
+		t.Errorf("Expected about 10%% drift, got %.2f", summary.HeartRateDrift)
+	}
+	if summary.AerobicDecoupling < 8 || summary.AerobicDecoupling > 10 {
+		t.Errorf("Expected about 9%% decoupling, got %.2f", summary.AerobicDecoupling)
+	}
+	if summary.LTHRZones != nil || summary.TRIMP != 0 {
+		t.Errorf("Reference dependent values must be empty without max HR and LTHR")
+	}
+}
+
+// TestHeartRateSexOverride tests that the Female override wins over the profile in both directions
+func TestHeartRateSexOverride(t *testing.T) {
+	female, male := true, false
+	cases := []struct {
+		sex      int
+		override *bool
+		want     bool
+	}{
+		{1, nil, false},
+		{2, nil, true},
+		{1, &female, true},
+		{2, &male, false},
+	}
+	for _, c := range cases {
+		options := igpsportsync.HeartRateOptionsFromProfile(
+			igpsportsync.UserInfoResult{Mhr: 190, Lthr: 170, Sex: c.sex},
+			igpsportsync.HeartRateOptions{Female: c.override},
+		)
+		if options.Female == nil || *options.Female != c.want {
+			t.Errorf("Sex %d with override %v: expected female %v", c.sex, c.override, c.want)
+		}
+	}
+}
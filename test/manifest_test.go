@@ -0,0 +1,72 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestManifestVerify tests that DiskSink records a manifest and that
+// VerifyManifest detects missing and corrupted files
+func TestManifestVerify(t *testing.T) {
+	dir := t.TempDir()
+
+	sink, err := igpsportsync.NewDiskSink(dir, true)
+	if err != nil {
+		t.Fatalf("Failed to create disk sink: %v", err)
+	}
+
+	activities := []*igpsportsync.DownloadedActivity{
+		{RideID: 1, Title: "ok", Extension: igpsportsync.FIT, SourceURL: "https://files.example.com/1.fit", Data: []byte("ride one")},
+		{RideID: 2, Title: "missing", Extension: igpsportsync.GPX, Data: []byte("ride two")},
+		{RideID: 3, Title: "corrupted", Extension: igpsportsync.TCX, Data: []byte("ride three")},
+		{RideID: 4, Title: "failed", Extension: igpsportsync.FIT, Error: errors.New("boom")},
+	}
+	callback := sink.Callback(context.Background(), nil)
+	for _, activity := range activities {
+		if !callback(activity) {
+			t.Fatalf("Callback asked to stop")
+		}
+		if activity.RideID != 4 && activity.Error != nil {
+			t.Fatalf("Failed to save activity %d: %v", activity.RideID, activity.Error)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "2.gpx")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "3.tcx"), []byte("tampered"), 0o644); err != nil {
+		t.Fatalf("Failed to tamper file: %v", err)
+	}
+
+	manifestPath := filepath.Join(dir, igpsportsync.MANIFEST_FILE)
+	entries, err := igpsportsync.ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 manifest entries, got %d", len(entries))
+	}
+	if entries[0].SourceHost != "files.example.com" || entries[0].Format != "fit" || entries[0].Size != 8 {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+
+	report, err := igpsportsync.VerifyManifest(context.Background(), manifestPath, nil)
+	if err != nil {
+		t.Fatalf("Failed to verify manifest: %v", err)
+	}
+	if report.OK != 1 || report.Missing != 1 || report.Corrupted != 1 || report.Failed != 1 {
+		t.Errorf("Unexpected report: ok=%d missing=%d corrupted=%d failed=%d",
+			report.OK, report.Missing, report.Corrupted, report.Failed)
+	}
+	if len(report.Problems()) != 3 {
+		t.Errorf("Expected 3 problems, got %d", len(report.Problems()))
+	}
+}
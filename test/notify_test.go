@@ -0,0 +1,211 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// webhookReceiver records signed deliveries, failing the first ones with status
+type webhookReceiver struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	failures int
+	status   int
+	events   []igpsportsync.ActivityEvent
+	requests int
+}
+
+func newWebhookReceiver(t *testing.T, secret string, failures, status int) *webhookReceiver {
+	r := &webhookReceiver{secret: secret, failures: failures, status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests++
+		timestamp, _ := strconv.ParseInt(req.Header.Get(igpsportsync.WEBHOOK_TIMESTAMP_HEADER), 10, 64)
+		if req.Header.Get(igpsportsync.WEBHOOK_SIGNATURE_HEADER) != igpsportsync.SignWebhook(r.secret, timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.failures > 0 {
+			r.failures--
+			w.WriteHeader(r.status)
+			return
+		}
+		var event igpsportsync.ActivityEvent
+		json.Unmarshal(body, &event)
+		r.events = append(r.events, event)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) Events() []igpsportsync.ActivityEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]igpsportsync.ActivityEvent(nil), r.events...)
+}
+
+// TestNotifier tests signed deliveries, retries, dead letters and redelivery
+func TestNotifier(t *testing.T) {
+	flaky := newWebhookReceiver(t, "s1", 2, http.StatusServiceUnavailable)
+	rejecting := newWebhookReceiver(t, "s2", 1, http.StatusBadRequest)
+	deadLetters := filepath.Join(t.TempDir(), "failed.jsonl")
+	notifier := igpsportsync.NewNotifier(igpsportsync.NotifierConfig{
+		Webhooks:       []igpsportsync.Webhook{{URL: flaky.URL, Secret: "s1"}, {URL: rejecting.URL, Secret: "s2"}},
+		MaxAttempts:    3,
+		Backoff:        time.Millisecond,
+		DeadLetterPath: deadLetters,
+		FTP:            250,
+	})
+
+	row := igpsportsync.ActivityRow{RideID: 7, Title: "Morning Ride", RideDistance: 3000}
+	activity := &igpsportsync.DownloadedActivity{
+		RideID:    7,
+		Extension: igpsportsync.FIT,
+		Data:      BuildFITFile(SampleRide(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))),
+		Row:       &row,
+	}
+	var reported []int
+	callback := notifier.Callback(context.Background(), func(rideID int, err error) {
+		reported = append(reported, rideID)
+	}, nil)
+	callback(activity)
+	callback(&igpsportsync.DownloadedActivity{RideID: 8, Error: io.EOF})
+	notifier.Close()
+
+	events := flaky.Events()
+	if len(events) != 1 || flaky.requests != 3 {
+		t.Fatalf("Expected 1 event after 3 requests, got %d after %d", len(events), flaky.requests)
+	}
+	event := events[0]
+	if event.ID != "igpsport-7" || event.Event != igpsportsync.EVENT_ACTIVITY_CREATED || event.Row == nil || event.Row.Title != "Morning Ride" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+	if event.Analytics == nil || event.Analytics.AveragePower != 200 || event.Analytics.AverageHeartRate == 0 {
+		t.Errorf("Unexpected analytics: %+v", event.Analytics)
+	}
+
+	// a 400 is not retried and goes to the dead letter file
+	if rejecting.requests != 1 || len(reported) != 1 || reported[0] != 7 {
+		t.Errorf("Expected one rejected request reported for ride 7, got %d requests, %v", rejecting.requests, reported)
+	}
+	data, _ := os.ReadFile(deadLetters)
+	var letter igpsportsync.DeadLetter
+	if err := json.Unmarshal(data, &letter); err != nil || letter.URL != rejecting.URL || letter.Attempts != 1 || !strings.Contains(letter.Error, "400") {
+		t.Fatalf("Unexpected dead letter: %s (%v)", data, err)
+	}
+
+	delivered, err := notifier.Redeliver(context.Background())
+	if err != nil || delivered != 1 {
+		t.Fatalf("Redeliver: %d, %v", delivered, err)
+	}
+	if events := rejecting.Events(); len(events) != 1 || events[0].RideID != 7 {
+		t.Errorf("Unexpected redelivered events: %+v", events)
+	}
+	if data, _ := os.ReadFile(deadLetters); len(data) != 0 {
+		t.Errorf("Dead letter file not emptied: %s", data)
+	}
+}
+
+// TestNotifierSlowWebhook tests that a hanging webhook does not hold up the download
+func TestNotifierSlowWebhook(t *testing.T) {
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.ReadAll(req.Body) // lets the server notice the client giving up
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer hanging.Close()
+	deadLetters := filepath.Join(t.TempDir(), "failed.jsonl")
+	notifier := igpsportsync.NewNotifier(igpsportsync.NotifierConfig{
+		Webhooks:        []igpsportsync.Webhook{{URL: hanging.URL}},
+		Backoff:         time.Millisecond,
+		MaxDeliveryTime: 50 * time.Millisecond,
+		QueueSize:       1,
+		DeadLetterPath:  deadLetters,
+	})
+
+	var mu sync.Mutex
+	reported := 0
+	callback := notifier.Callback(context.Background(), func(rideID int, err error) {
+		mu.Lock()
+		reported++
+		mu.Unlock()
+	}, nil)
+	started := time.Now()
+	for id := 1; id <= 3; id++ {
+		callback(&igpsportsync.DownloadedActivity{RideID: id, Extension: igpsportsync.FIT, Data: []byte("no fit")})
+	}
+	if elapsed := time.Since(started); elapsed > 40*time.Millisecond {
+		t.Errorf("Callbacks blocked for %v", elapsed)
+	}
+	notifier.Close()
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Deliveries not bounded by MaxDeliveryTime: %v", elapsed)
+	}
+
+	data, _ := os.ReadFile(deadLetters)
+	if lines := strings.Count(string(data), "\n"); lines != 3 || !strings.Contains(string(data), "queue full") {
+		t.Errorf("Expected 3 dead letters, one for a full queue, got %s", data)
+	}
+	if reported != 3 {
+		t.Errorf("Expected 3 reported failures, got %d", reported)
+	}
+}
+
+// TestRedeliverConcurrentFailure tests that failures recorded during a
+// redelivery are kept when the dead letter file is rewritten
+func TestRedeliverConcurrentFailure(t *testing.T) {
+	deadLetters := filepath.Join(t.TempDir(), "failed.jsonl")
+	var notifier *igpsportsync.Notifier
+	var mu sync.Mutex
+	requests := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.ReadAll(req.Body)
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		if n == 1 {
+			// the redelivery succeeds after another event failed meanwhile
+			notifier.Notify(req.Context(), igpsportsync.ActivityEvent{ID: "igpsport-9", RideID: 9})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+	notifier = igpsportsync.NewNotifier(igpsportsync.NotifierConfig{
+		Webhooks:       []igpsportsync.Webhook{{URL: receiver.URL}},
+		MaxAttempts:    1,
+		DeadLetterPath: deadLetters,
+	})
+
+	letter, _ := json.Marshal(igpsportsync.DeadLetter{URL: receiver.URL, Event: json.RawMessage(`{"rideId":7}`), Attempts: 1})
+	os.WriteFile(deadLetters, append(letter, '\n'), 0o644)
+
+	delivered, err := notifier.Redeliver(context.Background())
+	if err != nil || delivered != 1 {
+		t.Fatalf("Redeliver: %d, %v", delivered, err)
+	}
+	data, _ := os.ReadFile(deadLetters)
+	var kept igpsportsync.DeadLetter
+	if err := json.Unmarshal(data, &kept); err != nil || !strings.Contains(string(kept.Event), `"rideId":9`) || strings.Count(string(data), "\n") != 1 {
+		t.Errorf("Expected only the failure of ride 9 kept, got %s", data)
+	}
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestPlanDownload tests planning a sync against a manifest state and
+// running it with the same options
+func TestPlanDownload(t *testing.T) {
+	activities := FakeActivities(8, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)) // rides 8..1
+	activities[3].Row.RideDistance = 100                                         // ride 5 is filtered
+	activities[6].Row.StartTime = activities[5].Row.StartTime                    // ride 2 duplicates ride 3
+	server := NewFakeServer(t, activities)
+	client := server.Client(t, igpsportsync.Config{})
+
+	state := igpsportsync.NewManifestState([]igpsportsync.ManifestEntry{
+		{RideID: 7, Format: "fit", File: "7.fit", StartTime: "2024-05-07 08:00:00"},
+		{RideID: 6, Format: "fit", File: "6.fit", StartTime: "2024-05-06 08:00:00"},
+		{RideID: 4, Format: "fit", Error: "download failed", StartTime: "2024-05-04 08:00:00"},
+		{RideID: 50, Format: "fit", File: "50.fit", Title: "Deleted ride", StartTime: "2024-05-03 09:00:00"},
+		{RideID: 60, Format: "gpx", File: "60.gpx", StartTime: "2023-01-01 09:00:00"},
+	})
+	options := func() igpsportsync.DownloadOptions {
+		return igpsportsync.DownloadOptions{
+			Extension: igpsportsync.FIT,
+			BeginTime: "2024-04-01",
+			State:     state,
+			Filter: &igpsportsync.ActivityFilter{
+				MinDistance:  1000,
+				Deduplicator: igpsportsync.NewDeduplicator(igpsportsync.DedupOptions{Location: time.UTC}),
+			},
+		}
+	}
+
+	plan, err := client.PlanDownload(context.Background(), options())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	want := map[igpsportsync.PlanAction][]int{
+		igpsportsync.PLAN_DOWNLOAD:  {8, 4, 3, 1},
+		igpsportsync.PLAN_SYNCED:    {7, 6},
+		igpsportsync.PLAN_FILTERED:  {5},
+		igpsportsync.PLAN_DUPLICATE: {2},
+		igpsportsync.PLAN_DELETE:    {50},
+	}
+	for action, ids := range want {
+		if got := plan.RideIDs(action); fmt.Sprint(got) != fmt.Sprint(ids) || plan.Counts[action] != len(ids) {
+			t.Errorf("%s: got %v, want %v", action, got, ids)
+		}
+	}
+	for _, item := range plan.Items {
+		if item.Action == igpsportsync.PLAN_DUPLICATE && item.DuplicateOf != 3 {
+			t.Errorf("Expected ride 2 to duplicate ride 3, got %d", item.DuplicateOf)
+		}
+	}
+	if plan.Requests != 1+4*2 {
+		t.Errorf("Expected 9 requests, got %d", plan.Requests)
+	}
+	for _, endpoint := range []string{"detail", "downloadUrl", "file"} {
+		if n := server.Requests(endpoint); n != 0 {
+			t.Errorf("Plan made %d %s requests", n, endpoint)
+		}
+	}
+
+	// the run downloads what was planned
+	var got []int
+	run := options()
+	run.OrderedDelivery = true
+	run.Callback = func(activity *igpsportsync.DownloadedActivity) bool {
+		got = append(got, activity.RideID)
+		return true
+	}
+	summary, err := client.DownloadAllActivitiesContext(context.Background(), run)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want[igpsportsync.PLAN_DOWNLOAD]) || summary.Skipped != 4 {
+		t.Errorf("Downloaded %v, summary %+v", got, summary)
+	}
+	if n := server.Requests("file"); n != 4 {
+		t.Errorf("Expected 4 file requests, got %d", n)
+	}
+}
+
+// TestPlanDownloadListingFailure tests that a failed listing plans nothing
+func TestPlanDownloadListingFailure(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(2, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	client := server.Client(t, igpsportsync.Config{})
+	client.LoginResult.Access_token = "expired"
+
+	state := igpsportsync.NewManifestState([]igpsportsync.ManifestEntry{
+		{RideID: 1, Format: "fit", File: "1.fit", StartTime: "2024-05-01 08:00:00"},
+	})
+	plan, err := client.PlanDownload(context.Background(), igpsportsync.DownloadOptions{State: state})
+	if err == nil || plan != nil {
+		t.Errorf("Expected listing error, got %+v (%v)", plan, err)
+	}
+}
@@ -0,0 +1,68 @@
+package test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestAnalyzePower tests power metrics on a steady ride
+func TestAnalyzePower(t *testing.T) {
+	track, err := igpsportsync.DecodeTrack(BuildFITFile(SampleRide(time.Now())), igpsportsync.FIT)
+	if err != nil {
+		t.Fatalf("Failed to decode FIT: %v", err)
+	}
+
+	summary, err := igpsportsync.AnalyzePower(track, 250)
+	if err != nil {
+		t.Fatalf("Failed to analyze power: %v", err)
+	}
+	if summary.Duration != 10*time.Minute || summary.AveragePower != 200 || math.Abs(summary.NormalizedPower-200) > 1e-6 {
+		t.Errorf("Unexpected duration %v, average %.1f or NP %.1f", summary.Duration, summary.AveragePower, summary.NormalizedPower)
+	}
+	if math.Abs(summary.IntensityFactor-0.8) > 1e-6 || math.Abs(summary.VariabilityIndex-1) > 1e-6 {
+		t.Errorf("Unexpected IF %.3f or VI %.3f", summary.IntensityFactor, summary.VariabilityIndex)
+	}
+	if want := 600 * 200 * 0.8 / (250 * 3600) * 100; math.Abs(summary.TSS-want) > 1e-6 {
+		t.Errorf("TSS %.3f, want %.3f", summary.TSS, want)
+	}
+	// a 10 minute ride covers the 5s, 1min and 5min points
+	if len(summary.PowerCurve) != 3 || summary.PowerCurve[2].Watts != 200 {
+		t.Errorf("Unexpected power curve: %+v", summary.PowerCurve)
+	}
+	if summary.TimeInZones[2].Zone.Number != 3 || summary.TimeInZones[2].Duration != 10*time.Minute {
+		t.Errorf("Unexpected zones: %+v", summary.TimeInZones)
+	}
+}
+
+// TestNormalizedPowerIntervals tests that variable efforts raise NP above average power
+func TestNormalizedPowerIntervals(t *testing.T) {
+	points := SampleRide(time.Now())
+	for i := range points {
+		if (i/60)%2 == 0 {
+			points[i].Power = 350
+		} else {
+			points[i].Power = 50
+		}
+	}
+	track, err := igpsportsync.DecodeTrack(BuildFITFile(points), igpsportsync.FIT)
+	if err != nil {
+		t.Fatalf("Failed to decode FIT: %v", err)
+	}
+
+	summary, err := igpsportsync.AnalyzePower(track, 0)
+	if err != nil {
+		t.Fatalf("Failed to analyze power: %v", err)
+	}
+	if summary.NormalizedPower <= summary.AveragePower || summary.VariabilityIndex <= 1.2 {
+		t.Errorf("Expected NP above average, got NP %.1f avg %.1f", summary.NormalizedPower, summary.AveragePower)
+	}
+	if summary.PowerCurve[1].Watts != 350 || summary.MaxPower != 350 {
+		t.Errorf("Unexpected best minute %.1f", summary.PowerCurve[1].Watts)
+	}
+	if summary.TSS != 0 || summary.TimeInZones != nil {
+		t.Errorf("FTP dependent values must be empty without FTP")
+	}
+}
@@ -0,0 +1,179 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestSanitizeFIT tests blanking FIT positions near home and recomputing distance
+func TestSanitizeFIT(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	original := BuildFITFile(SampleRide(start))
+	backup := append([]byte(nil), original...)
+
+	home := igpsportsync.PrivacyZone{Lat: 31.2, Lng: 121.5, Radius: 202}
+	sanitized, err := igpsportsync.SanitizeActivity(original, igpsportsync.FIT, igpsportsync.PrivacyOptions{
+		Zones:   []igpsportsync.PrivacyZone{home},
+		TrimEnd: 502,
+	})
+	if err != nil {
+		t.Fatalf("Failed to sanitize: %v", err)
+	}
+	if !bytes.Equal(original, backup) {
+		t.Fatalf("Original data was modified")
+	}
+
+	var crc uint16
+	for _, b := range sanitized {
+		crc = fitCRC(crc, b)
+	}
+	if crc != 0 {
+		t.Errorf("Sanitized file has an invalid CRC")
+	}
+
+	track, err := igpsportsync.DecodeTrack(sanitized, igpsportsync.FIT)
+	if err != nil {
+		t.Fatalf("Failed to decode sanitized FIT: %v", err)
+	}
+	if len(track.Points) != 601 {
+		t.Fatalf("Records must be kept, got %d", len(track.Points))
+	}
+	positions := track.Positions()
+	if len(positions) != 601-41-101 {
+		t.Errorf("Unexpected number of positions: %d", len(positions))
+	}
+	for _, p := range positions {
+		if home.Contains(p) {
+			t.Fatalf("Position %v inside privacy zone", p)
+		}
+	}
+	if track.Points[0].Distance != 0 || track.Points[41].Distance != 0 {
+		t.Errorf("Distance should start at the first kept point")
+	}
+	if d := track.Distance(); d < 2290 || d > 2300 {
+		t.Errorf("Unexpected recomputed distance %.1f", d)
+	}
+}
+
+// TestSanitizeFITLaps tests recomputing FIT lap distances after trimming
+func TestSanitizeFITLaps(t *testing.T) {
+	original := BuildFITFileWithLaps(SampleRide(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)), 3)
+	if laps := FITLapDistances(original); len(laps) != 3 || laps[0] != 1000 || laps[1] != 1005 || laps[2] != 995 {
+		t.Fatalf("Unexpected original laps: %v", laps)
+	}
+
+	// the trims shorten the first and last lap only
+	sanitized, err := igpsportsync.SanitizeActivity(original, igpsportsync.FIT, igpsportsync.PrivacyOptions{TrimStart: 200, TrimEnd: 500})
+	if err != nil {
+		t.Fatalf("Failed to sanitize: %v", err)
+	}
+	laps := FITLapDistances(sanitized)
+	if len(laps) != 3 {
+		t.Fatalf("Expected 3 laps, got %v", laps)
+	}
+	track, err := igpsportsync.DecodeTrack(sanitized, igpsportsync.FIT)
+	if err != nil {
+		t.Fatalf("Failed to decode sanitized FIT: %v", err)
+	}
+	if sum := laps[0] + laps[1] + laps[2]; sum < track.Distance()-0.05 || sum > track.Distance()+0.05 {
+		t.Errorf("Lap distances %v do not add up to %.2f", laps, track.Distance())
+	}
+	if laps[1] < 1004.9 || laps[1] > 1005.1 || laps[0] >= 1000 || laps[2] >= 995 {
+		t.Errorf("Unexpected lap distances %v", laps)
+	}
+}
+
+func buildTCX(n int) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+  <Activities>
+    <Activity Sport="Biking">
+      <Lap StartTime="2024-05-01T00:00:00Z">
+        <DistanceMeters>9999</DistanceMeters>
+        <Track>
+`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `          <Trackpoint>
+            <Time>2024-05-01T00:%02d:%02dZ</Time>
+            <Position>
+              <LatitudeDegrees>%.7f</LatitudeDegrees>
+              <LongitudeDegrees>121.5</LongitudeDegrees>
+            </Position>
+            <DistanceMeters>%d</DistanceMeters>
+          </Trackpoint>
+`, i/60, i%60, 31.2+float64(i)*100/111195, i*100)
+	}
+	sb.WriteString(`        </Track>
+      </Lap>
+    </Activity>
+  </Activities>
+</TrainingCenterDatabase>
+`)
+	return sb.String()
+}
+
+// TestSanitizeTCX tests removing TCX trackpoints and rewriting distances
+func TestSanitizeTCX(t *testing.T) {
+	original := []byte(buildTCX(20))
+	sanitized, err := igpsportsync.SanitizeActivity(original, igpsportsync.TCX, igpsportsync.PrivacyOptions{TrimStart: 250, TrimEnd: 150})
+	if err != nil {
+		t.Fatalf("Failed to sanitize: %v", err)
+	}
+
+	track, err := igpsportsync.DecodeTrack(sanitized, igpsportsync.TCX)
+	if err != nil {
+		t.Fatalf("Failed to decode sanitized TCX: %v", err)
+	}
+	// 100 m spacing: start trim drops 3 points, end trim drops 2
+	if len(track.Points) != 15 {
+		t.Fatalf("Expected 15 points, got %d", len(track.Points))
+	}
+	if track.Points[0].Distance != 0 || track.Sport != "cycling" {
+		t.Errorf("Unexpected first point: %+v", track.Points[0])
+	}
+	if d := track.Distance(); d < 1399 || d > 1401 {
+		t.Errorf("Unexpected distance %.1f", d)
+	}
+	if !bytes.Contains(sanitized, []byte("<DistanceMeters>1400.00</DistanceMeters>\n        <Track>")) {
+		t.Errorf("Lap distance not rewritten")
+	}
+	if bytes.Contains(sanitized, []byte("\n\n")) {
+		t.Errorf("Removed trackpoints left blank lines")
+	}
+}
+
+// TestSanitizeGPX tests removing GPX points inside a privacy zone
+func TestSanitizeGPX(t *testing.T) {
+	gpx := `<?xml version="1.0"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><type>cycling</type><trkseg>
+    <trkpt lat="31.2000000" lon="121.5"><ele>10</ele><time>2024-05-01T00:00:00Z</time></trkpt>
+    <trkpt lat="31.2100000" lon="121.5"><ele>10</ele><time>2024-05-01T00:01:00Z</time></trkpt>
+    <trkpt lat="31.2200000" lon="121.5"><ele>10</ele><time>2024-05-01T00:02:00Z</time></trkpt>
+    <trkpt lat="31.2300000" lon="121.5"><ele>10</ele><time>2024-05-01T00:03:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>
+`
+	work := igpsportsync.PrivacyZone{Lat: 31.22, Lng: 121.5, Radius: 100}
+	sanitized, err := igpsportsync.SanitizeActivity([]byte(gpx), igpsportsync.GPX, igpsportsync.PrivacyOptions{Zones: []igpsportsync.PrivacyZone{work}})
+	if err != nil {
+		t.Fatalf("Failed to sanitize: %v", err)
+	}
+	if bytes.Contains(sanitized, []byte("31.2200000")) {
+		t.Errorf("Point inside zone was kept")
+	}
+
+	track, err := igpsportsync.DecodeTrack(sanitized, igpsportsync.GPX)
+	if err != nil {
+		t.Fatalf("Failed to decode sanitized GPX: %v", err)
+	}
+	if len(track.Points) != 3 {
+		t.Errorf("Expected 3 points, got %d", len(track.Points))
+	}
+}
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestReconcile tests mirroring remote deletions and edits into a download directory
+func TestReconcile(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(5, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	client := server.Client(t, igpsportsync.Config{})
+	dir := t.TempDir()
+	catalog := openCatalog(t, dir)
+	sink, err := igpsportsync.NewDiskSink(filepath.Join(dir, "activities"), true)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	defer sink.Close()
+	sink.Catalog = catalog
+
+	if _, err := client.DownloadAllActivitiesContext(context.Background(), igpsportsync.DownloadOptions{Sink: sink}); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	server.Delete(2)
+	server.Retitle(4, "Renamed ride")
+
+	// reporting changes nothing
+	changes, err := client.Reconcile(context.Background(), sink, igpsportsync.ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %+v", changes)
+	}
+	for _, change := range changes {
+		if change.Action != igpsportsync.RECONCILE_REPORT {
+			t.Errorf("Unexpected action %s", change.Action)
+		}
+		switch change.RideID {
+		case 2:
+			if change.Kind != igpsportsync.CHANGE_DELETED {
+				t.Errorf("Expected ride 2 deleted, got %s", change.Kind)
+			}
+		case 4:
+			if change.Kind != igpsportsync.CHANGE_EDITED || change.Row.Title != "Renamed ride" || change.Entries[0].Title != "Ride 4" {
+				t.Errorf("Unexpected change %+v", change)
+			}
+		default:
+			t.Errorf("Unexpected change of ride %d", change.RideID)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(sink.Dir, "2.fit")); err != nil {
+		t.Errorf("Report removed a file: %v", err)
+	}
+
+	trash := filepath.Join(dir, "trash")
+	changes, err = client.Reconcile(context.Background(), sink, igpsportsync.ReconcileOptions{
+		Deleted:  igpsportsync.RECONCILE_TRASH,
+		TrashDir: trash,
+		Edited:   igpsportsync.RECONCILE_RENAME,
+	})
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("Reconcile failed: %v (%d changes)", err, len(changes))
+	}
+	if _, err := os.Stat(filepath.Join(trash, "2.fit")); err != nil {
+		t.Errorf("File not moved to trash: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sink.Dir, "2.fit")); !os.IsNotExist(err) {
+		t.Errorf("File still in place: %v", err)
+	}
+	if entry, _ := catalog.Get(2); entry != nil {
+		t.Errorf("Ride 2 still in catalog")
+	}
+	if entry, _ := catalog.Get(4); entry == nil || entry.Row.Title != "Renamed ride" {
+		t.Errorf("Ride 4 not renamed in catalog: %+v", entry)
+	}
+
+	state, err := igpsportsync.LoadManifestState(filepath.Join(sink.Dir, igpsportsync.MANIFEST_FILE))
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if state.Synced(2, igpsportsync.FIT) || !state.Synced(4, igpsportsync.FIT) {
+		t.Errorf("Unexpected state after reconcile")
+	}
+	report, err := igpsportsync.VerifyManifest(context.Background(), filepath.Join(sink.Dir, igpsportsync.MANIFEST_FILE), nil)
+	if err != nil || report.OK != 4 || len(report.Problems()) != 0 {
+		t.Errorf("Unexpected verify report: %+v (%v)", report, err)
+	}
+
+	// everything is in sync now
+	changes, err = client.Reconcile(context.Background(), sink, igpsportsync.ReconcileOptions{Deleted: igpsportsync.RECONCILE_DELETE})
+	if err != nil || len(changes) != 0 {
+		t.Errorf("Expected no changes, got %+v (%v)", changes, err)
+	}
+}
+
+// TestDiffActivitiesWindow tests that rides outside the listed dates are not deleted
+func TestDiffActivitiesWindow(t *testing.T) {
+	entries := []igpsportsync.ManifestEntry{
+		{RideID: 1, Format: "fit", StartTime: "2023-12-31 10:00:00"},
+		{RideID: 2, Format: "fit", StartTime: "2024-01-01 10:00:00"},
+		{RideID: 2, Format: "gpx", StartTime: "2024-01-01 10:00:00"},
+		{RideID: 3, Format: "fit", StartTime: "2024-02-01 10:00:00"},
+	}
+	changes := igpsportsync.DiffActivities(nil, entries, "2024-01-01", "2024-01-31")
+	if len(changes) != 1 || changes[0].RideID != 2 || len(changes[0].Entries) != 2 {
+		t.Errorf("Unexpected changes: %+v", changes)
+	}
+}
+
+// TestDiffActivitiesTimeLayout tests that start times are compared by value, not layout
+func TestDiffActivitiesTimeLayout(t *testing.T) {
+	rows := []igpsportsync.ActivityRow{
+		{RideID: 1, Title: "Ride", StartTime: "2024-05-01 08:00:00"},
+		{RideID: 2, Title: "Ride", StartTime: "2024-05-02 08:00:00"},
+	}
+	entries := []igpsportsync.ManifestEntry{
+		{RideID: 1, Format: "fit", Title: "Ride", StartTime: "2024-05-01T08:00:00"},
+		{RideID: 2, Format: "fit", Title: "Ride", StartTime: "2024-05-02T09:00:00"},
+	}
+	changes := igpsportsync.DiffActivities(rows, entries, "", "")
+	if len(changes) != 1 || changes[0].RideID != 2 || changes[0].Kind != igpsportsync.CHANGE_EDITED {
+		t.Errorf("Expected only ride 2 edited, got %+v", changes)
+	}
+}
+
+// TestReconcileListingFailure tests that a failed or incomplete listing removes nothing
+func TestReconcileListingFailure(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(5, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	client := server.Client(t, igpsportsync.Config{})
+	sink, err := igpsportsync.NewDiskSink(t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	defer sink.Close()
+	if _, err := client.DownloadAllActivitiesContext(context.Background(), igpsportsync.DownloadOptions{Sink: sink}); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	options := igpsportsync.ReconcileOptions{Deleted: igpsportsync.RECONCILE_DELETE}
+
+	// an expired token fails the listing
+	unauthorized := server.Client(t, igpsportsync.Config{})
+	unauthorized.LoginResult.Access_token = "expired"
+	changes, err := unauthorized.Reconcile(context.Background(), sink, options)
+	if err == nil || len(changes) != 0 {
+		t.Errorf("Expected listing error, got %+v (%v)", changes, err)
+	}
+
+	// most rides missing from the listing are not removed without Force
+	for id := 1; id <= 3; id++ {
+		server.Delete(id)
+	}
+	changes, err = client.Reconcile(context.Background(), sink, options)
+	if err == nil || len(changes) != 3 {
+		t.Fatalf("Expected refusal, got %+v (%v)", changes, err)
+	}
+	for id := 1; id <= 5; id++ {
+		if _, err := os.Stat(filepath.Join(sink.Dir, strconv.Itoa(id)+".fit")); err != nil {
+			t.Errorf("File of ride %d removed: %v", id, err)
+		}
+	}
+
+	options.Force = true
+	changes, err = client.Reconcile(context.Background(), sink, options)
+	if err != nil || len(changes) != 3 || changes[0].Action != igpsportsync.RECONCILE_DELETE {
+		t.Errorf("Forced reconcile failed: %+v (%v)", changes, err)
+	}
+	if _, err := os.Stat(filepath.Join(sink.Dir, "1.fit")); !os.IsNotExist(err) {
+		t.Errorf("File still in place: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestWriteReport tests the index with totals and heatmaps and the activity pages
+func TestWriteReport(t *testing.T) {
+	ctx := context.Background()
+	storage := igpsportsync.NewMemoryStorage()
+	starts := map[int]time.Time{
+		1: time.Date(2023, 8, 20, 8, 0, 0, 0, time.UTC),
+		2: time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC),
+		3: time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC),
+	}
+	var entries []igpsportsync.ManifestEntry
+	for id := 1; id <= 3; id++ {
+		storage.Put(ctx, id, igpsportsync.FIT, BuildFITFile(SampleRide(starts[id])))
+		entries = append(entries, igpsportsync.ManifestEntry{RideID: id, Title: "Ride <" + string(rune('A'+id-1)) + ">", Format: "fit"})
+	}
+	storage.Put(ctx, 4, igpsportsync.GPX, []byte("not xml"))
+	entries = append(entries, igpsportsync.ManifestEntry{RideID: 4, Format: "gpx"})
+	// a manual entry has no samples
+	storage.Put(ctx, 5, igpsportsync.GPX, []byte(`<gpx version="1.1"><trk><trkseg></trkseg></trk></gpx>`))
+	entries = append(entries, igpsportsync.ManifestEntry{RideID: 5, Title: "Manual", StartTime: "2024-05-01 12:00:00", Format: "gpx"})
+	rows := []igpsportsync.ActivityRow{{RideID: 5, StartTime: "2024-05-01 12:00:00", RideDistance: 4000}}
+
+	dir := t.TempDir()
+	activities, err := igpsportsync.WriteReport(ctx, storage, entries, dir, igpsportsync.ReportOptions{Location: time.UTC, Rows: rows})
+	if err == nil || !strings.Contains(err.Error(), "ride 4") || strings.Contains(err.Error(), "ride 5") {
+		t.Errorf("Expected an error for ride 4 only, got %v", err)
+	}
+	if len(activities) != 4 || activities[0].RideID != 3 || activities[1].RideID != 5 || activities[3].RideID != 1 {
+		t.Fatalf("Unexpected activities: %+v", activities)
+	}
+	if a := activities[0]; a.Distance != 3000 || a.MovingTime != 10*time.Minute || a.Page != "activities/3.html" {
+		t.Errorf("Unexpected activity: %+v", a)
+	}
+	if a := activities[1]; a.Distance != 4000 || !a.StartTime.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected manual activity: %+v", a)
+	}
+
+	index, err := os.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil {
+		t.Fatalf("Missing index: %v", err)
+	}
+	html := string(index)
+	for _, want := range []string{
+		"<td>2024</td><td>3</td><td>10.0</td>",
+		"<td>2023</td><td>1</td><td>3.0</td>",
+		"<title>2024-05-01: 3 rides, 10.0 km</title>",
+		`href="activities/2.html">Ride &lt;B&gt;</a>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Index lacks %q", want)
+		}
+	}
+	if n := strings.Count(html, "<rect "); n != 366+365 {
+		t.Errorf("Heatmaps have %d days, want %d", n, 366+365)
+	}
+
+	page, err := os.ReadFile(filepath.Join(dir, "activities", "2.html"))
+	if err != nil {
+		t.Fatalf("Missing activity page: %v", err)
+	}
+	if !strings.Contains(string(page), `<polyline class="route"`) || strings.Count(string(page), `<polyline class="line"`) != 2 {
+		t.Errorf("Activity page lacks the map or the charts")
+	}
+	m := regexp.MustCompile(`(?s)<script type="application/json" id="route-geojson">(.*?)</script>`).FindSubmatch(page)
+	if m == nil {
+		t.Fatalf("Activity page lacks the GeoJSON")
+	}
+	var feature igpsportsync.GeoJSONFeature
+	if err := json.Unmarshal(m[1], &feature); err != nil {
+		t.Fatalf("Invalid GeoJSON: %v", err)
+	}
+	if feature.Geometry.Type != "LineString" || len(feature.Geometry.Coordinates) < 2 || feature.Properties["title"] != "Ride <B>" {
+		t.Errorf("Unexpected GeoJSON: %s", m[1][:100])
+	}
+
+	manual, err := os.ReadFile(filepath.Join(dir, "activities", "5.html"))
+	if err != nil {
+		t.Fatalf("Missing manual activity page: %v", err)
+	}
+	if !strings.Contains(string(manual), "<td>4.0 km</td>") || strings.Contains(string(manual), "<svg") || strings.Contains(string(manual), "route-geojson") {
+		t.Errorf("Manual activity page must show the totals without map or charts")
+	}
+}
@@ -0,0 +1,145 @@
+package test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+	_ "modernc.org/sqlite"
+)
+
+// TestDecodeFITTrack tests decoding a FIT file into a track
+func TestDecodeFITTrack(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	track, err := igpsportsync.DecodeTrack(BuildFITFile(SampleRide(start)), igpsportsync.FIT)
+	if err != nil {
+		t.Fatalf("Failed to decode FIT: %v", err)
+	}
+
+	if track.Sport != "cycling" || len(track.Points) != 601 {
+		t.Fatalf("Unexpected track: sport=%q points=%d", track.Sport, len(track.Points))
+	}
+	if !track.StartTime().Equal(start) || track.ElapsedTime() != 10*time.Minute {
+		t.Errorf("Unexpected times: start=%v elapsed=%v", track.StartTime(), track.ElapsedTime())
+	}
+	if track.Distance() != 3000 || track.MovingTime() != 10*time.Minute {
+		t.Errorf("Unexpected distance %v or moving time %v", track.Distance(), track.MovingTime())
+	}
+	p := track.Points[0]
+	if math.Abs(p.Lat-31.2) > 1e-6 || math.Abs(p.Lon-121.5) > 1e-6 || p.HeartRate != 140 || !p.HasPower || p.Power != 200 {
+		t.Errorf("Unexpected first point: %+v", p)
+	}
+}
+
+// TestConvertActivity tests encoding GPX and TCX files from a FIT file
+func TestConvertActivity(t *testing.T) {
+	fit := BuildFITFile(SampleRide(time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	for _, ext := range []igpsportsync.Extension{igpsportsync.GPX, igpsportsync.TCX} {
+		data, err := igpsportsync.ConvertActivity(fit, ext)
+		if err != nil {
+			t.Fatalf("%s: failed to convert: %v", ext.Name(), err)
+		}
+		if detected, _ := igpsportsync.DetectExtension(data); detected != ext {
+			t.Errorf("%s: detected as %s", ext.Name(), detected.Name())
+		}
+		track, err := igpsportsync.DecodeTrack(data, ext)
+		if err != nil {
+			t.Fatalf("%s: failed to decode: %v", ext.Name(), err)
+		}
+		p := track.Points[300]
+		if len(track.Points) != 601 || track.Sport != "cycling" || p.HeartRate != 140 || p.Power != 200 || !p.HasPosition {
+			t.Errorf("%s: unexpected track: %d points, %s, %+v", ext.Name(), len(track.Points), track.Sport, p)
+		}
+		if d := track.Distance(); d < 2990 || d > 3010 {
+			t.Errorf("%s: unexpected distance %.1f", ext.Name(), d)
+		}
+	}
+	if _, err := igpsportsync.ConvertActivity([]byte("<gpx/>"), igpsportsync.FIT); err == nil {
+		t.Errorf("Expected an error converting to FIT")
+	}
+}
+
+// TestPolyline tests encoding and decoding Google polylines
+func TestPolyline(t *testing.T) {
+	points := []igpsportsync.LatLng{{Lat: 38.5, Lng: -120.2}, {Lat: 40.7, Lng: -120.95}, {Lat: 43.252, Lng: -126.453}}
+	encoded := igpsportsync.EncodePolyline(points)
+	if encoded != "_p~iF~ps|U_ulLnnqC_mqNvxq`@" {
+		t.Errorf("Unexpected polyline: %s", encoded)
+	}
+
+	decoded, err := igpsportsync.DecodePolyline(encoded)
+	if err != nil {
+		t.Fatalf("Failed to decode polyline: %v", err)
+	}
+	if len(decoded) != len(points) || decoded[2] != points[2] {
+		t.Errorf("Unexpected decoded points: %v", decoded)
+	}
+}
+
+// TestRunningPageExport tests writing the running_page database and activities.json
+func TestRunningPageExport(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite", filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	exporter, err := igpsportsync.NewRunningPageExporter(db)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+
+	loc := time.FixedZone("CST", 8*3600)
+	for i, day := range []int{1, 2, 4} {
+		start := time.Date(2024, 5, day, 0, 0, 0, 0, time.UTC)
+		track, err := igpsportsync.DecodeTrack(BuildFITFile(SampleRide(start)), igpsportsync.FIT)
+		if err != nil {
+			t.Fatalf("Failed to decode FIT: %v", err)
+		}
+		activity := igpsportsync.NewRunningPageActivity(100+i, "Ride", track, loc)
+		if err := exporter.Upsert(activity); err != nil {
+			t.Fatalf("Failed to upsert: %v", err)
+		}
+		// upserting twice must not duplicate rows
+		if err := exporter.Upsert(activity); err != nil {
+			t.Fatalf("Failed to upsert: %v", err)
+		}
+	}
+
+	activities, err := exporter.Activities()
+	if err != nil {
+		t.Fatalf("Failed to read activities: %v", err)
+	}
+	if len(activities) != 3 {
+		t.Fatalf("Expected 3 activities, got %d", len(activities))
+	}
+	a := activities[0]
+	if a.StartDateLocal != "2024-05-01 08:00:00" || a.StartDate != "2024-05-01 00:00:00" || a.MovingTime != 10*time.Minute {
+		t.Errorf("Unexpected activity: start=%s local=%s moving=%v", a.StartDate, a.StartDateLocal, a.MovingTime)
+	}
+
+	jsonPath := filepath.Join(dir, "activities.json")
+	if err := exporter.WriteJSON(jsonPath); err != nil {
+		t.Fatalf("Failed to write json: %v", err)
+	}
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to read json: %v", err)
+	}
+	var out []map[string]any
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Failed to parse json: %v", err)
+	}
+	if len(out) != 3 || out[0]["moving_time"] != "0:10:00" || out[0]["type"] != "Ride" || out[0]["summary_polyline"] == "" {
+		t.Errorf("Unexpected json: %.300s", data)
+	}
+	if out[1]["streak"] != float64(2) || out[2]["streak"] != float64(1) {
+		t.Errorf("Unexpected streaks: %v %v", out[1]["streak"], out[2]["streak"])
+	}
+}
@@ -0,0 +1,173 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+func newTestServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+	catalog := openCatalog(t, t.TempDir())
+	rows := []igpsportsync.ActivityRow{
+		{RideID: 1, Title: "Morning Ride", RideDistance: 20000, StartTime: "2024-05-01 07:00:00", ProductName: "iGS630"},
+		{RideID: 2, Title: "Century", RideDistance: 160000, StartTime: "2024-05-04 06:00:00", ProductName: "iGS630"},
+		{RideID: 3, Title: "Evening commute", RideDistance: 12000, StartTime: "2024-06-10 18:00:00", ProductName: "BSC300"},
+	}
+	for _, row := range rows {
+		if err := catalog.PutRow(row); err != nil {
+			t.Fatalf("Failed to store row: %v", err)
+		}
+	}
+	storage := igpsportsync.NewMemoryStorage()
+	fit := BuildFITFile(SampleRide(time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)))
+	storage.Put(context.Background(), 1, igpsportsync.FIT, fit)
+	catalog.PutFile(igpsportsync.ManifestEntry{RideID: 1, Format: "fit", Size: int64(len(fit))}, "1.fit")
+
+	server := httptest.NewServer(igpsportsync.NewServer(igpsportsync.ServerConfig{
+		Catalog: catalog,
+		Storage: storage,
+		Profile: &igpsportsync.UserInfoResult{MemberId: 42, NickName: "rider"},
+		Token:   token,
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func getAPI(t *testing.T, url, token string, header http.Header, v any) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer res.Body.Close()
+	if v != nil && res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatalf("Failed to decode %s: %v", url, err)
+		}
+	}
+	return res
+}
+
+// TestServerActivities tests listing with pagination, filters and ETags
+func TestServerActivities(t *testing.T) {
+	server := newTestServer(t, "")
+
+	var list igpsportsync.APIActivityList
+	res := getAPI(t, server.URL+"/activities?pageSize=2", "", nil, &list)
+	if list.TotalRows != 3 || list.TotalPage != 2 || len(list.Rows) != 2 || list.Rows[0].RideID != 3 {
+		t.Errorf("Unexpected first page: %+v", list)
+	}
+	getAPI(t, server.URL+"/activities?pageSize=2&page=2", "", nil, &list)
+	if len(list.Rows) != 1 || list.Rows[0].RideID != 1 || len(list.Rows[0].Files) != 1 || list.Rows[0].Files[0].URL != "/activities/1/file.fit" {
+		t.Errorf("Unexpected second page: %+v", list)
+	}
+
+	etag := res.Header.Get("ETag")
+	res = getAPI(t, server.URL+"/activities?pageSize=2", "", http.Header{"If-None-Match": {etag}}, nil)
+	if etag == "" || res.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected 304 for ETag %q, got %d", etag, res.StatusCode)
+	}
+
+	filters := map[string][]int{
+		"minDistance=20000":             {2, 1},
+		"maxDistance=20000":             {3, 1},
+		"text=RIDE":                     {1},
+		"device=igs":                    {2, 1},
+		"from=2024-05-04&to=2024-06-10": {3, 2},
+	}
+	for query, want := range filters {
+		getAPI(t, server.URL+"/activities?"+query, "", nil, &list)
+		var got []int
+		for _, row := range list.Rows {
+			got = append(got, row.RideID)
+		}
+		if len(got) != len(want) || (len(got) > 0 && (got[0] != want[0] || got[len(got)-1] != want[len(want)-1])) {
+			t.Errorf("%s: got %v, want %v", query, got, want)
+		}
+	}
+	for _, page := range []string{"2", "4611686018427387904"} {
+		var past igpsportsync.APIActivityList
+		res := getAPI(t, server.URL+"/activities?pageSize=4&page="+page, "", nil, &past)
+		if res.StatusCode != http.StatusOK || len(past.Rows) != 0 || past.TotalRows != 3 {
+			t.Errorf("Expected an empty page %s, got %d %+v", page, res.StatusCode, past)
+		}
+	}
+	if res := getAPI(t, server.URL+"/activities?page=0", "", nil, nil); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for page 0, got %d", res.StatusCode)
+	}
+
+	var activity igpsportsync.APIActivity
+	getAPI(t, server.URL+"/activities/2", "", nil, &activity)
+	if activity.Title != "Century" {
+		t.Errorf("Unexpected activity: %+v", activity)
+	}
+	if res := getAPI(t, server.URL+"/activities/99", "", nil, nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", res.StatusCode)
+	}
+}
+
+// TestServerFilesProfileStats tests files, /me, /stats and bearer tokens
+func TestServerFilesProfileStats(t *testing.T) {
+	server := newTestServer(t, "secret")
+
+	if res := getAPI(t, server.URL+"/me", "", nil, nil); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", res.StatusCode)
+	}
+	if res := getAPI(t, server.URL+"/me", "wrong", nil, nil); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong token, got %d", res.StatusCode)
+	}
+	var profile igpsportsync.UserInfoResult
+	getAPI(t, server.URL+"/me", "secret", nil, &profile)
+	if profile.MemberId != 42 {
+		t.Errorf("Unexpected profile: %+v", profile)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/activities/1/file.fit", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	data, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/vnd.ant.fit" || len(data) == 0 {
+		t.Errorf("Unexpected file response: %d %s (%d bytes)", res.StatusCode, res.Header.Get("Content-Type"), len(data))
+	}
+	res = getAPI(t, server.URL+"/activities/1/file.fit", "secret", http.Header{"If-None-Match": {res.Header.Get("ETag")}}, nil)
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected 304 for the file, got %d", res.StatusCode)
+	}
+	for _, path := range []string{"/activities/1/file.gpx", "/activities/1/track.fit", "/activities/2/file.fit"} {
+		if res := getAPI(t, server.URL+path, "secret", nil, nil); res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, res.StatusCode)
+		}
+	}
+
+	var stats igpsportsync.APIStats
+	getAPI(t, server.URL+"/stats?period=month", "secret", nil, &stats)
+	if len(stats.Buckets) != 2 || stats.Buckets[0].Label != "2024-05" || stats.Buckets[0].Rides != 2 ||
+		stats.Buckets[0].Distance != 180000 || stats.Buckets[0].LongestRideID != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	getAPI(t, server.URL+"/stats?period=year&device=BSC", "secret", nil, &stats)
+	if len(stats.Buckets) != 1 || stats.Buckets[0].Rides != 1 {
+		t.Errorf("Unexpected filtered stats: %+v", stats)
+	}
+	if res := getAPI(t, server.URL+"/stats?period=day", "secret", nil, nil); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown period, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,98 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+func rideAt(t *testing.T, id int, start string, loc *time.Location, distance float64, moving time.Duration) igpsportsync.RideStats {
+	t.Helper()
+	ride, err := igpsportsync.RideStatsFromDetail(igpsportsync.ActivityDetailData{
+		RideId:       id,
+		StartTime:    start,
+		RideDistance: int(distance),
+		MovingTime:   int(moving / time.Second),
+		TotalAscent:  100,
+	}, loc)
+	if err != nil {
+		t.Fatalf("Failed to build ride: %v", err)
+	}
+	return ride
+}
+
+// TestComputeStatsTimeZone tests that rides are bucketed in the rider's time zone
+func TestComputeStatsTimeZone(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	rides := []igpsportsync.RideStats{
+		// 2024-04-30 20:00 UTC is already May 1st in Shanghai
+		rideAt(t, 1, "2024-04-30T20:00:00Z", shanghai, 40000, 2*time.Hour),
+		rideAt(t, 2, "2024-05-20 07:00:00", shanghai, 60000, 2*time.Hour),
+		rideAt(t, 3, "2024-07-02 07:00:00", shanghai, 20000, time.Hour),
+	}
+
+	months := igpsportsync.ComputeStats(rides, igpsportsync.PERIOD_MONTH, igpsportsync.StatsOptions{Location: shanghai})
+	if len(months) != 3 {
+		t.Fatalf("Expected May, June and July, got %d buckets", len(months))
+	}
+	may := months[0]
+	if may.Label != "2024-05" || may.Rides != 2 || may.Distance != 100000 || may.Ascent != 200 {
+		t.Errorf("Unexpected May totals: %+v", may)
+	}
+	if may.LongestRide.RideID != 2 || may.MovingTime != 4*time.Hour || may.AverageSpeed != 100000.0/(4*3600) {
+		t.Errorf("Unexpected May longest ride or speed: %+v", may)
+	}
+	if months[1].Label != "2024-06" || months[1].Rides != 0 {
+		t.Errorf("Expected an empty June bucket, got %+v", months[1])
+	}
+
+	// in UTC the first ride belongs to April
+	utc := igpsportsync.ComputeStats(rides, igpsportsync.PERIOD_MONTH, igpsportsync.StatsOptions{Location: time.UTC})
+	if utc[0].Label != "2024-04" {
+		t.Errorf("Expected the first UTC bucket in April, got %s", utc[0].Label)
+	}
+
+	weeks := igpsportsync.ComputeStats(rides[:2], igpsportsync.PERIOD_WEEK, igpsportsync.StatsOptions{Location: shanghai})
+	if weeks[0].Label != "2024-W18" || weeks[0].Start.Weekday() != time.Monday || len(weeks) != 4 {
+		t.Errorf("Unexpected weeks: %s starting %v, %d buckets", weeks[0].Label, weeks[0].Start, len(weeks))
+	}
+}
+
+// TestCompareYearsAndStreaks tests year over year totals and riding streaks
+func TestCompareYearsAndStreaks(t *testing.T) {
+	loc := time.UTC
+	rides := []igpsportsync.RideStats{
+		rideAt(t, 1, "2023-02-01 08:00:00", loc, 10000, time.Hour),
+		rideAt(t, 2, "2023-08-01 08:00:00", loc, 50000, time.Hour), // after the compared span
+		rideAt(t, 3, "2024-01-10 08:00:00", loc, 10000, time.Hour),
+		rideAt(t, 4, "2024-02-01 08:00:00", loc, 5000, time.Hour),
+		rideAt(t, 5, "2024-02-02 08:00:00", loc, 5000, time.Hour),
+		rideAt(t, 6, "2024-02-03 08:00:00", loc, 5000, time.Hour),
+		rideAt(t, 7, "2024-02-03 18:00:00", loc, 5000, time.Hour),
+		rideAt(t, 8, "2024-03-01 08:00:00", loc, 5000, time.Hour),
+		rideAt(t, 9, "2024-03-02 08:00:00", loc, 5000, time.Hour),
+	}
+	asOf := time.Date(2024, 3, 3, 12, 0, 0, 0, loc)
+	options := igpsportsync.StatsOptions{Location: loc}
+
+	yoy := igpsportsync.CompareYears(rides, asOf, options)
+	if yoy.Current.Rides != 7 || yoy.Previous.Rides != 1 {
+		t.Fatalf("Unexpected ride counts %d and %d", yoy.Current.Rides, yoy.Previous.Rides)
+	}
+	if yoy.DistanceChange != 300 {
+		t.Errorf("Distance change %.1f%%, want 300%%", yoy.DistanceChange)
+	}
+
+	streaks := igpsportsync.Streaks(rides, asOf, options)
+	if streaks.LongestDays.Length != 3 || streaks.LongestDays.Start.Day() != 1 || streaks.LongestDays.Start.Month() != time.February {
+		t.Errorf("Unexpected longest day streak: %+v", streaks.LongestDays)
+	}
+	// no ride yet on March 3rd, the streak ending yesterday still counts
+	if streaks.CurrentDays.Length != 2 {
+		t.Errorf("Unexpected current day streak: %+v", streaks.CurrentDays)
+	}
+	if streaks.CurrentWeeks.Length != 1 || streaks.LongestWeeks.Length != 1 {
+		t.Errorf("Unexpected week streaks: %+v / %+v", streaks.CurrentWeeks, streaks.LongestWeeks)
+	}
+}
@@ -0,0 +1,189 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestSignV4 checks the signer of FakeS3 against the GET Object example of
+// the AWS Signature Version 4 documentation
+func TestSignV4(t *testing.T) {
+	signature := SignV4("GET", "/test.txt", url.Values{}, map[string]string{
+		"host":                 "examplebucket.s3.amazonaws.com",
+		"range":                "bytes=0-9",
+		"x-amz-content-sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"x-amz-date":           "20130524T000000Z",
+	}, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"20130524T000000Z", "us-east-1", "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY")
+	if want := "f0e8bdb87c964420e857bd35b5d6ed310bd44f0170aba48dd91039c6036bdb41"; signature != want {
+		t.Errorf("Signature %s, want %s", signature, want)
+	}
+}
+
+func newTestS3Storage(t *testing.T, secret string) (*igpsportsync.S3Storage, *FakeS3) {
+	t.Helper()
+	fake := NewFakeS3(t, "rides")
+	fake.PageSize = 2
+	if secret == "" {
+		secret = fake.SecretKey
+	}
+	storage := igpsportsync.NewS3Storage(igpsportsync.S3Config{
+		Endpoint:  fake.URL,
+		Bucket:    "rides",
+		Prefix:    "igpsport/",
+		AccessKey: fake.AccessKey,
+		SecretKey: secret,
+	})
+	return storage, fake
+}
+
+// TestStorageBackends runs the same operations against every Storage
+func TestStorageBackends(t *testing.T) {
+	ctx := context.Background()
+	local, err := igpsportsync.NewLocalStorage(filepath.Join(t.TempDir(), "files"))
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	s3, _ := newTestS3Storage(t, "")
+	backends := []struct {
+		name    string
+		storage igpsportsync.Storage
+	}{
+		{"local", local},
+		{"memory", igpsportsync.NewMemoryStorage()},
+		{"s3", s3},
+	}
+
+	for _, b := range backends {
+		files := []igpsportsync.StoredFile{
+			{RideID: 2, Extension: igpsportsync.GPX, Size: 3},
+			{RideID: 2, Extension: igpsportsync.FIT, Size: 4},
+			{RideID: 10, Extension: igpsportsync.FIT, Size: 5},
+		}
+		for _, file := range files {
+			data := []byte("0123456789"[:file.Size])
+			if err := b.storage.Put(ctx, file.RideID, file.Extension, data); err != nil {
+				t.Fatalf("%s: put failed: %v", b.name, err)
+			}
+		}
+
+		data, err := b.storage.Get(ctx, 10, igpsportsync.FIT)
+		if err != nil || string(data) != "01234" {
+			t.Errorf("%s: got %q (%v)", b.name, data, err)
+		}
+		if _, err := b.storage.Get(ctx, 3, igpsportsync.FIT); !errors.Is(err, igpsportsync.ErrNotStored) {
+			t.Errorf("%s: expected ErrNotStored, got %v", b.name, err)
+		}
+		if ok, err := b.storage.Exists(ctx, 2, igpsportsync.GPX); !ok || err != nil {
+			t.Errorf("%s: expected 2.gpx to exist (%v)", b.name, err)
+		}
+		if ok, err := b.storage.Exists(ctx, 2, igpsportsync.TCX); ok || err != nil {
+			t.Errorf("%s: expected 2.tcx to be missing (%v)", b.name, err)
+		}
+
+		listed, err := b.storage.List(ctx)
+		if err != nil {
+			t.Fatalf("%s: list failed: %v", b.name, err)
+		}
+		want := "[{2 0 4} {2 1 3} {10 0 5}]"
+		if got := fmt.Sprint(listed); got != want {
+			t.Errorf("%s: listed %s, want %s", b.name, got, want)
+		}
+
+		if err := b.storage.Delete(ctx, 2, igpsportsync.FIT); err != nil {
+			t.Errorf("%s: delete failed: %v", b.name, err)
+		}
+		if err := b.storage.Delete(ctx, 2, igpsportsync.FIT); err != nil {
+			t.Errorf("%s: deleting a missing file failed: %v", b.name, err)
+		}
+		if listed, _ := b.storage.List(ctx); len(listed) != 2 {
+			t.Errorf("%s: expected 2 files after delete, got %v", b.name, listed)
+		}
+	}
+}
+
+// TestS3StorageSignature tests that a wrong secret is rejected
+func TestS3StorageSignature(t *testing.T) {
+	storage, _ := newTestS3Storage(t, "wrong")
+	err := storage.Put(context.Background(), 1, igpsportsync.FIT, []byte("data"))
+	if err == nil {
+		t.Errorf("Expected a signature error")
+	}
+}
+
+// TestDiskSinkStorage tests downloading into S3 and skipping synced rides
+func TestDiskSinkStorage(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(4, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	client := server.Client(t, igpsportsync.Config{})
+	storage, fake := newTestS3Storage(t, "")
+	dir := t.TempDir()
+	catalog := openCatalog(t, dir)
+
+	sink, err := igpsportsync.NewDiskSink(dir, true)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	defer sink.Close()
+	sink.Storage = storage
+	sink.Catalog = catalog
+
+	ctx := context.Background()
+	if _, err := client.DownloadActivities(ctx, []int{1, 3}, igpsportsync.DownloadOptions{Sink: sink}); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if data, ok := fake.Object("rides", "igpsport/3.fit"); !ok || len(data) == 0 {
+		t.Errorf("File not uploaded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "3.fit")); !os.IsNotExist(err) {
+		t.Errorf("File written to the directory: %v", err)
+	}
+	entry, err := catalog.Get(3)
+	if err != nil || entry == nil || len(entry.Files) != 1 || entry.Files[0].Path != "s3://rides/igpsport/3.fit" {
+		t.Errorf("Unexpected catalog entry: %+v (%v)", entry, err)
+	}
+
+	// a sync against the storage only downloads the missing rides
+	state, err := igpsportsync.NewStorageState(ctx, storage)
+	if err != nil {
+		t.Fatalf("Failed to list storage: %v", err)
+	}
+	summary, err := client.DownloadAllActivitiesContext(ctx, igpsportsync.DownloadOptions{Sink: sink, State: state})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if summary.Succeeded != 2 || summary.Skipped != 2 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if files, _ := storage.List(ctx); len(files) != 4 {
+		t.Errorf("Expected 4 stored files, got %v", files)
+	}
+
+	// the manifest is verified against the storage, not the directory
+	manifest := filepath.Join(dir, igpsportsync.MANIFEST_FILE)
+	report, err := igpsportsync.VerifyManifest(ctx, manifest, storage)
+	if err != nil || report.OK != 4 || len(report.Problems()) != 0 {
+		t.Errorf("Unexpected verify report: %+v (%v)", report, err)
+	}
+	if report, _ := igpsportsync.VerifyManifest(ctx, manifest, nil); report.Missing != 4 {
+		t.Errorf("Expected 4 files missing from the directory, got %+v", report)
+	}
+
+	// saving honors the download's context
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	activity := &igpsportsync.DownloadedActivity{RideID: 9, Extension: igpsportsync.FIT, Data: []byte("data")}
+	if err := sink.Save(cancelled, activity); err == nil {
+		t.Errorf("Expected a cancelled upload to fail")
+	}
+	if _, ok := fake.Object("rides", "igpsport/9.fit"); ok {
+		t.Errorf("File uploaded despite cancellation")
+	}
+}
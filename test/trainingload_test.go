@@ -0,0 +1,134 @@
+package test
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+// TestTrainingLoadSeries tests CTL/ATL/TSB against the exponential averages
+func TestTrainingLoadSeries(t *testing.T) {
+	load := igpsportsync.NewTrainingLoad()
+	load.Add(1, igpsportsync.RideStress{Date: "2024-05-01", TSS: 100, Source: "power"})
+	load.Add(2, igpsportsync.RideStress{Date: "2024-05-03", TSS: 50, Source: "power"})
+	load.Add(3, igpsportsync.RideStress{Date: "2024-05-03", TSS: 30, Source: "heartrate"})
+
+	series, err := load.Series("2024-04-30", "2024-05-04")
+	if err != nil {
+		t.Fatalf("Failed to compute series: %v", err)
+	}
+	if len(series) != 5 || series[0].Date != "2024-04-30" || series[0].CTL != 0 {
+		t.Fatalf("Unexpected series: %+v", series)
+	}
+
+	var ctl, atl float64
+	for i, tss := range []float64{100, 0, 80, 0} {
+		day := series[i+1]
+		tsb := ctl - atl
+		ctl += (tss - ctl) / igpsportsync.CTL_DAYS
+		atl += (tss - atl) / igpsportsync.ATL_DAYS
+		if day.TSS != tss || math.Abs(day.CTL-ctl) > 1e-9 || math.Abs(day.ATL-atl) > 1e-9 || math.Abs(day.TSB-tsb) > 1e-9 {
+			t.Errorf("Day %s: got %+v, want TSS %.0f CTL %.3f ATL %.3f TSB %.3f", day.Date, day, tss, ctl, atl, tsb)
+		}
+	}
+}
+
+// TestTrainingLoadIncremental tests that adding rides to a cached series
+// gives the same result as computing it from scratch
+func TestTrainingLoadIncremental(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "load.json")
+	load, err := igpsportsync.OpenTrainingLoad(path)
+	if err != nil {
+		t.Fatalf("Failed to open cache: %v", err)
+	}
+	load.Add(1, igpsportsync.RideStress{Date: "2024-05-01", TSS: 100})
+	load.Add(2, igpsportsync.RideStress{Date: "2024-05-10", TSS: 60})
+	if err := load.Save(); err != nil {
+		t.Fatalf("Failed to save cache: %v", err)
+	}
+
+	cached, err := igpsportsync.OpenTrainingLoad(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen cache: %v", err)
+	}
+	if !cached.Has(1) || !cached.Has(2) {
+		t.Fatalf("Cached rides missing")
+	}
+	// a ride between cached days and one replacing an earlier value
+	cached.Add(3, igpsportsync.RideStress{Date: "2024-05-05", TSS: 80})
+	cached.Add(2, igpsportsync.RideStress{Date: "2024-05-10", TSS: 70})
+	cached.Add(4, igpsportsync.RideStress{Date: "2024-05-20", TSS: 40})
+
+	fresh := igpsportsync.NewTrainingLoad()
+	fresh.Add(1, igpsportsync.RideStress{Date: "2024-05-01", TSS: 100})
+	fresh.Add(2, igpsportsync.RideStress{Date: "2024-05-10", TSS: 70})
+	fresh.Add(3, igpsportsync.RideStress{Date: "2024-05-05", TSS: 80})
+	fresh.Add(4, igpsportsync.RideStress{Date: "2024-05-20", TSS: 40})
+
+	got, err := cached.Series("2024-05-01", "2024-05-25")
+	if err != nil {
+		t.Fatalf("Failed to compute series: %v", err)
+	}
+	want, _ := fresh.Series("2024-05-01", "2024-05-25")
+	for i := range want {
+		if math.Abs(got[i].CTL-want[i].CTL) > 1e-9 || math.Abs(got[i].ATL-want[i].ATL) > 1e-9 || got[i].TSS != want[i].TSS {
+			t.Errorf("Day %s: got %+v, want %+v", want[i].Date, got[i], want[i])
+		}
+	}
+}
+
+// TestTrainingLoadActivity tests recording a downloaded FIT activity
+func TestTrainingLoadActivity(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	activity := &igpsportsync.DownloadedActivity{
+		RideID:    1,
+		StartTime: "2024-05-01 08:00:00",
+		Extension: igpsportsync.FIT,
+		Data:      BuildFITFile(SampleRide(start)),
+	}
+
+	load := igpsportsync.NewTrainingLoad()
+	var forwarded bool
+	var reported []int
+	callback := load.Callback(250, igpsportsync.HeartRateOptions{}, func(rideID int, err error) {
+		reported = append(reported, rideID)
+	}, func(*igpsportsync.DownloadedActivity) bool {
+		forwarded = true
+		return true
+	})
+	if !callback(activity) || !forwarded {
+		t.Fatalf("Callback did not forward the activity")
+	}
+	// unusable data is reported and still forwarded
+	forwarded = false
+	broken := &igpsportsync.DownloadedActivity{RideID: 2, StartTime: "2024-05-02 08:00:00", Extension: igpsportsync.FIT, Data: []byte("broken")}
+	if !callback(broken) || !forwarded || len(reported) != 1 || reported[0] != 2 {
+		t.Errorf("Expected ride 2 reported and forwarded, got %v", reported)
+	}
+
+	series, err := load.Series("2024-05-01", "2024-05-01")
+	if err != nil {
+		t.Fatalf("Failed to compute series: %v", err)
+	}
+	if want := 600 * 200 * 0.8 / (250 * 3600) * 100; math.Abs(series[0].TSS-want) > 1e-6 {
+		t.Errorf("TSS %.3f, want %.3f", series[0].TSS, want)
+	}
+
+	// without FTP the heart rate stress is used
+	stress, source, err := igpsportsync.ActivityStress(mustDecode(t, activity.Data), 0, igpsportsync.HeartRateOptions{MaxHR: 190, LTHR: 170})
+	if err != nil || source != "heartrate" || stress <= 0 {
+		t.Errorf("Unexpected heart rate stress %.1f (%s): %v", stress, source, err)
+	}
+}
+
+func mustDecode(t *testing.T, data []byte) *igpsportsync.Track {
+	t.Helper()
+	track, err := igpsportsync.DecodeTrack(data, igpsportsync.FIT)
+	if err != nil {
+		t.Fatalf("Failed to decode FIT: %v", err)
+	}
+	return track
+}
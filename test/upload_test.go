@@ -0,0 +1,185 @@
+package test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	igpsportsync "github.com/NenoSann/igpsport_sync"
+)
+
+func newTestStravaUploader(fake *FakeStrava, onToken func(igpsportsync.StravaToken) error) *igpsportsync.StravaUploader {
+	return igpsportsync.NewStravaUploader(igpsportsync.StravaConfig{
+		ClientID:     fake.ClientID,
+		ClientSecret: fake.ClientSecret,
+		Token:        igpsportsync.StravaToken{RefreshToken: "refresh-0"},
+		OnToken:      onToken,
+		BaseURL:      fake.URL,
+		PollInterval: 5 * time.Millisecond,
+	})
+}
+
+// TestStravaUploader tests token refresh, processing and duplicate detection
+func TestStravaUploader(t *testing.T) {
+	fake := NewFakeStrava(t, "refresh-0")
+	var tokens []igpsportsync.StravaToken
+	uploader := newTestStravaUploader(fake, func(token igpsportsync.StravaToken) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	ctx := context.Background()
+	data := BuildFITFile(SampleRide(time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+
+	result, err := uploader.Upload(ctx, &igpsportsync.DownloadedActivity{RideID: 1, Title: "Morning Ride", Extension: igpsportsync.FIT, Data: data})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if result.Destination != "strava" || result.RideID != 1 || result.RemoteID == "" || result.Duplicate {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if len(tokens) != 1 || tokens[0].RefreshToken != "refresh-1" || tokens[0].ExpiresAt.Before(time.Now()) {
+		t.Errorf("Unexpected tokens: %+v", tokens)
+	}
+	var first FakeStravaUpload
+	for _, upload := range fake.Uploads() {
+		first = upload
+	}
+	if first.ExternalID != "igpsport-1" || first.Name != "Morning Ride" || first.DataType != "fit" {
+		t.Errorf("Unexpected upload: %+v", first)
+	}
+
+	// the same file again is a duplicate of the first activity
+	duplicate, err := uploader.Upload(ctx, &igpsportsync.DownloadedActivity{RideID: 2, Extension: igpsportsync.FIT, Data: data})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if !duplicate.Duplicate || duplicate.RemoteID != result.RemoteID {
+		t.Errorf("Expected a duplicate of %s, got %+v", result.RemoteID, duplicate)
+	}
+
+	// a revoked token is refreshed once
+	fake.Revoke()
+	other := BuildFITFile(SampleRide(time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)))
+	if _, err := uploader.Upload(ctx, &igpsportsync.DownloadedActivity{RideID: 3, Extension: igpsportsync.FIT, Data: other}); err != nil {
+		t.Fatalf("Upload after revoke failed: %v", err)
+	}
+	if fake.Refreshes() != 2 || len(tokens) != 2 {
+		t.Errorf("Expected 2 token refreshes, got %d", fake.Refreshes())
+	}
+
+	// processing that never finishes times out
+	fake.ProcessingPolls = 1000
+	slow := igpsportsync.NewStravaUploader(igpsportsync.StravaConfig{
+		ClientID: fake.ClientID, ClientSecret: fake.ClientSecret,
+		Token:   igpsportsync.StravaToken{RefreshToken: tokens[1].RefreshToken},
+		BaseURL: fake.URL, PollInterval: 5 * time.Millisecond, PollTimeout: 30 * time.Millisecond,
+	})
+	third := BuildFITFile(SampleRide(time.Date(2024, 5, 3, 8, 0, 0, 0, time.UTC)))
+	if _, err := slow.Upload(ctx, &igpsportsync.DownloadedActivity{RideID: 4, Extension: igpsportsync.FIT, Data: third}); err == nil {
+		t.Errorf("Expected a processing timeout")
+	}
+}
+
+// TestUploadStored tests uploading downloaded files once, tracked by the upload log
+func TestUploadStored(t *testing.T) {
+	server := NewFakeServer(t, FakeActivities(3, time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)))
+	client := server.Client(t, igpsportsync.Config{})
+	fake := NewFakeStrava(t, "refresh-0")
+	uploader := newTestStravaUploader(fake, nil)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// rides 3 and 2 are uploaded while downloading
+	log, err := igpsportsync.OpenUploadLog(filepath.Join(dir, "uploads.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open upload log: %v", err)
+	}
+	sink, err := igpsportsync.NewDiskSink(filepath.Join(dir, "activities"), true)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	var reported []igpsportsync.UploadResult
+	report := func(result igpsportsync.UploadResult, err error) {
+		if err != nil {
+			t.Errorf("Upload failed: %v", err)
+		}
+		reported = append(reported, result)
+	}
+	_, err = client.DownloadActivities(ctx, []int{3, 2}, igpsportsync.DownloadOptions{
+		Sink:     sink,
+		Callback: igpsportsync.UploadCallback(ctx, uploader, log, report, nil),
+	})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if _, err := client.DownloadActivities(ctx, []int{1}, igpsportsync.DownloadOptions{Sink: sink}); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	sink.Close()
+	log.Close()
+	if len(reported) != 2 || len(fake.Uploads()) != 2 {
+		t.Fatalf("Expected 2 uploads, got %d", len(fake.Uploads()))
+	}
+
+	// the stored files are uploaded after reopening the log, except the logged ones
+	log, err = igpsportsync.OpenUploadLog(filepath.Join(dir, "uploads.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open upload log: %v", err)
+	}
+	defer log.Close()
+	state, err := igpsportsync.LoadManifestState(filepath.Join(sink.Dir, igpsportsync.MANIFEST_FILE))
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	entries, _ := state.Activities()
+	reported = nil
+	if err := igpsportsync.UploadStored(ctx, uploader, log, sink.Storage, entries, report); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if len(reported) != 1 || reported[0].RideID != 1 || len(fake.Uploads()) != 3 {
+		t.Errorf("Expected only ride 1 to be uploaded, got %+v", reported)
+	}
+	if result, ok := log.Uploaded("strava", 1); !ok || result.RemoteID == "" {
+		t.Errorf("Ride 1 not logged: %+v", result)
+	}
+}
+
+// TestIntervalsUploader tests uploads keyed by RideID and duplicate detection
+func TestIntervalsUploader(t *testing.T) {
+	fake := NewFakeIntervals(t)
+	uploader := igpsportsync.NewIntervalsUploader(igpsportsync.IntervalsConfig{APIKey: fake.APIKey, BaseURL: fake.URL})
+	ctx := context.Background()
+	activity := &igpsportsync.DownloadedActivity{
+		RideID:    42,
+		Title:     "Evening Ride",
+		Extension: igpsportsync.GPX,
+		Data:      []byte("<gpx/>"),
+	}
+
+	result, err := uploader.Upload(ctx, activity)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if result.Destination != "intervals" || result.RemoteID != "i100" || result.Duplicate {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	got := fake.Activities()["42"]
+	if got.Name != "Evening Ride" || got.FileName != "42.gpx" || got.Size != 6 {
+		t.Errorf("Unexpected activity: %+v", got)
+	}
+
+	// the same RideID again is a duplicate, whatever the log says
+	result, err = uploader.Upload(ctx, activity)
+	if err != nil || !result.Duplicate {
+		t.Errorf("Expected a duplicate, got %+v (%v)", result, err)
+	}
+	if len(fake.Activities()) != 1 {
+		t.Errorf("Duplicate stored")
+	}
+
+	bad := igpsportsync.NewIntervalsUploader(igpsportsync.IntervalsConfig{APIKey: "wrong", BaseURL: fake.URL})
+	if _, err := bad.Upload(ctx, activity); err == nil {
+		t.Errorf("Expected an authorization error")
+	}
+}
@@ -0,0 +1,346 @@
+package igpsportsync
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// TrackPoint is a single sample of a recorded activity
+type TrackPoint struct {
+	Time        time.Time
+	Lat         float64 // degrees
+	Lon         float64 // degrees
+	HasPosition bool
+	Altitude    float64 // meters
+	HasAltitude bool
+	Distance    float64 // cumulative meters from the start
+	Speed       float64 // m/s, 0 if unknown
+	HeartRate   int     // bpm, 0 if unknown
+	Cadence     int     // rpm, 0 if unknown
+	Power       int     // watts, only meaningful if HasPower
+	HasPower    bool
+}
+
+// Track is the decoded sample stream of an activity file
+type Track struct {
+	// Sport as reported by the file, e.g. "cycling", empty if unknown
+	Sport  string
+	Points []TrackPoint
+}
+
+// DecodeTrack decodes FIT, GPX or TCX file data into a Track.
+// Cumulative distance is computed from positions when the file has none.
+func DecodeTrack(data []byte, ext Extension) (*Track, error) {
+	var track *Track
+	var err error
+	switch ext {
+	case GPX:
+		track, err = decodeGPXTrack(data)
+	case TCX:
+		track, err = decodeTCXTrack(data)
+	default:
+		track, err = decodeFITTrack(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	track.fillDistances()
+	return track, nil
+}
+
+var fitSports = map[uint64]string{
+	0: "generic", 1: "running", 2: "cycling", 5: "swimming",
+	10: "training", 11: "walking", 17: "hiking", 21: "e_biking",
+}
+
+func decodeFITTrack(data []byte) (*Track, error) {
+	f, err := decodeFIT(data)
+	if err != nil {
+		return nil, err
+	}
+
+	track := &Track{}
+	for i := range f.messages {
+		m := &f.messages[i]
+		switch m.num {
+		case FIT_MESG_SESSION:
+			if sport, ok := f.uint(m, 5); ok && track.Sport == "" {
+				track.Sport = fitSports[sport]
+			}
+		case FIT_MESG_RECORD:
+			if !m.hasTimestamp {
+				continue
+			}
+			p := TrackPoint{Time: fitTime(m.timestamp)}
+			lat, okLat := f.sint(m, 0)
+			lon, okLon := f.sint(m, 1)
+			if okLat && okLon {
+				p.Lat, p.Lon, p.HasPosition = fitSemicircles(lat), fitSemicircles(lon), true
+			}
+			if v, ok := f.uint(m, 78); ok {
+				p.Altitude, p.HasAltitude = float64(v)/5-500, true
+			} else if v, ok := f.uint(m, 2); ok {
+				p.Altitude, p.HasAltitude = float64(v)/5-500, true
+			}
+			if v, ok := f.uint(m, 5); ok {
+				p.Distance = float64(v) / 100
+			}
+			if v, ok := f.uint(m, 73); ok {
+				p.Speed = float64(v) / 1000
+			} else if v, ok := f.uint(m, 6); ok {
+				p.Speed = float64(v) / 1000
+			}
+			if v, ok := f.uint(m, 3); ok {
+				p.HeartRate = int(v)
+			}
+			if v, ok := f.uint(m, 4); ok {
+				p.Cadence = int(v)
+			}
+			if v, ok := f.uint(m, 7); ok {
+				p.Power, p.HasPower = int(v), true
+			}
+			track.Points = append(track.Points, p)
+		}
+	}
+	return track, nil
+}
+
+type gpxDocument struct {
+	Tracks []struct {
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []struct {
+				Lat   float64  `xml:"lat,attr"`
+				Lon   float64  `xml:"lon,attr"`
+				Ele   *float64 `xml:"ele"`
+				Time  string   `xml:"time"`
+				HR    int      `xml:"extensions>TrackPointExtension>hr"`
+				Cad   int      `xml:"extensions>TrackPointExtension>cad"`
+				Power *int     `xml:"extensions>power"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+func decodeGPXTrack(data []byte) (*Track, error) {
+	var doc gpxDocument
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("gpx: %v", err)
+	}
+
+	track := &Track{}
+	for _, trk := range doc.Tracks {
+		if track.Sport == "" {
+			track.Sport = strings.ToLower(trk.Type)
+		}
+		for _, seg := range trk.Segments {
+			for _, pt := range seg.Points {
+				p := TrackPoint{Lat: pt.Lat, Lon: pt.Lon, HasPosition: true, HeartRate: pt.HR, Cadence: pt.Cad}
+				p.Time, _ = time.Parse(time.RFC3339, strings.TrimSpace(pt.Time))
+				if pt.Ele != nil {
+					p.Altitude, p.HasAltitude = *pt.Ele, true
+				}
+				if pt.Power != nil {
+					p.Power, p.HasPower = *pt.Power, true
+				}
+				track.Points = append(track.Points, p)
+			}
+		}
+	}
+	return track, nil
+}
+
+type tcxDocument struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Laps  []struct {
+			Tracks []struct {
+				Points []struct {
+					Time     string   `xml:"Time"`
+					Lat      *float64 `xml:"Position>LatitudeDegrees"`
+					Lon      *float64 `xml:"Position>LongitudeDegrees"`
+					Altitude *float64 `xml:"AltitudeMeters"`
+					Distance *float64 `xml:"DistanceMeters"`
+					HR       int      `xml:"HeartRateBpm>Value"`
+					Cadence  int      `xml:"Cadence"`
+					Speed    *float64 `xml:"Extensions>TPX>Speed"`
+					Watts    *int     `xml:"Extensions>TPX>Watts"`
+				} `xml:"Trackpoint"`
+			} `xml:"Track"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+func decodeTCXTrack(data []byte) (*Track, error) {
+	var doc tcxDocument
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("tcx: %v", err)
+	}
+
+	track := &Track{}
+	for _, activity := range doc.Activities {
+		if track.Sport == "" {
+			track.Sport = strings.ToLower(activity.Sport)
+			if track.Sport == "biking" {
+				track.Sport = "cycling"
+			}
+		}
+		for _, lap := range activity.Laps {
+			for _, trk := range lap.Tracks {
+				for _, pt := range trk.Points {
+					p := TrackPoint{HeartRate: pt.HR, Cadence: pt.Cadence}
+					p.Time, _ = time.Parse(time.RFC3339, strings.TrimSpace(pt.Time))
+					if pt.Lat != nil && pt.Lon != nil {
+						p.Lat, p.Lon, p.HasPosition = *pt.Lat, *pt.Lon, true
+					}
+					if pt.Altitude != nil {
+						p.Altitude, p.HasAltitude = *pt.Altitude, true
+					}
+					if pt.Distance != nil {
+						p.Distance = *pt.Distance
+					}
+					if pt.Speed != nil {
+						p.Speed = *pt.Speed
+					}
+					if pt.Watts != nil {
+						p.Power, p.HasPower = *pt.Watts, true
+					}
+					track.Points = append(track.Points, p)
+				}
+			}
+		}
+	}
+	return track, nil
+}
+
+// fillDistances computes cumulative distances from positions when the
+// source file carried none
+func (t *Track) fillDistances() {
+	for _, p := range t.Points {
+		if p.Distance > 0 {
+			return
+		}
+	}
+	var total float64
+	var last *TrackPoint
+	for i := range t.Points {
+		p := &t.Points[i]
+		if p.HasPosition {
+			if last != nil {
+				total += Haversine(last.Lat, last.Lon, p.Lat, p.Lon)
+			}
+			last = p
+		}
+		p.Distance = total
+	}
+}
+
+// StartTime returns the time of the first sample
+func (t *Track) StartTime() time.Time {
+	for _, p := range t.Points {
+		if !p.Time.IsZero() {
+			return p.Time
+		}
+	}
+	return time.Time{}
+}
+
+// ElapsedTime returns the time between the first and last sample
+func (t *Track) ElapsedTime() time.Duration {
+	start := t.StartTime()
+	for i := len(t.Points) - 1; i >= 0; i-- {
+		if !t.Points[i].Time.IsZero() {
+			return t.Points[i].Time.Sub(start)
+		}
+	}
+	return 0
+}
+
+// MOVING_SPEED_THRESHOLD is the speed (m/s) above which a rider counts as moving
+const MOVING_SPEED_THRESHOLD = 0.5
+
+// MovingTime sums the intervals in which the rider moved faster than
+// MOVING_SPEED_THRESHOLD
+func (t *Track) MovingTime() time.Duration {
+	var moving time.Duration
+	for i := 1; i < len(t.Points); i++ {
+		prev, p := t.Points[i-1], t.Points[i]
+		dt := p.Time.Sub(prev.Time)
+		if dt <= 0 || prev.Time.IsZero() {
+			continue
+		}
+		if (p.Distance-prev.Distance)/dt.Seconds() > MOVING_SPEED_THRESHOLD {
+			moving += dt
+		}
+	}
+	return moving
+}
+
+// Distance returns the total distance in meters
+func (t *Track) Distance() float64 {
+	var max float64
+	for _, p := range t.Points {
+		max = math.Max(max, p.Distance)
+	}
+	return max
+}
+
+// ElevationGain sums the positive altitude changes in meters
+func (t *Track) ElevationGain() float64 {
+	var gain float64
+	var last *TrackPoint
+	for i := range t.Points {
+		p := &t.Points[i]
+		if !p.HasAltitude {
+			continue
+		}
+		if last != nil && p.Altitude > last.Altitude {
+			gain += p.Altitude - last.Altitude
+		}
+		last = p
+	}
+	return gain
+}
+
+// AverageHeartRate returns the mean of the recorded heart rate samples, 0 if none
+func (t *Track) AverageHeartRate() float64 {
+	var sum, n int
+	for _, p := range t.Points {
+		if p.HeartRate > 0 {
+			sum += p.HeartRate
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(sum) / float64(n)
+}
+
+// Positions returns the coordinates of all samples that have a position
+func (t *Track) Positions() []LatLng {
+	var positions []LatLng
+	for _, p := range t.Points {
+		if p.HasPosition {
+			positions = append(positions, LatLng{Lat: p.Lat, Lng: p.Lon})
+		}
+	}
+	return positions
+}
+
+// EARTH_RADIUS is the mean earth radius in meters
+const EARTH_RADIUS = 6371008.8
+
+// Haversine returns the great-circle distance in meters between two coordinates
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EARTH_RADIUS * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,264 @@
+package igpsportsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Time constants of the Performance Management Chart, in days
+const (
+	CTL_DAYS = 42
+	ATL_DAYS = 7
+)
+
+const dateLayout = "2006-01-02"
+
+// DailyLoad is one day of the Performance Management Chart
+type DailyLoad struct {
+	Date string  `json:"date"` // 2006-01-02
+	TSS  float64 `json:"tss"`  // training stress of the day
+	CTL  float64 `json:"ctl"`  // chronic training load (fitness)
+	ATL  float64 `json:"atl"`  // acute training load (fatigue)
+	TSB  float64 `json:"tsb"`  // training stress balance (form), yesterday's CTL - ATL
+}
+
+// RideStress is the training stress recorded for one activity
+type RideStress struct {
+	Date   string  `json:"date"`
+	TSS    float64 `json:"tss"`
+	Source string  `json:"source"` // "power" or "heartrate"
+}
+
+// ActivityStress computes the training stress of a track: power based TSS
+// when the track has power and ftp is set, hrTSS otherwise
+func ActivityStress(track *Track, ftp int, hr HeartRateOptions) (float64, string, error) {
+	if ftp > 0 {
+		if power, err := AnalyzePower(track, ftp); err == nil {
+			return power.TSS, "power", nil
+		}
+	}
+	summary, err := AnalyzeHeartRate(track, hr)
+	if err != nil {
+		return 0, "", fmt.Errorf("no power or heart rate data to compute training stress")
+	}
+	if summary.HrTSS == 0 {
+		return 0, "", fmt.Errorf("hrTSS needs max HR and LTHR")
+	}
+	return summary.HrTSS, "heartrate", nil
+}
+
+// TrainingLoad keeps the training stress of every activity and a cached
+// daily CTL/ATL/TSB series. Adding activities only recomputes the series
+// from the earliest changed day. It is safe for concurrent use.
+type TrainingLoad struct {
+	mu    sync.Mutex
+	path  string
+	rides map[int]RideStress
+	days  []DailyLoad
+	dirty string // earliest day to recompute, "" if the cache is current
+}
+
+type trainingLoadFile struct {
+	Rides map[int]RideStress `json:"rides"`
+	Days  []DailyLoad        `json:"days"`
+}
+
+// NewTrainingLoad creates an empty in-memory TrainingLoad
+func NewTrainingLoad() *TrainingLoad {
+	return &TrainingLoad{rides: make(map[int]RideStress)}
+}
+
+// OpenTrainingLoad loads the cache at path, or starts an empty one if the
+// file does not exist. Save writes it back.
+func OpenTrainingLoad(path string) (*TrainingLoad, error) {
+	l := NewTrainingLoad()
+	l.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading training load cache: %v", err)
+	}
+	var file trainingLoadFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error decoding training load cache: %v", err)
+	}
+	if file.Rides != nil {
+		l.rides = file.Rides
+	}
+	l.days = file.Days
+	return l, nil
+}
+
+// Save writes the cache to the path given to OpenTrainingLoad
+func (l *TrainingLoad) Save() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path == "" {
+		return fmt.Errorf("training load has no cache file")
+	}
+	l.recompute("")
+	data, err := json.Marshal(trainingLoadFile{Rides: l.rides, Days: l.days})
+	if err != nil {
+		return fmt.Errorf("error encoding training load cache: %v", err)
+	}
+	return writeFileAtomic(l.path, data)
+}
+
+// Has reports whether the activity is already recorded
+func (l *TrainingLoad) Has(rideID int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.rides[rideID]
+	return ok
+}
+
+// Add records (or replaces) the training stress of an activity on a day
+func (l *TrainingLoad) Add(rideID int, stress RideStress) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if old, ok := l.rides[rideID]; ok {
+		if old == stress {
+			return
+		}
+		l.markDirty(old.Date)
+	}
+	l.rides[rideID] = stress
+	l.markDirty(stress.Date)
+}
+
+func (l *TrainingLoad) markDirty(date string) {
+	if l.dirty == "" || date < l.dirty {
+		l.dirty = date
+	}
+}
+
+// AddActivity decodes a downloaded activity and records its training stress.
+// The day is taken from the activity's StartTime.
+func (l *TrainingLoad) AddActivity(activity *DownloadedActivity, ftp int, hr HeartRateOptions) error {
+	if activity.Error != nil {
+		return activity.Error
+	}
+	start, err := ParseActivityTime(activity.StartTime, time.UTC)
+	if err != nil {
+		return err
+	}
+	track, err := DecodeTrack(activity.Data, activity.Extension)
+	if err != nil {
+		return err
+	}
+	tss, source, err := ActivityStress(track, ftp, hr)
+	if err != nil {
+		return fmt.Errorf("activity %d: %v", activity.RideID, err)
+	}
+	l.Add(activity.RideID, RideStress{Date: start.Format(dateLayout), TSS: tss, Source: source})
+	return nil
+}
+
+// Callback returns a DownloadCallback that records every successfully
+// downloaded activity and then calls next (if not nil), so the series stays
+// current as new activities sync. Activities without usable data are
+// skipped and their error is passed to report (if not nil).
+func (l *TrainingLoad) Callback(ftp int, hr HeartRateOptions, report func(rideID int, err error), next DownloadCallback) DownloadCallback {
+	return func(activity *DownloadedActivity) bool {
+		if activity.Error == nil {
+			if err := l.AddActivity(activity, ftp, hr); err != nil && report != nil {
+				report(activity.RideID, err)
+			}
+		}
+		if next == nil {
+			return true
+		}
+		return next(activity)
+	}
+}
+
+// Series returns the daily load from `from` to `to` inclusive
+// (dates as 2006-01-02). Days before the first activity have zero load.
+func (l *TrainingLoad) Series(from, to string) ([]DailyLoad, error) {
+	start, err := time.Parse(dateLayout, from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from date: %v", err)
+	}
+	end, err := time.Parse(dateLayout, to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to date: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recompute(to)
+
+	var series []DailyLoad
+	index := make(map[string]DailyLoad, len(l.days))
+	for _, day := range l.days {
+		index[day.Date] = day
+	}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format(dateLayout)
+		if day, ok := index[date]; ok {
+			series = append(series, day)
+		} else {
+			series = append(series, DailyLoad{Date: date})
+		}
+	}
+	return series, nil
+}
+
+// recompute brings the cached days up to date from the dirty day on and
+// extends them through `through` (and the last activity)
+func (l *TrainingLoad) recompute(through string) {
+	if len(l.rides) == 0 {
+		l.days, l.dirty = nil, ""
+		return
+	}
+
+	daily := make(map[string]float64)
+	var first, last string
+	for _, ride := range l.rides {
+		daily[ride.Date] += ride.TSS
+		if first == "" || ride.Date < first {
+			first = ride.Date
+		}
+		if ride.Date > last {
+			last = ride.Date
+		}
+	}
+	if through > last {
+		last = through
+	}
+
+	// keep the cached prefix before the dirty day
+	keep := 0
+	if len(l.days) > 0 && l.days[0].Date == first {
+		keep = len(l.days)
+		if l.dirty != "" {
+			keep = sort.Search(len(l.days), func(i int) bool { return l.days[i].Date >= l.dirty })
+		}
+	}
+	l.days = l.days[:keep]
+
+	day, _ := time.Parse(dateLayout, first)
+	var ctl, atl float64
+	if keep > 0 {
+		previous := l.days[keep-1]
+		ctl, atl = previous.CTL, previous.ATL
+		day, _ = time.Parse(dateLayout, previous.Date)
+		day = day.AddDate(0, 0, 1)
+	}
+	for date := day.Format(dateLayout); date <= last; date = day.Format(dateLayout) {
+		tss := daily[date]
+		tsb := ctl - atl
+		ctl += (tss - ctl) / CTL_DAYS
+		atl += (tss - atl) / ATL_DAYS
+		l.days = append(l.days, DailyLoad{Date: date, TSS: tss, CTL: ctl, ATL: atl, TSB: tsb})
+		day = day.AddDate(0, 0, 1)
+	}
+	l.dirty = ""
+}
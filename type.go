@@ -1,5 +1,12 @@
 package igpsportsync
 
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
 type Extension string
 
 const (
@@ -8,6 +15,74 @@ const (
 	TCX Extension = "2"
 )
 
+// Name returns the lowercase file extension for the format, e.g. "fit".
+// An empty Extension is treated as FIT.
+func (e Extension) Name() string {
+	switch e {
+	case GPX:
+		return "gpx"
+	case TCX:
+		return "tcx"
+	default:
+		return "fit"
+	}
+}
+
+// ParseExtension converts a file extension such as "fit", ".gpx" or "TCX"
+// to an Extension
+func ParseExtension(name string) (Extension, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "fit":
+		return FIT, nil
+	case "gpx":
+		return GPX, nil
+	case "tcx":
+		return TCX, nil
+	}
+	return "", fmt.Errorf("unknown extension: %s", name)
+}
+
+// DetectExtension tells the format of file data from its content: the FIT
+// header signature, or the root element of GPX and TCX documents
+func DetectExtension(data []byte) (Extension, bool) {
+	if len(data) >= 12 && string(data[8:12]) == ".FIT" {
+		return FIT, true
+	}
+	head := string(data[:min(len(data), 1024)])
+	switch {
+	case strings.Contains(head, "<gpx"):
+		return GPX, true
+	case strings.Contains(head, "<TrainingCenterDatabase"):
+		return TCX, true
+	}
+	return "", false
+}
+
+// activityTimeLayouts are the layouts iGPSport uses for StartTime/EndTime
+var activityTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006.01.02 15:04:05",
+	time.RFC3339,
+	"2006-01-02",
+}
+
+// ParseActivityTime parses a StartTime/EndTime value returned by the API.
+// Times without a zone are interpreted in loc (time.Local if nil).
+func ParseActivityTime(value string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	value = strings.TrimSpace(value)
+	for _, layout := range activityTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time format: %q", value)
+}
+
 type Response struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
@@ -132,12 +207,55 @@ type ActivityDetailData struct {
 	DeviceInfo           DeviceInfo `json:"deviceInfo"`
 }
 
+// DownloadSummary reports the outcome of a bulk download
+type DownloadSummary struct {
+	Listed    int // activities taken from the listing before the run ended
+	Succeeded int // delivered without error
+	Failed    int // delivered with Error set
+
+	// Skipped counts listed activities that were not delivered: rejected
+	// by the filter, already synced, content duplicates, or abandoned when
+	// the run stopped
+	Skipped int
+
+	// StoppedEarly is set when the callback returned false or the context
+	// ended before the listing was done
+	StoppedEarly bool
+
+	Duration time.Duration
+}
+
 type DownloadedActivity struct {
 	RideID    int
 	Title     string
 	StartTime string
+
+	// Extension is the format of Data, which differs from the requested
+	// DownloadOptions.Extension when the served file could not be
+	// converted (see ConvertActivity)
+	Extension Extension
+
+	// Requested is the DownloadOptions.Extension of the download
+	Requested Extension
+
+	// SourceURL is the url the file data was fetched from, empty if the
+	// download url could not be resolved
+	SourceURL string
 	Data      []byte
 	Error     error
+
+	// Row is the activity list entry, nil for DownloadSingleActivity and
+	// DownloadActivities
+	Row *ActivityRow
+
+	// Detail is the activity detail when DownloadOptions.IncludeDetail is
+	// set (always for DownloadSingleActivity and DownloadActivities), nil
+	// otherwise or when it could not be fetched
+	Detail *ActivityDetailData
+
+	// DetailError is the error fetching Detail; the file may still have
+	// been downloaded
+	DetailError error
 }
 
 // DownloadOptions contains configuration for downloading activities
@@ -158,7 +276,77 @@ type DownloadOptions struct {
 	// Default: 5 (if set to 0)
 	MaxConcurrency int
 
+	// OrderedDelivery makes DownloadAllActivitiesWithConcurrency deliver
+	// activities in listing order instead of completion order. Callbacks are
+	// serialized in either mode.
+	OrderedDelivery bool
+
+	// ReorderBuffer bounds how many activities may be downloaded ahead of
+	// the next one to deliver in ordered mode. Default: 2 * MaxConcurrency
+	ReorderBuffer int
+
+	// IncludeDetail fetches the activity detail concurrently with each file
+	// and attaches it to DownloadedActivity.Detail. Config.DetailCache is used.
+	IncludeDetail bool
+
+	// Filter selects which activities are downloaded (optional)
+	// Activities rejected by the filter are skipped without calling Callback
+	Filter *ActivityFilter
+
+	// State skips listed activities already stored in Extension (optional).
+	// They count as Skipped; DownloadActivities ignores it.
+	State SyncState
+
+	// Sink stores each downloaded activity before Callback is called
+	// (optional). A failed save is reported to Callback through
+	// activity.Error.
+	Sink ActivitySink
+
+	// Progress receives a DownloadProgress after each activity of
+	// DownloadAllActivities, DownloadAllActivitiesWithConcurrency,
+	// DownloadAllActivitiesContext and DownloadActivities (optional)
+	Progress ProgressFunc
+
 	// Callback is called for each downloaded activity
 	// Return true to continue, false to stop downloading
+	// Optional when Sink is set
 	Callback DownloadCallback
 }
+
+// ActivitySink stores downloaded activities, e.g. DiskSink
+type ActivitySink interface {
+	Save(ctx context.Context, activity *DownloadedActivity) error
+}
+
+// want reports whether a listed activity should be downloaded
+func (o *DownloadOptions) want(row ActivityRow) bool {
+	if !o.Filter.Match(row) {
+		return false
+	}
+	return o.State == nil || !o.State.Synced(row.RideID, o.Extension)
+}
+
+func (o *DownloadOptions) validate() error {
+	if o.Callback == nil && o.Sink == nil {
+		return fmt.Errorf("callback function or sink is required")
+	}
+	return nil
+}
+
+// deliver passes a downloaded activity that is not a content duplicate to
+// the sink and the callback. delivered is false for duplicates; cont is
+// false to stop downloading.
+func (o *DownloadOptions) deliver(ctx context.Context, activity *DownloadedActivity) (delivered bool, cont bool) {
+	if !o.Filter.matchContent(activity) {
+		return false, true
+	}
+	if o.Sink != nil {
+		if err := o.Sink.Save(ctx, activity); err != nil && activity.Error == nil {
+			activity.Error = err
+		}
+	}
+	if o.Callback == nil {
+		return true, true
+	}
+	return true, o.Callback(activity)
+}
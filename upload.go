@@ -0,0 +1,199 @@
+package igpsportsync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Uploader sends activity files to a training platform, e.g. StravaUploader
+type Uploader interface {
+	// Name identifies the destination in an UploadLog, e.g. "strava"
+	Name() string
+
+	// Upload sends the activity file and waits until the destination has
+	// processed it. An activity the destination already has is reported
+	// with UploadResult.Duplicate, not as an error.
+	Upload(ctx context.Context, activity *DownloadedActivity) (UploadResult, error)
+}
+
+// UploadResult is a processed upload
+type UploadResult struct {
+	Destination string `json:"destination"`
+	RideID      int    `json:"rideId"`
+
+	// RemoteID is the activity id at the destination, empty if unknown
+	RemoteID string `json:"remoteId,omitempty"`
+
+	// Duplicate is set when the destination already had the activity;
+	// RemoteID is then the existing activity if known
+	Duplicate bool `json:"duplicate,omitempty"`
+
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+// UploadLog records uploads in a JSON Lines file, one result per line, so
+// every activity is uploaded to a destination once. Safe for concurrent use.
+type UploadLog struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string]UploadResult
+}
+
+// OpenUploadLog reads the log at path, if any, and opens it for appending
+func OpenUploadLog(path string) (*UploadLog, error) {
+	log := &UploadLog{done: make(map[string]UploadResult)}
+	f, err := os.Open(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error opening upload log: %v", err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var result UploadResult
+			if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("error decoding upload log line %d: %v", line, err)
+			}
+			log.done[uploadKey(result.Destination, result.RideID)] = result
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading upload log: %v", err)
+		}
+	}
+
+	log.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening upload log: %v", err)
+	}
+	return log, nil
+}
+
+func uploadKey(destination string, rideID int) string {
+	return destination + "/" + strconv.Itoa(rideID)
+}
+
+// Uploaded returns the recorded upload of an activity to a destination
+func (l *UploadLog) Uploaded(destination string, rideID int) (UploadResult, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result, ok := l.done[uploadKey(destination, rideID)]
+	return result, ok
+}
+
+// Record appends a result to the log
+func (l *UploadLog) Record(result UploadResult) error {
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing upload log: %v", err)
+	}
+	l.done[uploadKey(result.Destination, result.RideID)] = result
+	return nil
+}
+
+// Close closes the log file
+func (l *UploadLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// UploadActivity uploads an activity unless log (optional) already has it,
+// in which case the recorded result is returned, and records the outcome
+func UploadActivity(ctx context.Context, uploader Uploader, log *UploadLog, activity *DownloadedActivity) (UploadResult, error) {
+	if log != nil {
+		if result, ok := log.Uploaded(uploader.Name(), activity.RideID); ok {
+			return result, nil
+		}
+	}
+	result, err := uploader.Upload(ctx, activity)
+	if err != nil {
+		return result, fmt.Errorf("error uploading activity %d to %s: %v", activity.RideID, uploader.Name(), err)
+	}
+	if log != nil {
+		if err := log.Record(result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// UploadCallback returns a DownloadCallback that uploads every successfully
+// downloaded activity with UploadActivity, then calls next (if not nil).
+// Each outcome is passed to report (if not nil); a failed upload does not
+// stop the download.
+func UploadCallback(ctx context.Context, uploader Uploader, log *UploadLog, report func(UploadResult, error), next DownloadCallback) DownloadCallback {
+	return func(activity *DownloadedActivity) bool {
+		if activity.Error == nil {
+			result, err := UploadActivity(ctx, uploader, log, activity)
+			if report != nil {
+				report(result, err)
+			}
+		}
+		if next == nil {
+			return true
+		}
+		return next(activity)
+	}
+}
+
+// UploadStored uploads the stored files of manifest entries (e.g. the
+// Activities of a ManifestState) with UploadActivity, in order. Each
+// outcome is passed to report (if not nil); failures are joined in the
+// returned error, a cancelled ctx stops with ctx.Err().
+func UploadStored(ctx context.Context, uploader Uploader, log *UploadLog, storage Storage, entries []ManifestEntry, report func(UploadResult, error)) error {
+	var errs []error
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if log != nil {
+			if _, ok := log.Uploaded(uploader.Name(), entry.RideID); ok {
+				continue
+			}
+		}
+		ext, err := ParseExtension(entry.Format)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		data, err := storage.Get(ctx, entry.RideID, ext)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error reading activity %d: %v", entry.RideID, err))
+			continue
+		}
+		activity := &DownloadedActivity{
+			RideID:    entry.RideID,
+			Title:     entry.Title,
+			StartTime: entry.StartTime,
+			Extension: ext,
+			Data:      data,
+		}
+		result, err := UploadActivity(ctx, uploader, log, activity)
+		if report != nil {
+			report(result, err)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
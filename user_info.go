@@ -11,7 +11,7 @@ const USER_INFO_URL = BASE_URL + "mobile/api/User/UserInfo"
 // A UserInfo http wrapper function
 func (s *IgpsportSync) GetUserInfo() (*UserInfoResponse, error) {
 	// Create HTTP request
-	req, err := http.NewRequest("GET", USER_INFO_URL, nil)
+	req, err := http.NewRequest("GET", s.url(USER_INFO_URL), nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating user info request: %v", err)
 	}